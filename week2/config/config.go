@@ -0,0 +1,359 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Config webook 的全部配置
+// 先从 YAML 文件里面读，再用环境变量覆盖，密码和密钥这类东西建议只放在环境变量里
+type Config struct {
+	Server   ServerConfig   `yaml:"server"`
+	DB       DBConfig       `yaml:"db"`
+	Redis    RedisConfig    `yaml:"redis"`
+	Session  SessionConfig  `yaml:"session"`
+	JWT      JWTConfig      `yaml:"jwt"`
+	Auth     AuthConfig     `yaml:"auth"`
+	Cache    CacheConfig    `yaml:"cache"`
+	Blob     BlobConfig     `yaml:"blob"`
+	Email    EmailConfig    `yaml:"email"`
+	Password PasswordConfig `yaml:"password"`
+	Admin    AdminConfig    `yaml:"admin"`
+	SMS      SMSConfig      `yaml:"sms"`
+}
+
+type ServerConfig struct {
+	// 监听地址，比如 :8080
+	Addr string `yaml:"addr" env:"WEBOOK_SERVER_ADDR"`
+	// 信任哪些反向代理转发过来的 X-Forwarded-For，IP 或者 CIDR
+	// 默认谁都不信任，不然客户端随便改个请求头就能绕过按照 IP 的限流
+	TrustedProxies []string `yaml:"trustedProxies"`
+}
+
+type DBConfig struct {
+	// 数据库类型，mysql 或者 sqlite，sqlite 的 dsn 就是数据库文件的路径
+	Driver string `yaml:"driver" env:"WEBOOK_DB_DRIVER"`
+	DSN    string `yaml:"dsn" env:"WEBOOK_DB_DSN"`
+}
+
+type RedisConfig struct {
+	Addr     string `yaml:"addr" env:"WEBOOK_REDIS_ADDR"`
+	Password string `yaml:"password" env:"WEBOOK_REDIS_PASSWORD"`
+	// session 用的连接池大小
+	MaxIdle int `yaml:"maxIdle" env:"WEBOOK_REDIS_MAX_IDLE"`
+}
+
+type SessionConfig struct {
+	// 签名 cookie 用的密钥
+	AuthKey string `yaml:"authKey" env:"WEBOOK_SESSION_AUTH_KEY"`
+	// 加密 cookie 用的密钥，长度必须是 16、24 或者 32
+	EncryptionKey string `yaml:"encryptionKey" env:"WEBOOK_SESSION_ENCRYPTION_KEY"`
+}
+
+type JWTConfig struct {
+	Key string `yaml:"key" env:"WEBOOK_JWT_KEY"`
+}
+
+type AuthConfig struct {
+	// 登录方式，session 用 cookie 保存登录状态，jwt 在 x-jwt-token 响应头里面返回 token
+	Mode string `yaml:"mode" env:"WEBOOK_AUTH_MODE"`
+}
+
+type CacheConfig struct {
+	// 验证码和用户信息的缓存放在哪里，memory 或者 redis
+	Type string `yaml:"type" env:"WEBOOK_CACHE_TYPE"`
+}
+
+type BlobConfig struct {
+	// 头像这类文件存在本地的哪个目录
+	Dir string `yaml:"dir" env:"WEBOOK_BLOB_DIR"`
+	// 访问文件的 URL 前缀，以 / 开头的话由 webook 自己提供文件下载，也可以是 CDN 的地址
+	URLPrefix string `yaml:"urlPrefix" env:"WEBOOK_BLOB_URL_PREFIX"`
+}
+
+type EmailConfig struct {
+	// 本地开发不真的发邮件，邮件写到这个目录下面
+	OutboxDir string `yaml:"outboxDir" env:"WEBOOK_EMAIL_OUTBOX_DIR"`
+	// 签名验证邮箱链接用的密钥，不要和 jwt.key 一样
+	VerifyKey string `yaml:"verifyKey" env:"WEBOOK_EMAIL_VERIFY_KEY"`
+	// 验证邮箱的链接，token 会拼在查询参数里面
+	VerifyURL string `yaml:"verifyURL" env:"WEBOOK_EMAIL_VERIFY_URL"`
+}
+
+type PasswordConfig struct {
+	// 密码最短和最长多少个字符，一个中文和一个英文字母一样
+	MinLength int `yaml:"minLength" env:"WEBOOK_PASSWORD_MIN_LENGTH"`
+	MaxLength int `yaml:"maxLength" env:"WEBOOK_PASSWORD_MAX_LENGTH"`
+	// 必须包含的字符类型：lower、upper、digit、symbol
+	RequireClasses []string `yaml:"requireClasses"`
+	// 至少包含几类字符，0 表示不限制
+	MinClasses int `yaml:"minClasses" env:"WEBOOK_PASSWORD_MIN_CLASSES"`
+	// 估算的强度至少多少 bit
+	MinEntropy int `yaml:"minEntropy" env:"WEBOOK_PASSWORD_MIN_ENTROPY"`
+	// 额外的弱密码列表，一行一个，内置的常见弱密码总是生效
+	BannedFile string     `yaml:"bannedFile" env:"WEBOOK_PASSWORD_BANNED_FILE"`
+	Hash       HashConfig `yaml:"hash"`
+}
+
+// HashConfig 加密密码用的算法和参数，调整之后老用户下次登录的时候自动重新加密
+type HashConfig struct {
+	// bcrypt 或者 argon2id
+	Algorithm  string `yaml:"algorithm" env:"WEBOOK_PASSWORD_HASH_ALGORITHM"`
+	BcryptCost int    `yaml:"bcryptCost" env:"WEBOOK_PASSWORD_HASH_BCRYPT_COST"`
+	// argon2id 的迭代次数、内存（单位 KiB）和并行度
+	Argon2Time    int `yaml:"argon2Time" env:"WEBOOK_PASSWORD_HASH_ARGON2_TIME"`
+	Argon2Memory  int `yaml:"argon2Memory" env:"WEBOOK_PASSWORD_HASH_ARGON2_MEMORY"`
+	Argon2Threads int `yaml:"argon2Threads" env:"WEBOOK_PASSWORD_HASH_ARGON2_THREADS"`
+}
+
+type SMSConfig struct {
+	// 短信供应商，memory 不真的发短信，http 调用短信网关
+	Provider string `yaml:"provider" env:"WEBOOK_SMS_PROVIDER"`
+	// memory 最多记住最近多少条短信
+	MemoryCapacity int `yaml:"memoryCapacity" env:"WEBOOK_SMS_MEMORY_CAPACITY"`
+	// memory 在日志里面打印验证码，只有开发环境才能打开
+	LogCodes bool `yaml:"logCodes" env:"WEBOOK_SMS_LOG_CODES"`
+	// 短信网关的地址和鉴权用的 token
+	GatewayURL   string `yaml:"gatewayURL" env:"WEBOOK_SMS_GATEWAY_URL"`
+	GatewayToken string `yaml:"gatewayToken" env:"WEBOOK_SMS_GATEWAY_TOKEN"`
+}
+
+type AdminConfig struct {
+	// 哪些用户是管理员，可以提前解锁登录失败太多次被锁定的账号
+	Uids []int64 `yaml:"uids"`
+}
+
+// passwordClasses 密码的字符类型
+var passwordClasses = map[string]bool{
+	"lower":  true,
+	"upper":  true,
+	"digit":  true,
+	"symbol": true,
+}
+
+const (
+	DBDriverMySQL  = "mysql"
+	DBDriverSQLite = "sqlite"
+)
+
+const (
+	HashBcrypt   = "bcrypt"
+	HashArgon2id = "argon2id"
+)
+
+const (
+	SMSProviderMemory = "memory"
+	SMSProviderHTTP   = "http"
+)
+
+const (
+	AuthModeSession = "session"
+	AuthModeJWT     = "jwt"
+)
+
+const (
+	CacheTypeMemory = "memory"
+	CacheTypeRedis  = "redis"
+)
+
+// ProfileEnv 用这个环境变量指定用哪个配置文件，比如 dev、k8s
+const ProfileEnv = "WEBOOK_PROFILE"
+
+// ProfilePath 返回 profile 对应的配置文件路径，没有指定 profile 就用 dev
+func ProfilePath(profile string) string {
+	if profile == "" {
+		profile = "dev"
+	}
+	return fmt.Sprintf("config/%s.yaml", profile)
+}
+
+// Load 读取配置文件，用环境变量覆盖之后做校验
+func Load(path string) (Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("读取配置文件 %s 失败: %w", path, err)
+	}
+	if err = yaml.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("解析配置文件 %s 失败: %w", path, err)
+	}
+	if err = applyEnv(reflect.ValueOf(&cfg).Elem()); err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.Validate()
+}
+
+// Validate 启动的时候检查配置，有问题直接报出来，不要等到用的时候才发现
+func (c Config) Validate() error {
+	var errs []error
+	if c.Server.Addr == "" {
+		errs = append(errs, errors.New("server.addr 不能为空"))
+	}
+	for _, proxy := range c.Server.TrustedProxies {
+		if !validProxy(proxy) {
+			errs = append(errs, fmt.Errorf("server.trustedProxies 里面的 %s 不是合法的 IP 或者 CIDR", proxy))
+		}
+	}
+	switch c.DB.Driver {
+	case DBDriverMySQL, DBDriverSQLite:
+	default:
+		errs = append(errs, fmt.Errorf("db.driver 只能是 %s 或者 %s", DBDriverMySQL, DBDriverSQLite))
+	}
+	if c.DB.DSN == "" {
+		errs = append(errs, errors.New("db.dsn 不能为空"))
+	}
+	// 本地缓存的时候用不到 Redis
+	if c.Cache.Type == CacheTypeRedis {
+		if c.Redis.Addr == "" {
+			errs = append(errs, errors.New("redis.addr 不能为空"))
+		}
+		if c.Redis.MaxIdle <= 0 {
+			errs = append(errs, errors.New("redis.maxIdle 必须大于 0"))
+		}
+	}
+	if c.Session.AuthKey == "" {
+		errs = append(errs, errors.New("session.authKey 不能为空"))
+	}
+	switch len(c.Session.EncryptionKey) {
+	case 16, 24, 32:
+	default:
+		errs = append(errs, errors.New("session.encryptionKey 的长度必须是 16、24 或者 32"))
+	}
+	if c.JWT.Key == "" {
+		errs = append(errs, errors.New("jwt.key 不能为空"))
+	} else if c.JWT.Key == c.Session.AuthKey || c.JWT.Key == c.Session.EncryptionKey {
+		// 一个密钥泄露了不能连带着另一个也不安全
+		errs = append(errs, errors.New("jwt.key 不能和 session 的密钥一样"))
+	}
+	switch c.Auth.Mode {
+	case AuthModeSession, AuthModeJWT:
+	default:
+		errs = append(errs, fmt.Errorf("auth.mode 只能是 %s 或者 %s", AuthModeSession, AuthModeJWT))
+	}
+	switch c.Cache.Type {
+	case CacheTypeMemory, CacheTypeRedis:
+	default:
+		errs = append(errs, fmt.Errorf("cache.type 只能是 %s 或者 %s", CacheTypeMemory, CacheTypeRedis))
+	}
+	if c.Blob.Dir == "" {
+		errs = append(errs, errors.New("blob.dir 不能为空"))
+	}
+	if c.Blob.URLPrefix == "" {
+		errs = append(errs, errors.New("blob.urlPrefix 不能为空"))
+	}
+	if c.Email.OutboxDir == "" {
+		errs = append(errs, errors.New("email.outboxDir 不能为空"))
+	}
+	if c.Email.VerifyKey == "" {
+		errs = append(errs, errors.New("email.verifyKey 不能为空"))
+	} else if c.Email.VerifyKey == c.JWT.Key {
+		errs = append(errs, errors.New("email.verifyKey 不能和 jwt.key 一样"))
+	}
+	if c.Email.VerifyURL == "" {
+		errs = append(errs, errors.New("email.verifyURL 不能为空"))
+	}
+	if c.Password.MinLength <= 0 {
+		errs = append(errs, errors.New("password.minLength 必须大于 0"))
+	}
+	if c.Password.MaxLength < c.Password.MinLength {
+		errs = append(errs, errors.New("password.maxLength 不能小于 password.minLength"))
+	}
+	for _, class := range c.Password.RequireClasses {
+		if !passwordClasses[class] {
+			errs = append(errs, fmt.Errorf("password.requireClasses 不支持 %s，只能是 lower、upper、digit、symbol", class))
+		}
+	}
+	if c.Password.MinClasses < 0 || c.Password.MinClasses > len(passwordClasses) {
+		errs = append(errs, fmt.Errorf("password.minClasses 只能是 0 到 %d", len(passwordClasses)))
+	}
+	if c.Password.MinEntropy < 0 {
+		errs = append(errs, errors.New("password.minEntropy 不能小于 0"))
+	}
+	hash := c.Password.Hash
+	switch hash.Algorithm {
+	case HashBcrypt:
+		// bcrypt 的成本因子只能是 4 到 31
+		if hash.BcryptCost < 4 || hash.BcryptCost > 31 {
+			errs = append(errs, errors.New("password.hash.bcryptCost 只能是 4 到 31"))
+		}
+	case HashArgon2id:
+		if hash.Argon2Time <= 0 || hash.Argon2Memory <= 0 || hash.Argon2Threads <= 0 || hash.Argon2Threads > 255 {
+			errs = append(errs, errors.New("password.hash 的 argon2Time、argon2Memory 必须大于 0，argon2Threads 只能是 1 到 255"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("password.hash.algorithm 只能是 %s 或者 %s", HashBcrypt, HashArgon2id))
+	}
+	switch c.SMS.Provider {
+	case SMSProviderMemory:
+		if c.SMS.MemoryCapacity <= 0 {
+			errs = append(errs, errors.New("sms.memoryCapacity 必须大于 0"))
+		}
+	case SMSProviderHTTP:
+		if c.SMS.GatewayURL == "" {
+			errs = append(errs, errors.New("sms.gatewayURL 不能为空"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("sms.provider 只能是 %s 或者 %s", SMSProviderMemory, SMSProviderHTTP))
+	}
+	for _, uid := range c.Admin.Uids {
+		if uid <= 0 {
+			errs = append(errs, fmt.Errorf("admin.uids 里面的 %d 不是合法的用户 id", uid))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func validProxy(proxy string) bool {
+	if strings.Contains(proxy, "/") {
+		_, _, err := net.ParseCIDR(proxy)
+		return err == nil
+	}
+	return net.ParseIP(proxy) != nil
+}
+
+// applyEnv 用 env 标签指定的环境变量覆盖配置，没有设置的环境变量不覆盖
+func applyEnv(val reflect.Value) error {
+	typ := val.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := val.Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := applyEnv(field); err != nil {
+				return err
+			}
+			continue
+		}
+		name := typ.Field(i).Tag.Get("env")
+		if name == "" {
+			continue
+		}
+		env, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(strings.TrimSpace(env))
+		case reflect.Int:
+			n, err := strconv.Atoi(strings.TrimSpace(env))
+			if err != nil {
+				return fmt.Errorf("环境变量 %s 必须是整数: %w", name, err)
+			}
+			field.SetInt(int64(n))
+		case reflect.Bool:
+			b, err := strconv.ParseBool(strings.TrimSpace(env))
+			if err != nil {
+				return fmt.Errorf("环境变量 %s 必须是 true 或者 false: %w", name, err)
+			}
+			field.SetBool(b)
+		default:
+			return fmt.Errorf("环境变量 %s 对应的字段类型 %s 不支持", name, field.Kind())
+		}
+	}
+	return nil
+}
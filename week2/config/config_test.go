@@ -0,0 +1,198 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// withSecrets 配置文件里面没有的密钥，都从环境变量注入
+func withSecrets(env map[string]string) map[string]string {
+	res := map[string]string{
+		"WEBOOK_SESSION_AUTH_KEY":       "auth",
+		"WEBOOK_SESSION_ENCRYPTION_KEY": "0123456789abcdef",
+		"WEBOOK_JWT_KEY":                "jwt",
+		"WEBOOK_EMAIL_VERIFY_KEY":       "email",
+	}
+	for k, v := range env {
+		res[k] = v
+	}
+	return res
+}
+
+// devEnv dev 环境的数据库地址也从环境变量注入
+func devEnv(env map[string]string) map[string]string {
+	res := withSecrets(env)
+	if _, ok := res["WEBOOK_DB_DSN"]; !ok {
+		res["WEBOOK_DB_DSN"] = "root:root@tcp(localhost:3306)/webook"
+	}
+	return res
+}
+
+func TestLoad(t *testing.T) {
+	testCases := []struct {
+		name    string
+		path    string
+		env     map[string]string
+		wantErr bool
+		check   func(t *testing.T, cfg Config)
+	}{
+		{
+			name: "dev",
+			path: "dev.yaml",
+			env:  devEnv(nil),
+			check: func(t *testing.T, cfg Config) {
+				assert.Equal(t, ":8080", cfg.Server.Addr)
+				assert.Equal(t, CacheTypeMemory, cfg.Cache.Type)
+				assert.Equal(t, 8, cfg.Password.MinLength)
+				assert.Equal(t, HashArgon2id, cfg.Password.Hash.Algorithm)
+				assert.Equal(t, SMSProviderMemory, cfg.SMS.Provider)
+				assert.True(t, cfg.SMS.LogCodes)
+				assert.Equal(t, AuthModeSession, cfg.Auth.Mode)
+				// 默认不信任任何代理
+				assert.Empty(t, cfg.Server.TrustedProxies)
+			},
+		},
+		{
+			name: "环境变量切换成 JWT 登录",
+			path: "dev.yaml",
+			env: devEnv(map[string]string{
+				"WEBOOK_AUTH_MODE": "jwt",
+			}),
+			check: func(t *testing.T, cfg Config) {
+				assert.Equal(t, AuthModeJWT, cfg.Auth.Mode)
+			},
+		},
+		{
+			name: "布尔类型的环境变量",
+			path: "dev.yaml",
+			env: devEnv(map[string]string{
+				"WEBOOK_SMS_LOG_CODES": "false",
+			}),
+			check: func(t *testing.T, cfg Config) {
+				assert.False(t, cfg.SMS.LogCodes)
+			},
+		},
+		{
+			name: "k8s 密钥从环境变量读取",
+			path: "k8s.yaml",
+			env: withSecrets(map[string]string{
+				"WEBOOK_REDIS_MAX_IDLE":  "32",
+				"WEBOOK_SMS_GATEWAY_URL": "http://sms-gateway/send",
+			}),
+			check: func(t *testing.T, cfg Config) {
+				assert.Equal(t, ":8081", cfg.Server.Addr)
+				assert.Equal(t, "webook-redis:6380", cfg.Redis.Addr)
+				assert.Equal(t, 32, cfg.Redis.MaxIdle)
+				assert.Equal(t, "jwt", cfg.JWT.Key)
+				assert.Equal(t, "/data/uploads", cfg.Blob.Dir)
+				assert.Equal(t, SMSProviderHTTP, cfg.SMS.Provider)
+				assert.Equal(t, "http://sms-gateway/send", cfg.SMS.GatewayURL)
+			},
+		},
+		{
+			name: "local 使用 SQLite",
+			path: "local.yaml",
+			env:  withSecrets(nil),
+			check: func(t *testing.T, cfg Config) {
+				assert.Equal(t, DBDriverSQLite, cfg.DB.Driver)
+				// 本地缓存不需要配置 Redis
+				assert.Equal(t, CacheTypeMemory, cfg.Cache.Type)
+				assert.Empty(t, cfg.Redis.Addr)
+			},
+		},
+		{
+			name: "local 换成 Redis 缓存要配置 Redis",
+			path: "local.yaml",
+			env: withSecrets(map[string]string{
+				"WEBOOK_CACHE_TYPE": "redis",
+			}),
+			wantErr: true,
+		},
+		{
+			name:    "k8s 没有设置密钥",
+			path:    "k8s.yaml",
+			wantErr: true,
+		},
+		{
+			name:    "dev 没有设置密钥",
+			path:    "dev.yaml",
+			wantErr: true,
+		},
+		{
+			name: "JWT 密钥和 session 密钥一样",
+			path: "dev.yaml",
+			env: devEnv(map[string]string{
+				"WEBOOK_JWT_KEY": "auth",
+			}),
+			wantErr: true,
+		},
+		{
+			name: "环境变量不是整数",
+			path: "dev.yaml",
+			env: devEnv(map[string]string{
+				"WEBOOK_REDIS_MAX_IDLE": "abc",
+			}),
+			wantErr: true,
+		},
+		{
+			name: "环境变量不是布尔值",
+			path: "dev.yaml",
+			env: devEnv(map[string]string{
+				"WEBOOK_SMS_LOG_CODES": "abc",
+			}),
+			wantErr: true,
+		},
+		{
+			name:    "配置文件不存在",
+			path:    "prod.yaml",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+			cfg, err := Load(tc.path)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			tc.check(t, cfg)
+		})
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+server:
+  addr: ":8080"
+  trustedProxies: ["10.0.0.0/8", "proxy"]
+session:
+  encryptionKey: "short"
+auth:
+  mode: oauth
+cache:
+  type: mongo
+password:
+  maxLength: -1
+  requireClasses: [lower, emoji]
+admin:
+  uids: [1, 0]
+`), 0644))
+	_, err := Load(path)
+	require.Error(t, err)
+	// 所有的问题一次性报出来
+	for _, field := range []string{"server.trustedProxies", "db.driver", "db.dsn", "session.authKey",
+		"session.encryptionKey", "jwt.key", "auth.mode", "cache.type", "blob.dir", "blob.urlPrefix",
+		"email.outboxDir", "email.verifyKey", "email.verifyURL", "password.minLength",
+		"password.hash.algorithm", "sms.provider", "admin.uids"} {
+		assert.Contains(t, err.Error(), field)
+	}
+}
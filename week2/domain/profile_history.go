@@ -0,0 +1,16 @@
+package domain
+
+import "time"
+
+// ProfileChange 个人信息某一个字段的一次修改
+type ProfileChange struct {
+	Id  int64
+	Uid int64
+	// 改的是哪个字段，比如 nickname
+	Field  string
+	Before string
+	After  string
+	// 谁改的，用户自己改就是用户自己的 id
+	Actor int64
+	Ctime time.Time
+}
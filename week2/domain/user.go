@@ -1,17 +1,107 @@
 package domain
 
+import (
+	"errors"
+	"time"
+)
+
 //User 领域对象，是DDD中的entity
 //BO(business object)
 
 type User struct {
-	Id       int64
-	Email    string
-	Password string
+	Id    int64
+	Email string
+	// 邮箱有没有验证过，注册之后要点了验证邮件里面的链接才算验证过
+	EmailVerified bool
+	Password      string
+	Phone         string
 
 	//添加如下字段，用户昵称，生日和个人简介
 	Nickname string
-	Birthday string
+	// 生日，只有日期部分有意义，零值表示没有填
+	Birthday time.Time
 	Abstract string
-	Ctime    string
-	Utime    string
+	// 时区偏好，IANA 时区名，比如 Asia/Shanghai，空的表示用默认时区
+	Timezone string
+	// 头像在文件存储里面的 key，空的表示没有上传过头像
+	Avatar string
+	// 个人信息的版本号，每次修改加一
+	Version int64
+	Ctime   time.Time
+	Utime   time.Time
+}
+
+// UserProfilePatch 部分修改个人信息，nil 表示不修改这个字段，
+// 指向零值表示清空，比如 Abstract 指向空字符串就是清空个人简介，Birthday 指向零值就是清空生日
+type UserProfilePatch struct {
+	Nickname *string
+	Birthday *time.Time
+	Abstract *string
+	Timezone *string
+	// Avatar 头像在文件存储里面的 key
+	Avatar *string
+	// Version 期望的版本号，和当前的版本号不一致就不修改，0 表示不检查
+	Version int64
+	// Actor 谁改的，0 表示用户自己改的
+	Actor int64
+}
+
+// IsEmpty 一个字段都没有修改
+func (p UserProfilePatch) IsEmpty() bool {
+	return p.Nickname == nil && p.Birthday == nil && p.Abstract == nil && p.Timezone == nil &&
+		p.Avatar == nil
+}
+
+const (
+	// BirthdayLayout 生日的格式，例如 1992-01-01
+	BirthdayLayout = "2006-01-02"
+	// 允许的最大年龄
+	maxAge = 150
+)
+
+var (
+	ErrInvalidBirthday  = errors.New("生日不是一个合法的日期")
+	ErrBirthdayInFuture = errors.New("生日不能晚于今天")
+	ErrBirthdayTooEarly = errors.New("生日太早了")
+)
+
+// ParseBirthday 解析并校验生日，2024-13-45、2023-02-29 这种日期会解析失败
+// 生日不能晚于 now 所在的那天，年龄也不能超过 150 岁
+func ParseBirthday(s string, now time.Time) (time.Time, error) {
+	birthday, err := time.Parse(BirthdayLayout, s)
+	if err != nil {
+		return time.Time{}, ErrInvalidBirthday
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if birthday.After(today) {
+		return time.Time{}, ErrBirthdayInFuture
+	}
+	if ageAt(birthday, now) > maxAge {
+		return time.Time{}, ErrBirthdayTooEarly
+	}
+	return birthday, nil
+}
+
+// EmailUnverified 填了邮箱但是还没有验证，手机号注册的用户没有邮箱，不算
+func (u User) EmailUnverified() bool {
+	return u.Email != "" && !u.EmailVerified
+}
+
+// Age 用户在 now 这一天的周岁，没有填生日返回 -1
+// 可以用来做和年龄有关的策略，比如未成年人限制
+func (u User) Age(now time.Time) int {
+	if u.Birthday.IsZero() {
+		return -1
+	}
+	return ageAt(u.Birthday, now)
+}
+
+func ageAt(birthday, now time.Time) int {
+	age := now.Year() - birthday.Year()
+	// 今年的生日还没到
+	if now.Month() < birthday.Month() ||
+		(now.Month() == birthday.Month() && now.Day() < birthday.Day()) {
+		age--
+	}
+	return age
 }
@@ -0,0 +1,102 @@
+package domain
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestParseBirthday(t *testing.T) {
+	now := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name     string
+		birthday string
+		want     time.Time
+		wantErr  error
+	}{
+		{
+			name:     "合法的生日",
+			birthday: "1992-01-01",
+			want:     time.Date(1992, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "今天出生",
+			birthday: "2024-03-01",
+			want:     time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "闰年的 2 月 29 日",
+			birthday: "2000-02-29",
+			want:     time.Date(2000, 2, 29, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "月份和日期不存在",
+			birthday: "2024-13-45",
+			wantErr:  ErrInvalidBirthday,
+		},
+		{
+			name:     "平年没有 2 月 29 日",
+			birthday: "2023-02-29",
+			wantErr:  ErrInvalidBirthday,
+		},
+		{
+			name:     "格式不对",
+			birthday: "1992/01/01",
+			wantErr:  ErrInvalidBirthday,
+		},
+		{
+			name:     "明天",
+			birthday: "2024-03-02",
+			wantErr:  ErrBirthdayInFuture,
+		},
+		{
+			name:     "超过 150 岁",
+			birthday: "1873-01-01",
+			wantErr:  ErrBirthdayTooEarly,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseBirthday(tc.birthday, now)
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestUser_Age(t *testing.T) {
+	now := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name     string
+		birthday time.Time
+		want     int
+	}{
+		{
+			name: "没有填生日",
+			want: -1,
+		},
+		{
+			name:     "今年生日已经过了",
+			birthday: time.Date(1992, 1, 1, 0, 0, 0, 0, time.UTC),
+			want:     32,
+		},
+		{
+			name:     "今天生日",
+			birthday: time.Date(1992, 3, 1, 0, 0, 0, 0, time.UTC),
+			want:     32,
+		},
+		{
+			name:     "今年生日还没到",
+			birthday: time.Date(1992, 3, 2, 0, 0, 0, 0, time.UTC),
+			want:     31,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			u := User{Birthday: tc.birthday}
+			assert.Equal(t, tc.want, u.Age(now))
+		})
+	}
+}
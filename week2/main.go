@@ -1,38 +1,211 @@
 package main
 
 import (
+	"awesomeProject/webook/internal/config"
+	"awesomeProject/webook/internal/migrator"
+	"awesomeProject/webook/internal/ratelimit"
 	"awesomeProject/webook/internal/repository"
+	"awesomeProject/webook/internal/repository/cache"
 	"awesomeProject/webook/internal/repository/dao"
 	"awesomeProject/webook/internal/service"
+	"awesomeProject/webook/internal/service/blob/local"
+	"awesomeProject/webook/internal/service/email/outbox"
+	"awesomeProject/webook/internal/service/password"
+	"awesomeProject/webook/internal/service/sms"
+	"awesomeProject/webook/internal/service/sms/gateway"
+	"awesomeProject/webook/internal/service/sms/memory"
 	"awesomeProject/webook/internal/web"
 	"awesomeProject/webook/internal/web/middleware"
+	"context"
+	"flag"
+	"fmt"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/memstore"
 	"github.com/gin-contrib/sessions/redis"
 	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	goredis "github.com/redis/go-redis/v9"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
+	"net/http"
+	"os"
 	"strings"
+	"text/tabwriter"
 	"time"
+	// 把时区数据编译进来，容器里面没有 /usr/share/zoneinfo 也能加载用户的时区
+	_ "time/tzdata"
 )
 
 func main() {
-	db := initDB()
-	server := initWebServer()
-	u := initUser(db)
+	cfg := initConfig()
+	db := initDB(cfg.DB)
+	// go run . migrate up|down|status
+	if flag.Arg(0) == "migrate" {
+		runMigrate(db, flag.Arg(1))
+		return
+	}
+	err := dao.InitTable(db)
+	if err != nil {
+		panic(err)
+	}
+	redisClient := initRedis(cfg)
+	sessionSvc := initSessionSvc(redisClient, cfg.Cache)
+	server := initWebServer(cfg, redisClient, sessionSvc)
+	loginGuardSvc := initLoginGuardSvc(redisClient, cfg.Cache)
+	u := initUser(db, redisClient, sessionSvc, loginGuardSvc, cfg)
 	u.RegisterRoutes(server)
-	server.Run(":8080")
+	web.NewAdminHandler(loginGuardSvc, cfg.Admin.Uids).RegisterRoutes(server)
+	server.Run(cfg.Server.Addr)
+}
+
+// initConfig 读取配置，-config 指定配置文件，不指定就按照 WEBOOK_PROFILE 选择 config 目录下的文件
+func initConfig() config.Config {
+	path := flag.String("config", config.ProfilePath(os.Getenv(config.ProfileEnv)), "配置文件路径")
+	flag.Parse()
+	cfg, err := config.Load(*path)
+	if err != nil {
+		panic(err)
+	}
+	return cfg
+}
+
+// initRedis 本地缓存的时候不需要 Redis，返回 nil
+func initRedis(cfg config.Config) goredis.Cmdable {
+	if cfg.Cache.Type != config.CacheTypeRedis {
+		return nil
+	}
+	return goredis.NewClient(&goredis.Options{
+		Addr:     cfg.Redis.Addr,
+		Password: cfg.Redis.Password,
+	})
 }
 
-func initUser(db *gorm.DB) *web.UserHandler {
+func initUser(db *gorm.DB, redisClient goredis.Cmdable, sessionSvc service.SessionService,
+	loginGuardSvc service.LoginGuardService, cfg config.Config) *web.UserHandler {
 	ud := dao.NewUserDAO(db)
-	repo := repository.NewUserRepository(ud)
-	svc := service.NewUserService(repo)
-	u := web.NewUserHandler(svc)
+	var uc cache.UserCache = cache.NewUserMemoryCache()
+	if cfg.Cache.Type == config.CacheTypeRedis {
+		uc = cache.NewUserRedisCache(redisClient)
+	}
+	repo := repository.NewUserRepository(ud, uc)
+	svc := service.NewUserService(repo, initPasswordPolicy(cfg.Password), initPasswordHasher(cfg.Password.Hash))
+	codeRepo := initCodeRepo(redisClient, cfg.Cache)
+	smsSvc := initSMS(cfg.SMS)
+	codeSvc := service.NewCodeService(codeRepo, smsSvc)
+	emailSvc := outbox.NewService(cfg.Email.OutboxDir)
+	avatarSvc := service.NewAvatarService(repo, local.NewStorage(cfg.Blob.Dir, cfg.Blob.URLPrefix))
+	emailVerifySvc := service.NewEmailVerifyService(repo, emailSvc,
+		[]byte(cfg.Email.VerifyKey), cfg.Email.VerifyURL)
+	passwordResetSvc := service.NewPasswordResetService(repo, codeRepo, svc, sessionSvc, smsSvc, emailSvc)
+	u := web.NewUserHandler(svc, codeSvc).WithJWTKey([]byte(cfg.JWT.Key)).
+		WithAvatarService(avatarSvc).
+		WithEmailVerifyService(emailVerifySvc).
+		WithPasswordResetService(passwordResetSvc).
+		WithSessionService(sessionSvc).
+		WithLoginGuardService(loginGuardSvc)
+	if cfg.Auth.Mode == config.AuthModeJWT {
+		u.WithJWTLogin()
+	}
 	return u
 }
-func initWebServer() *gin.Engine {
+
+// initSMS 开发环境用本地短信，不依赖短信供应商，线上通过短信网关发送
+func initSMS(cfg config.SMSConfig) sms.Service {
+	if cfg.Provider == config.SMSProviderHTTP {
+		return gateway.NewService(cfg.GatewayURL, cfg.GatewayToken, &http.Client{Timeout: 5 * time.Second})
+	}
+	return memory.NewService(cfg.MemoryCapacity, cfg.LogCodes)
+}
+
+// initPasswordPolicy 按照配置生成密码策略，注册、修改密码、找回密码共用
+func initPasswordPolicy(cfg config.PasswordConfig) *password.Policy {
+	policy := password.NewPolicy(cfg.MinLength, cfg.MaxLength).
+		MinClasses(cfg.MinClasses).
+		MinEntropy(float64(cfg.MinEntropy))
+	for _, class := range cfg.RequireClasses {
+		policy.RequireClasses(password.Class(class))
+	}
+	if cfg.BannedFile != "" {
+		banned, err := password.ReadBannedFile(cfg.BannedFile)
+		if err != nil {
+			panic(err)
+		}
+		policy.Ban(banned...)
+	}
+	return policy
+}
+
+// initPasswordHasher 按照配置选择加密密码的算法，配置已经校验过了
+func initPasswordHasher(cfg config.HashConfig) password.Hasher {
+	if cfg.Algorithm == config.HashArgon2id {
+		params := password.DefaultArgon2idParams
+		params.Time = uint32(cfg.Argon2Time)
+		params.Memory = uint32(cfg.Argon2Memory)
+		params.Threads = uint8(cfg.Argon2Threads)
+		return password.NewArgon2idHasher(params)
+	}
+	return password.NewBcryptHasher(cfg.BcryptCost)
+}
+
+// initCodeRepo 登录和找回密码的验证码共用，按照 biz 区分
+func initCodeRepo(redisClient goredis.Cmdable, cfg config.CacheConfig) repository.CodeRepository {
+	// 默认用本地缓存，不依赖 Redis
+	var codeCache cache.CodeCache = cache.NewCodeMemoryCache()
+	if cfg.Type == config.CacheTypeRedis {
+		codeCache = cache.NewCodeRedisCache(redisClient)
+	}
+	return repository.NewCodeRepository(codeCache)
+}
+
+// initSessionSvc 登录校验和找回密码都要用，撤销记录要和验证码一样在多个实例之间共享
+func initSessionSvc(redisClient goredis.Cmdable, cfg config.CacheConfig) service.SessionService {
+	var sessionCache cache.SessionCache = cache.NewSessionMemoryCache()
+	if cfg.Type == config.CacheTypeRedis {
+		sessionCache = cache.NewSessionRedisCache(redisClient)
+	}
+	return service.NewSessionService(repository.NewSessionRepository(sessionCache))
+}
+
+// initLoginGuardSvc 失败次数要在多个实例之间共享，不然换个实例就能接着试
+func initLoginGuardSvc(redisClient goredis.Cmdable, cfg config.CacheConfig) service.LoginGuardService {
+	var attemptCache cache.LoginAttemptCache = cache.NewLoginAttemptMemoryCache()
+	if cfg.Type == config.CacheTypeRedis {
+		attemptCache = cache.NewLoginAttemptRedisCache(redisClient)
+	}
+	return service.NewLoginGuardService(repository.NewLoginAttemptRepository(attemptCache))
+}
+
+// initRateLimit 所有接口按照 IP 限流，注册、登录、发验证码这些容易被刷的接口再单独限流
+func initRateLimit(redisClient goredis.Cmdable, cfg config.CacheConfig) gin.HandlerFunc {
+	// 默认用本地限流，多实例部署的时候用 Redis 才能共享限额
+	slidingWindow := func(window time.Duration, rate int) ratelimit.Limiter {
+		if cfg.Type == config.CacheTypeRedis {
+			return ratelimit.NewSlidingWindowRedisLimiter(redisClient, window, rate)
+		}
+		return ratelimit.NewSlidingWindowMemoryLimiter(window, rate)
+	}
+	var limiter ratelimit.Limiter = ratelimit.NewTokenBucketMemoryLimiter(10*time.Millisecond, 100)
+	if cfg.Type == config.CacheTypeRedis {
+		limiter = ratelimit.NewTokenBucketRedisLimiter(redisClient, 10*time.Millisecond, 100)
+	}
+	return middleware.NewRateLimitBuilder().
+		KeyBy(middleware.KeyByIP).
+		// 平均每秒 100 个请求，允许突发 100 个
+		Limit(limiter).
+		Route("POST", "/users/signup", slidingWindow(10*time.Minute, 10)).
+		Route("POST", "/users/login", slidingWindow(time.Minute, 20)).
+		Route("POST", "/users/login_sms/code/send", slidingWindow(time.Minute, 5)).
+		Route("POST", "/users/password/reset/code", slidingWindow(time.Minute, 5)).
+		Build()
+}
+
+func initWebServer(cfg config.Config, redisClient goredis.Cmdable, sessionSvc service.SessionService) *gin.Engine {
 	server := gin.Default()
+	// ClientIP 只认信任的代理转发过来的 X-Forwarded-For，按照 IP 限流才靠得住
+	if err := server.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		panic(err)
+	}
 	server.Use(func(ctx *gin.Context) {
 		println("这是第一个middleware")
 	})
@@ -43,9 +216,11 @@ func initWebServer() *gin.Engine {
 	server.Use(cors.New(cors.Config{
 		//AllowOrigins: []string{"*"},
 		//AllowMethods: []string{"POST", "GET"},
-		AllowHeaders: []string{"Content-Type", "Authorization"},
+		// PATCH 不是简单请求，要在预检里面放行
+		AllowMethods: []string{"GET", "POST", "PATCH"},
+		AllowHeaders: []string{"Content-Type", "Authorization", "If-Match"},
 		// 你不加这个，前端是拿不到的
-		ExposeHeaders: []string{"x-jwt-token"},
+		ExposeHeaders: []string{"x-jwt-token", "ETag"},
 		//ExposeHeaders: []string{"x-jwt-token"},
 		// 是否允许你带 cookie 之类的东西
 		AllowCredentials: true,
@@ -58,6 +233,8 @@ func initWebServer() *gin.Engine {
 		},
 		MaxAge: 12 * time.Hour,
 	}))
+	// 这里还没有经过登录中间件，只能按照 IP 或者路由限流，KeyByUid 要注册在登录中间件后面
+	server.Use(initRateLimit(redisClient, cfg.Cache))
 
 	////步骤1
 	//store := cookie.NewStore([]byte("secret"))
@@ -66,29 +243,107 @@ func initWebServer() *gin.Engine {
 	////步骤3
 	//server.Use(middleware.NewLoginMiddlewareBuilder().IgnorePaths("/users/signup").
 	//	IgnorePaths("/users/login").Build())
-	//redis存储session
-	store, err := redis.NewStore(16, "tcp", "10.1.90.235:6379", "HzN8m%cr!Vve", []byte("95osj3fUD7fo0mlYdDbncXz4VD2igvf0"), []byte("0Pf2r0wZBpXVXlQNdpwCXN4ncnlnZSc3"))
-	if err != nil {
-		panic(err)
-	}
-	server.Use(sessions.Sessions("mysession", store))
+	// JWT 登录也保留 session 中间件，退出登录这些接口还会用到 session
+	server.Use(sessions.Sessions("mysession", initSessionStore(cfg)))
 
-	server.Use(middleware.NewLoginMiddlewareBuilder().IgnorePaths("/users/signup").
-		IgnorePaths("/users/login").Build())
-	//jwt
-	//server.Use(middleware.NewLoginJWTMiddlewareBuilder().IgnorePaths("/users/signup").
-	//	IgnorePaths("/users/login").Build())
+	server.Use(initLoginMiddleware(cfg, sessionSvc))
+	// 文件存在本地的时候自己提供下载，URL 前缀是 CDN 地址的话交给 CDN
+	if strings.HasPrefix(cfg.Blob.URLPrefix, "/") {
+		server.Static(cfg.Blob.URLPrefix, cfg.Blob.Dir)
+	}
 	return server
 }
 
-func initDB() *gorm.DB {
-	db, err := gorm.Open(mysql.Open("indigo:indigotest@tcp(10.1.80.122:3306)/go_test"))
+// initSessionStore 用 Redis 缓存的时候 session 也存在 Redis 里面，多个实例共享
+// 本地缓存的时候 session 存在内存里，不依赖 Redis，重启之后要重新登录
+func initSessionStore(cfg config.Config) sessions.Store {
+	if cfg.Cache.Type == config.CacheTypeRedis {
+		//redis存储session
+		store, err := redis.NewStore(cfg.Redis.MaxIdle, "tcp", cfg.Redis.Addr, cfg.Redis.Password,
+			[]byte(cfg.Session.AuthKey), []byte(cfg.Session.EncryptionKey))
+		if err != nil {
+			panic(err)
+		}
+		return store
+	}
+	return memstore.NewStore([]byte(cfg.Session.AuthKey), []byte(cfg.Session.EncryptionKey))
+}
+
+// publicPaths 不需要登录就能访问的接口
+var publicPaths = []string{
+	"/users/signup",
+	"/users/login",
+	"/users/login_sms/code/send",
+	"/users/login_sms",
+	"/users/verify_email",
+	"/users/password/reset/code",
+	"/users/password/reset",
+}
+
+// initLoginMiddleware 按照配置的登录方式校验 session 或者 JWT，两种方式都会检查有没有被撤销
+func initLoginMiddleware(cfg config.Config, sessionSvc service.SessionService) gin.HandlerFunc {
+	blobPrefix := strings.TrimSuffix(cfg.Blob.URLPrefix, "/") + "/"
+	if cfg.Auth.Mode == config.AuthModeJWT {
+		builder := middleware.NewLoginJWTMiddlewareBuilder([]byte(cfg.JWT.Key))
+		for _, path := range publicPaths {
+			builder.IgnorePaths(path)
+		}
+		return builder.IgnorePathPrefix(blobPrefix).CheckSession(sessionSvc).Build()
+	}
+	builder := middleware.NewLoginMiddlewareBuilder()
+	for _, path := range publicPaths {
+		builder.IgnorePaths(path)
+	}
+	return builder.IgnorePathPrefix(blobPrefix).CheckSession(sessionSvc).Build()
+}
+
+func initDB(cfg config.DBConfig) *gorm.DB {
+	var dialector gorm.Dialector
+	switch cfg.Driver {
+	case config.DBDriverSQLite:
+		// 纯 Go 实现的 SQLite，不需要 cgo，本地开发和测试不用装 MySQL
+		dialector = sqlite.Open(cfg.DSN)
+	default:
+		dialector = mysql.Open(cfg.DSN)
+	}
+	db, err := gorm.Open(dialector)
 	if err != nil {
 		panic(err)
 	}
-	err = dao.InitTable(db)
+	return db
+}
+
+func runMigrate(db *gorm.DB, cmd string) {
+	m := dao.NewMigrator(db)
+	ctx := context.Background()
+	var err error
+	switch cmd {
+	case "up":
+		err = m.Up(ctx)
+	case "down":
+		err = m.Down(ctx)
+	case "status":
+		var status []migrator.Status
+		status, err = m.Status(ctx)
+		// 出错了就不要打印一张空表
+		if err != nil {
+			break
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range status {
+			appliedAt := "pending"
+			if s.Applied {
+				appliedAt = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+		w.Flush()
+	default:
+		fmt.Println("用法: webook [-config 配置文件] migrate up|down|status")
+		os.Exit(2)
+	}
 	if err != nil {
 		panic(err)
 	}
-	return db
 }
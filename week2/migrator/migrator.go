@@ -0,0 +1,260 @@
+package migrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+)
+
+var (
+	ErrDuplicateVersion  = errors.New("迁移的版本号重复")
+	ErrNothingToRollback = errors.New("没有可以回滚的迁移")
+)
+
+const (
+	// 拿不到锁的时候，隔多久再试一次
+	defaultPollInterval = time.Second
+	// 锁超过这个时间没释放，认为持有锁的实例已经挂了
+	defaultLockTTL = 10 * time.Minute
+	// 锁表里面只有这一行
+	lockId = 1
+)
+
+// Migration 一次表结构变更，Version 必须递增，已经发布的 Migration 不要再修改
+type Migration struct {
+	Version int64
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// Status 一个迁移的执行情况
+type Status struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// SchemaMigration 记录已经执行过的迁移，也就是 schema 的版本
+type SchemaMigration struct {
+	Version int64 `gorm:"primaryKey;autoIncrement:false"`
+	Name    string
+	// 执行时间，毫秒数
+	AppliedAt int64
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// migrationLock 多个实例同时启动的时候，只有插入成功的那个实例能执行迁移
+type migrationLock struct {
+	Id    int64 `gorm:"primaryKey;autoIncrement:false"`
+	Owner string
+	// 加锁时间，毫秒数
+	LockedAt int64
+}
+
+func (migrationLock) TableName() string {
+	return "schema_migration_lock"
+}
+
+// Migrator 按照版本号顺序执行 Migration
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+	// 当前实例的标识，释放锁的时候只删自己的锁
+	owner        string
+	pollInterval time.Duration
+	lockTTL      time.Duration
+}
+
+func NewMigrator(db *gorm.DB, migrations []Migration) *Migrator {
+	ms := make([]Migration, len(migrations))
+	copy(ms, migrations)
+	sort.Slice(ms, func(i, j int) bool {
+		return ms[i].Version < ms[j].Version
+	})
+	host, _ := os.Hostname()
+	return &Migrator{
+		db:           db,
+		migrations:   ms,
+		owner:        fmt.Sprintf("%s-%d-%d", host, os.Getpid(), rand.Int63()),
+		pollInterval: defaultPollInterval,
+		lockTTL:      defaultLockTTL,
+	}
+}
+
+// Up 执行所有还没执行的迁移
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.withLock(ctx, func(db *gorm.DB) error {
+		applied, err := m.applied(db)
+		if err != nil {
+			return err
+		}
+		for _, mg := range m.migrations {
+			if _, ok := applied[mg.Version]; ok {
+				continue
+			}
+			// 一个迁移一个事务，注意 MySQL 的 DDL 会隐式提交，没办法跟着回滚
+			err = db.Transaction(func(tx *gorm.DB) error {
+				if err := mg.Up(tx); err != nil {
+					return err
+				}
+				return tx.Create(&SchemaMigration{
+					Version:   mg.Version,
+					Name:      mg.Name,
+					AppliedAt: time.Now().UnixMilli(),
+				}).Error
+			})
+			if err != nil {
+				return fmt.Errorf("执行迁移 %d_%s 失败: %w", mg.Version, mg.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Down 回滚最近执行的一个迁移
+func (m *Migrator) Down(ctx context.Context) error {
+	return m.withLock(ctx, func(db *gorm.DB) error {
+		applied, err := m.applied(db)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			mg := m.migrations[i]
+			if _, ok := applied[mg.Version]; !ok {
+				continue
+			}
+			err = db.Transaction(func(tx *gorm.DB) error {
+				if err := mg.Down(tx); err != nil {
+					return err
+				}
+				return tx.Where("version = ?", mg.Version).Delete(&SchemaMigration{}).Error
+			})
+			if err != nil {
+				return fmt.Errorf("回滚迁移 %d_%s 失败: %w", mg.Version, mg.Name, err)
+			}
+			return nil
+		}
+		return ErrNothingToRollback
+	})
+}
+
+// Status 返回所有迁移的执行情况
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	db := m.db.WithContext(ctx)
+	if err := m.ensureTables(db); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(db)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]Status, 0, len(m.migrations))
+	for _, mg := range m.migrations {
+		s := Status{
+			Version: mg.Version,
+			Name:    mg.Name,
+		}
+		if sm, ok := applied[mg.Version]; ok {
+			s.Applied = true
+			s.AppliedAt = time.UnixMilli(sm.AppliedAt)
+		}
+		res = append(res, s)
+	}
+	return res, nil
+}
+
+func (m *Migrator) applied(db *gorm.DB) (map[int64]SchemaMigration, error) {
+	var sms []SchemaMigration
+	if err := db.Find(&sms).Error; err != nil {
+		return nil, err
+	}
+	res := make(map[int64]SchemaMigration, len(sms))
+	for _, sm := range sms {
+		res[sm.Version] = sm
+	}
+	return res, nil
+}
+
+// withLock 拿到锁之后再执行 fn，保证同一时刻只有一个实例在改表结构
+func (m *Migrator) withLock(ctx context.Context, fn func(db *gorm.DB) error) error {
+	versions := make(map[int64]struct{}, len(m.migrations))
+	for _, mg := range m.migrations {
+		if _, ok := versions[mg.Version]; ok {
+			return fmt.Errorf("%w: %d", ErrDuplicateVersion, mg.Version)
+		}
+		versions[mg.Version] = struct{}{}
+	}
+	db := m.db.WithContext(ctx)
+	if err := m.ensureTables(db); err != nil {
+		return err
+	}
+	if err := m.lock(ctx, db); err != nil {
+		return err
+	}
+	defer m.unlock(db)
+	return fn(db)
+}
+
+// ensureTables 创建记录版本和锁的表，别的实例同时创建导致失败的话，再检查一次就行
+func (m *Migrator) ensureTables(db *gorm.DB) error {
+	for _, table := range []any{&SchemaMigration{}, &migrationLock{}} {
+		if db.Migrator().HasTable(table) {
+			continue
+		}
+		if err := db.Migrator().CreateTable(table); err != nil && !db.Migrator().HasTable(table) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) lock(ctx context.Context, db *gorm.DB) error {
+	for {
+		now := time.Now().UnixMilli()
+		err := db.Create(&migrationLock{
+			Id:       lockId,
+			Owner:    m.owner,
+			LockedAt: now,
+		}).Error
+		if err == nil {
+			return nil
+		}
+		if !m.isUniqueConflict(err) {
+			return err
+		}
+		// 别的实例拿着锁，如果锁太久没释放，说明那个实例执行到一半挂了，删掉之后重新抢
+		err = db.Where("id = ? AND locked_at < ?", lockId, now-m.lockTTL.Milliseconds()).
+			Delete(&migrationLock{}).Error
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(m.pollInterval):
+		}
+	}
+}
+
+func (m *Migrator) unlock(db *gorm.DB) {
+	// 用一个新的 context，就算调用方的 context 已经取消了也要释放锁
+	db.WithContext(context.Background()).
+		Where("id = ? AND owner = ?", lockId, m.owner).Delete(&migrationLock{})
+}
+
+func (m *Migrator) isUniqueConflict(err error) bool {
+	if translator, ok := m.db.Dialector.(gorm.ErrorTranslator); ok {
+		err = translator.Translate(err)
+	}
+	return errors.Is(err, gorm.ErrDuplicatedKey)
+}
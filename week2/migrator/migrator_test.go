@@ -0,0 +1,132 @@
+package migrator
+
+import (
+	"context"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type article struct {
+	Id    int64 `gorm:"primaryKey;autoIncrement"`
+	Title string
+}
+
+func testMigrations() []Migration {
+	return []Migration{
+		// 故意打乱顺序，Migrator 要按照版本号执行
+		{
+			Version: 2,
+			Name:    "insert_article",
+			Up: func(tx *gorm.DB) error {
+				return tx.Create(&article{Title: "hello"}).Error
+			},
+			Down: func(tx *gorm.DB) error {
+				return tx.Where("title = ?", "hello").Delete(&article{}).Error
+			},
+		},
+		{
+			Version: 1,
+			Name:    "create_articles",
+			Up: func(tx *gorm.DB) error {
+				return tx.Migrator().CreateTable(&article{})
+			},
+			Down: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable(&article{})
+			},
+		},
+	}
+}
+
+func openDB(t *testing.T, path string) *gorm.DB {
+	// busy_timeout 让并发写的时候等一会，而不是直接报 database is locked
+	db, err := gorm.Open(sqlite.Open(path + "?_pragma=busy_timeout(5000)"))
+	require.NoError(t, err)
+	return db
+}
+
+func TestMigrator_UpDownStatus(t *testing.T) {
+	db := openDB(t, filepath.Join(t.TempDir(), "webook.db"))
+	m := NewMigrator(db, testMigrations())
+	ctx := context.Background()
+
+	status, err := m.Status(ctx)
+	require.NoError(t, err)
+	assert.Len(t, status, 2)
+	assert.False(t, status[0].Applied)
+
+	require.NoError(t, m.Up(ctx))
+	// 重复执行不会出问题
+	require.NoError(t, m.Up(ctx))
+	var cnt int64
+	require.NoError(t, db.Model(&article{}).Count(&cnt).Error)
+	assert.Equal(t, int64(1), cnt)
+	status, err = m.Status(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), status[0].Version)
+	assert.True(t, status[0].Applied)
+	assert.True(t, status[1].Applied)
+
+	// 一次只回滚一个
+	require.NoError(t, m.Down(ctx))
+	require.NoError(t, db.Model(&article{}).Count(&cnt).Error)
+	assert.Equal(t, int64(0), cnt)
+	status, err = m.Status(ctx)
+	require.NoError(t, err)
+	assert.True(t, status[0].Applied)
+	assert.False(t, status[1].Applied)
+
+	require.NoError(t, m.Down(ctx))
+	assert.False(t, db.Migrator().HasTable(&article{}))
+	assert.Equal(t, ErrNothingToRollback, m.Down(ctx))
+}
+
+func TestMigrator_DuplicateVersion(t *testing.T) {
+	db := openDB(t, filepath.Join(t.TempDir(), "webook.db"))
+	ms := append(testMigrations(), testMigrations()[0])
+	err := NewMigrator(db, ms).Up(context.Background())
+	assert.ErrorIs(t, err, ErrDuplicateVersion)
+}
+
+func TestMigrator_ConcurrentUp(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webook.db")
+	const instances = 3
+	var wg sync.WaitGroup
+	errs := make([]error, instances)
+	for i := 0; i < instances; i++ {
+		// 每个实例单独打开数据库，模拟多个副本同时启动
+		m := NewMigrator(openDB(t, path), testMigrations())
+		m.pollInterval = 10 * time.Millisecond
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = m.Up(context.Background())
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+	var cnt int64
+	require.NoError(t, openDB(t, path).Model(&article{}).Count(&cnt).Error)
+	assert.Equal(t, int64(1), cnt)
+}
+
+func TestMigrator_StaleLock(t *testing.T) {
+	db := openDB(t, filepath.Join(t.TempDir(), "webook.db"))
+	m := NewMigrator(db, testMigrations())
+	m.pollInterval = 10 * time.Millisecond
+	require.NoError(t, m.ensureTables(db))
+	// 一个已经挂掉的实例留下的锁
+	require.NoError(t, db.Create(&migrationLock{
+		Id:       lockId,
+		Owner:    "dead",
+		LockedAt: time.Now().Add(-time.Hour).UnixMilli(),
+	}).Error)
+	require.NoError(t, m.Up(context.Background()))
+}
@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func newTestRedis(t *testing.T) redis.Cmdable {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+	t.Cleanup(func() {
+		client.Close()
+	})
+	return client
+}
+
+func TestSlidingWindowMemoryLimiter(t *testing.T) {
+	testSlidingWindow(t, NewSlidingWindowMemoryLimiter(200*time.Millisecond, 3))
+}
+
+func TestSlidingWindowRedisLimiter(t *testing.T) {
+	testSlidingWindow(t, NewSlidingWindowRedisLimiter(newTestRedis(t), 200*time.Millisecond, 3))
+}
+
+func TestTokenBucketMemoryLimiter(t *testing.T) {
+	testTokenBucket(t, NewTokenBucketMemoryLimiter(100*time.Millisecond, 3))
+}
+
+func TestTokenBucketRedisLimiter(t *testing.T) {
+	testTokenBucket(t, NewTokenBucketRedisLimiter(newTestRedis(t), 100*time.Millisecond, 3))
+}
+
+// testSlidingWindow 窗口 200ms，最多 3 个请求
+func testSlidingWindow(t *testing.T, l Limiter) {
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		assertAllowed(t, l, "ip:127.0.0.1")
+	}
+	limited, retryAfter, err := l.Limit(ctx, "ip:127.0.0.1")
+	require.NoError(t, err)
+	assert.True(t, limited)
+	assert.True(t, retryAfter > 0 && retryAfter <= 200*time.Millisecond, retryAfter)
+	// 别的 key 不受影响
+	assertAllowed(t, l, "ip:127.0.0.2")
+
+	// 最早的请求滑出窗口之后又可以请求了
+	time.Sleep(retryAfter + 10*time.Millisecond)
+	assertAllowed(t, l, "ip:127.0.0.1")
+}
+
+// testTokenBucket 每 100ms 一个令牌，桶里最多 3 个
+func testTokenBucket(t *testing.T, l Limiter) {
+	ctx := context.Background()
+	// 一开始桶是满的，允许突发
+	for i := 0; i < 3; i++ {
+		assertAllowed(t, l, "ip:127.0.0.1")
+	}
+	limited, retryAfter, err := l.Limit(ctx, "ip:127.0.0.1")
+	require.NoError(t, err)
+	assert.True(t, limited)
+	assert.True(t, retryAfter > 0 && retryAfter <= 100*time.Millisecond, retryAfter)
+	assertAllowed(t, l, "ip:127.0.0.2")
+
+	// 等一个令牌只能再请求一次
+	time.Sleep(retryAfter + 10*time.Millisecond)
+	assertAllowed(t, l, "ip:127.0.0.1")
+	limited, _, err = l.Limit(ctx, "ip:127.0.0.1")
+	require.NoError(t, err)
+	assert.True(t, limited)
+}
+
+func assertAllowed(t *testing.T, l Limiter, key string) {
+	limited, retryAfter, err := l.Limit(context.Background(), key)
+	require.NoError(t, err)
+	assert.False(t, limited)
+	assert.Zero(t, retryAfter)
+}
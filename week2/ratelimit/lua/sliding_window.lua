@@ -0,0 +1,19 @@
+-- 滑动窗口，窗口里面每个请求是有序集合里面的一个成员，分数是请求的时间
+local key = KEYS[1]
+-- 窗口大小，毫秒
+local window = tonumber(ARGV[1])
+-- 窗口里面最多多少个请求
+local rate = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local member = ARGV[4]
+
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+local cnt = redis.call('ZCARD', key)
+if cnt >= rate then
+    -- 最早的请求滑出窗口之后才能再请求，返回还要等多少毫秒
+    local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+    return tonumber(oldest[2]) + window - now
+end
+redis.call('ZADD', key, now, member)
+redis.call('PEXPIRE', key, window)
+return 0
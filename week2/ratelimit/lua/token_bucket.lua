@@ -0,0 +1,30 @@
+-- 令牌桶，每 interval 毫秒放一个令牌，桶里最多 burst 个令牌
+local key = KEYS[1]
+local interval = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local bucket = redis.call('HMGET', key, 'tokens', 'ts')
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil or ts == nil then
+    -- 第一次请求，桶是满的
+    tokens = burst
+    ts = now
+end
+if now > ts then
+    tokens = math.min(burst, tokens + (now - ts) / interval)
+    ts = now
+end
+
+local wait = 0
+if tokens < 1 then
+    -- 返回还要等多少毫秒才有一个完整的令牌
+    wait = math.ceil((1 - tokens) * interval)
+else
+    tokens = tokens - 1
+end
+redis.call('HSET', key, 'tokens', tostring(tokens), 'ts', ts)
+-- 桶放满之后就和没有这个 key 一样了
+redis.call('PEXPIRE', key, math.ceil(burst * interval))
+return wait
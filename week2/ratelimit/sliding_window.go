@@ -0,0 +1,104 @@
+package ratelimit
+
+import (
+	"context"
+	_ "embed"
+	"github.com/redis/go-redis/v9"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//go:embed lua/sliding_window.lua
+var luaSlidingWindow string
+
+// SlidingWindowMemoryLimiter 滑动窗口限流，任意 window 这么长的时间里面最多 rate 个请求
+// 限额只在当前实例有效，开发环境或者单机部署用
+type SlidingWindowMemoryLimiter struct {
+	window time.Duration
+	rate   int
+
+	mu sync.Mutex
+	// 每个 key 在窗口里面的请求时间，从早到晚
+	requests map[string][]time.Time
+	// 上一次清理不活跃的 key 的时间
+	lastSweep time.Time
+}
+
+// NewSlidingWindowMemoryLimiter 创建一个新的 SlidingWindowMemoryLimiter 实例
+func NewSlidingWindowMemoryLimiter(window time.Duration, rate int) *SlidingWindowMemoryLimiter {
+	return &SlidingWindowMemoryLimiter{
+		window:    window,
+		rate:      rate,
+		requests:  make(map[string][]time.Time),
+		lastSweep: time.Now(),
+	}
+}
+
+func (l *SlidingWindowMemoryLimiter) Limit(ctx context.Context, key string) (bool, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.sweep(now)
+	start := now.Add(-l.window)
+	reqs := l.requests[key]
+	i := 0
+	for i < len(reqs) && !reqs[i].After(start) {
+		i++
+	}
+	reqs = reqs[i:]
+	if len(reqs) >= l.rate {
+		l.requests[key] = reqs
+		return true, reqs[0].Add(l.window).Sub(now), nil
+	}
+	l.requests[key] = append(reqs, now)
+	return false, 0, nil
+}
+
+// sweep 每过一个窗口清理一次最近一个窗口都没有请求的 key，不然 key 会越来越多
+func (l *SlidingWindowMemoryLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.window {
+		return
+	}
+	l.lastSweep = now
+	start := now.Add(-l.window)
+	for key, reqs := range l.requests {
+		if len(reqs) == 0 || !reqs[len(reqs)-1].After(start) {
+			delete(l.requests, key)
+		}
+	}
+}
+
+// SlidingWindowRedisLimiter 是 SlidingWindowMemoryLimiter 基于 Redis 的版本，多个实例共享限额
+// 请求的时间用的是各个实例自己的时钟，实例之间的时钟要大致同步
+type SlidingWindowRedisLimiter struct {
+	client redis.Cmdable
+	window time.Duration
+	rate   int
+}
+
+// NewSlidingWindowRedisLimiter 创建一个新的 SlidingWindowRedisLimiter 实例
+func NewSlidingWindowRedisLimiter(client redis.Cmdable, window time.Duration, rate int) *SlidingWindowRedisLimiter {
+	return &SlidingWindowRedisLimiter{
+		client: client,
+		window: window,
+		rate:   rate,
+	}
+}
+
+func (l *SlidingWindowRedisLimiter) Limit(ctx context.Context, key string) (bool, time.Duration, error) {
+	now := time.Now()
+	// 同一毫秒可能有多个请求，成员要加上随机数区分开
+	member := strconv.FormatInt(now.UnixNano(), 10) + ":" + strconv.FormatInt(rand.Int63(), 10)
+	wait, err := l.client.Eval(ctx, luaSlidingWindow, []string{redisKey(key)},
+		l.window.Milliseconds(), l.rate, now.UnixMilli(), member).Int64()
+	if err != nil {
+		return false, 0, err
+	}
+	return wait > 0, time.Duration(wait) * time.Millisecond, nil
+}
+
+func redisKey(key string) string {
+	return "ratelimit:" + key
+}
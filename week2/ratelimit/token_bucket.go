@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"context"
+	_ "embed"
+	"github.com/redis/go-redis/v9"
+	"math"
+	"sync"
+	"time"
+)
+
+//go:embed lua/token_bucket.lua
+var luaTokenBucket string
+
+type bucket struct {
+	tokens float64
+	// 上一次放令牌的时间
+	last time.Time
+}
+
+// TokenBucketMemoryLimiter 令牌桶限流，每 interval 放一个令牌，桶里最多 burst 个
+// 和滑动窗口比起来允许短时间的突发流量，限额只在当前实例有效
+type TokenBucketMemoryLimiter struct {
+	interval time.Duration
+	burst    int
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// NewTokenBucketMemoryLimiter 创建一个新的 TokenBucketMemoryLimiter 实例
+func NewTokenBucketMemoryLimiter(interval time.Duration, burst int) *TokenBucketMemoryLimiter {
+	return &TokenBucketMemoryLimiter{
+		interval:  interval,
+		burst:     burst,
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+func (l *TokenBucketMemoryLimiter) Limit(ctx context.Context, key string) (bool, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		// 第一次请求，桶是满的
+		b = &bucket{tokens: float64(l.burst), last: now}
+		l.buckets[key] = b
+	}
+	if now.After(b.last) {
+		b.tokens = math.Min(float64(l.burst), b.tokens+float64(now.Sub(b.last))/float64(l.interval))
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return true, time.Duration(math.Ceil((1 - b.tokens) * float64(l.interval))), nil
+	}
+	b.tokens--
+	return false, 0, nil
+}
+
+// full 桶放满要多久，放满了的桶和没有这个桶一样
+func (l *TokenBucketMemoryLimiter) full() time.Duration {
+	return time.Duration(l.burst) * l.interval
+}
+
+// sweep 定期删掉已经放满的桶
+func (l *TokenBucketMemoryLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.full() {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= l.full() {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// TokenBucketRedisLimiter 是 TokenBucketMemoryLimiter 基于 Redis 的版本，多个实例共享限额
+type TokenBucketRedisLimiter struct {
+	client   redis.Cmdable
+	interval time.Duration
+	burst    int
+}
+
+// NewTokenBucketRedisLimiter 创建一个新的 TokenBucketRedisLimiter 实例
+func NewTokenBucketRedisLimiter(client redis.Cmdable, interval time.Duration, burst int) *TokenBucketRedisLimiter {
+	return &TokenBucketRedisLimiter{
+		client:   client,
+		interval: interval,
+		burst:    burst,
+	}
+}
+
+func (l *TokenBucketRedisLimiter) Limit(ctx context.Context, key string) (bool, time.Duration, error) {
+	wait, err := l.client.Eval(ctx, luaTokenBucket, []string{redisKey(key)},
+		l.interval.Milliseconds(), l.burst, time.Now().UnixMilli()).Int64()
+	if err != nil {
+		return false, 0, err
+	}
+	return wait > 0, time.Duration(wait) * time.Millisecond, nil
+}
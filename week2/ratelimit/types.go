@@ -0,0 +1,12 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Limiter 限流器，key 相同的请求共享同一份限额
+type Limiter interface {
+	// Limit 这次请求要不要被限流，被限流的话 retryAfter 是至少还要等多久
+	Limit(ctx context.Context, key string) (limited bool, retryAfter time.Duration, err error)
+}
@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"awesomeProject/webook/internal/repository/cache"
+	"context"
+)
+
+var (
+	ErrCodeSendTooMany        = cache.ErrCodeSendTooMany
+	ErrCodeVerifyTooManyTimes = cache.ErrCodeVerifyTooManyTimes
+)
+
+//go:generate mockgen -source=./code.go -package=repomocks -destination=./mocks/code.mock.go
+type CodeRepository interface {
+	Store(ctx context.Context, biz, phone, code string) error
+	Verify(ctx context.Context, biz, phone, inputCode string) (bool, error)
+}
+
+// CachedCodeRepository 验证码只存在缓存里面
+type CachedCodeRepository struct {
+	cache cache.CodeCache
+}
+
+func NewCodeRepository(c cache.CodeCache) CodeRepository {
+	return &CachedCodeRepository{
+		cache: c,
+	}
+}
+
+func (repo *CachedCodeRepository) Store(ctx context.Context, biz, phone, code string) error {
+	return repo.cache.Set(ctx, biz, phone, code)
+}
+
+func (repo *CachedCodeRepository) Verify(ctx context.Context, biz, phone, inputCode string) (bool, error) {
+	return repo.cache.Verify(ctx, biz, phone, inputCode)
+}
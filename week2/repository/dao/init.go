@@ -1,7 +1,17 @@
 package dao
 
-import "gorm.io/gorm"
+import (
+	"awesomeProject/webook/internal/migrator"
+	"context"
+	"gorm.io/gorm"
+)
 
+// NewMigrator 管理 webook 的表结构
+func NewMigrator(db *gorm.DB) *migrator.Migrator {
+	return migrator.NewMigrator(db, migrations)
+}
+
+// InitTable 执行所有还没执行的迁移，多个实例同时启动的时候只有一个会真正执行
 func InitTable(db *gorm.DB) error {
-	return db.AutoMigrate(&User{})
+	return NewMigrator(db).Up(context.Background())
 }
@@ -0,0 +1,227 @@
+package dao
+
+import (
+	"awesomeProject/webook/internal/domain"
+	"awesomeProject/webook/internal/migrator"
+	"database/sql"
+	"gorm.io/gorm"
+	"time"
+)
+
+// migrations 所有的表结构变更，只能往后追加，已经发布的不要再修改
+// 每个迁移用自己的表结构快照，不要直接用 User，不然 User 改了之后老的迁移也会跟着变
+var migrations = []migrator.Migration{
+	{
+		Version: 1,
+		Name:    "create_users",
+		Up: func(tx *gorm.DB) error {
+			// 之前是 AutoMigrate 建的表，已经有了就不用再建
+			if tx.Migrator().HasTable(&userV1{}) {
+				return nil
+			}
+			return tx.Migrator().CreateTable(&userV1{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&userV1{})
+		},
+	},
+	{
+		Version: 2,
+		Name:    "add_users_timezone",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().AddColumn(&userV2{}, "Timezone")
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&userV2{}, "Timezone")
+		},
+	},
+	{
+		Version: 3,
+		Name:    "users_birthday_to_date",
+		Up: func(tx *gorm.DB) error {
+			// 原来的 birthday 是字符串，里面可能有不合法的日期，没办法直接改类型
+			// 先加一个 DATE 类型的列，把合法的日期搬过去，再替换掉原来的列
+			err := tx.Migrator().AddColumn(&userV3{}, "BirthdayDate")
+			if err != nil {
+				return err
+			}
+			err = copyBirthday(tx, "birthday", "birthday_date", func(s string) (string, bool) {
+				// 不合法的日期直接丢掉，用户需要重新填
+				t, err := time.Parse(domain.BirthdayLayout, s)
+				return t.Format(domain.BirthdayLayout), err == nil
+			})
+			if err != nil {
+				return err
+			}
+			if err = tx.Migrator().DropColumn(&userV1{}, "Birthday"); err != nil {
+				return err
+			}
+			return tx.Migrator().RenameColumn(&userV3{}, "birthday_date", "birthday")
+		},
+		Down: func(tx *gorm.DB) error {
+			err := tx.Migrator().AddColumn(&userV3{}, "BirthdayText")
+			if err != nil {
+				return err
+			}
+			err = copyBirthday(tx, "birthday", "birthday_text", func(s string) (string, bool) {
+				return s, true
+			})
+			if err != nil {
+				return err
+			}
+			if err = tx.Migrator().DropColumn(&userV3{}, "birthday"); err != nil {
+				return err
+			}
+			return tx.Migrator().RenameColumn(&userV3{}, "birthday_text", "birthday")
+		},
+	},
+	{
+		Version: 4,
+		Name:    "add_users_version",
+		Up: func(tx *gorm.DB) error {
+			// 已有的用户从版本 1 开始
+			return tx.Migrator().AddColumn(&userV4{}, "Version")
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&userV4{}, "Version")
+		},
+	},
+	{
+		Version: 5,
+		Name:    "create_user_profile_histories",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().CreateTable(&userProfileHistoryV5{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&userProfileHistoryV5{})
+		},
+	},
+	{
+		Version: 6,
+		Name:    "add_users_avatar",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().AddColumn(&userV6{}, "Avatar")
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&userV6{}, "Avatar")
+		},
+	},
+	{
+		Version: 7,
+		Name:    "add_users_email_verified",
+		Up: func(tx *gorm.DB) error {
+			err := tx.Migrator().AddColumn(&userV7{}, "EmailVerified")
+			if err != nil {
+				return err
+			}
+			// 上线之前注册的用户没有办法补发验证邮件，都当作已经验证过
+			return tx.Table("users").Where("email IS NOT NULL").
+				Update("email_verified", true).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&userV7{}, "EmailVerified")
+		},
+	},
+}
+
+// copyBirthday 把 from 列的生日转换之后写到 to 列，convert 返回 false 的跳过
+func copyBirthday(tx *gorm.DB, from, to string, convert func(s string) (string, bool)) error {
+	type row struct {
+		Id       int64
+		Birthday sql.NullString
+	}
+	var rows []row
+	err := tx.Table("users").Select("id", from+" AS birthday").
+		Where(from + " IS NOT NULL").Find(&rows).Error
+	if err != nil {
+		return err
+	}
+	for _, r := range rows {
+		// MySQL 开了 parseTime 的话，DATE 会被读成 1992-01-01T00:00:00Z 这种格式
+		s := r.Birthday.String
+		if len(s) > len(domain.BirthdayLayout) {
+			s = s[:len(domain.BirthdayLayout)]
+		}
+		val, ok := convert(s)
+		if !ok {
+			continue
+		}
+		err = tx.Table("users").Where("id = ?", r.Id).Update(to, val).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type userV1 struct {
+	Id       int64          `gorm:"primaryKey;autoIncrement"`
+	Email    sql.NullString `gorm:"unique"`
+	Password string
+	Phone    sql.NullString `gorm:"unique"`
+	Nickname string
+	Birthday string
+	Abstract string
+	Ctime    int64
+	Utime    int64
+}
+
+func (userV1) TableName() string {
+	return "users"
+}
+
+type userV2 struct {
+	Timezone string
+}
+
+func (userV2) TableName() string {
+	return "users"
+}
+
+type userV3 struct {
+	Birthday     sql.NullString `gorm:"type:date"`
+	BirthdayDate sql.NullString `gorm:"type:date"`
+	BirthdayText string
+}
+
+func (userV3) TableName() string {
+	return "users"
+}
+
+type userV4 struct {
+	Version int64 `gorm:"not null;default:1"`
+}
+
+func (userV4) TableName() string {
+	return "users"
+}
+
+type userProfileHistoryV5 struct {
+	Id       int64  `gorm:"primaryKey;autoIncrement"`
+	Uid      int64  `gorm:"index:idx_uid_ctime"`
+	Field    string `gorm:"type:varchar(64)"`
+	OldValue string
+	NewValue string
+	Actor    int64
+	Ctime    int64 `gorm:"index:idx_uid_ctime"`
+}
+
+func (userProfileHistoryV5) TableName() string {
+	return "user_profile_histories"
+}
+
+type userV6 struct {
+	Avatar string
+}
+
+func (userV6) TableName() string {
+	return "users"
+}
+
+type userV7 struct {
+	EmailVerified bool `gorm:"not null;default:false"`
+}
+
+func (userV7) TableName() string {
+	return "users"
+}
@@ -0,0 +1,97 @@
+package dao
+
+import (
+	"awesomeProject/webook/internal/domain"
+	"database/sql"
+	"golang.org/x/net/context"
+	"gorm.io/gorm"
+)
+
+// UserProfileHistory 个人信息的修改记录，一个字段一条
+type UserProfileHistory struct {
+	Id  int64 `gorm:"primaryKey,autoIncrement"`
+	Uid int64 `gorm:"index:idx_uid_ctime"`
+	// 改的是哪一列，比如 nickname
+	Field    string `gorm:"type:varchar(64)"`
+	OldValue string
+	NewValue string
+	// 谁改的，用户自己改就是用户自己的 id
+	Actor int64
+	// 修改时间，毫秒数
+	Ctime int64 `gorm:"index:idx_uid_ctime"`
+}
+
+// FindProfileHistory 最新的修改在前面
+func (dao *GORMUserDAO) FindProfileHistory(ctx context.Context, uid int64,
+	offset, limit int) ([]UserProfileHistory, int64, error) {
+	// Session 之后 query 可以复用，Count 不会影响后面的查询
+	query := dao.db.WithContext(ctx).Model(&UserProfileHistory{}).
+		Where("uid = ?", uid).Session(&gorm.Session{})
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var res []UserProfileHistory
+	// 同一个事务里面写的几条 ctime 一样，再按 id 排一下，顺序才稳定
+	err := query.Order("ctime DESC, id DESC").Offset(offset).Limit(limit).Find(&res).Error
+	return res, total, err
+}
+
+// profileHistories 对比修改前后的值，只记录真的变了的字段
+func profileHistories(before User, fields map[string]any, actor int64, now int64) []UserProfileHistory {
+	res := make([]UserProfileHistory, 0, len(fields))
+	for _, field := range profileFields {
+		val, ok := fields[field]
+		if !ok {
+			continue
+		}
+		oldVal, newVal := profileFieldValue(before, field), historyValue(val)
+		if oldVal == newVal {
+			continue
+		}
+		res = append(res, UserProfileHistory{
+			Uid:      before.Id,
+			Field:    field,
+			OldValue: oldVal,
+			NewValue: newVal,
+			Actor:    actor,
+			Ctime:    now,
+		})
+	}
+	return res
+}
+
+// profileFields 会记录修改历史的字段，顺序固定，记录的顺序也就固定了
+var profileFields = []string{"nickname", "birthday", "abstract", "timezone", "avatar"}
+
+func profileFieldValue(u User, field string) string {
+	switch field {
+	case "nickname":
+		return u.Nickname
+	case "birthday":
+		// MySQL 开了 parseTime 的话，DATE 会被读成 1992-01-01T00:00:00Z，只保留日期部分
+		b := historyValue(u.Birthday)
+		if len(b) > len(domain.BirthdayLayout) {
+			b = b[:len(domain.BirthdayLayout)]
+		}
+		return b
+	case "abstract":
+		return u.Abstract
+	case "timezone":
+		return u.Timezone
+	case "avatar":
+		return u.Avatar
+	}
+	return ""
+}
+
+// historyValue NULL 记成空字符串
+func historyValue(val any) string {
+	switch v := val.(type) {
+	case string:
+		return v
+	case sql.NullString:
+		return v.String
+	}
+	return ""
+}
@@ -1,23 +1,36 @@
 package dao
 
 import (
+	"database/sql"
 	"errors"
-	"github.com/go-sql-driver/mysql"
 	"golang.org/x/net/context"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"time"
 )
 
 type User struct {
 	Id int64 `gorm:"primaryKey,autoIncrement"`
 	// 全部用户唯一
-	Email    string `gorm:"unique"`
-	Password string
+	// 手机号注册的用户没有邮箱，所以用 NullString，NULL 不参与唯一索引冲突
+	Email sql.NullString `gorm:"unique"`
+	// 邮箱有没有验证过
+	EmailVerified bool `gorm:"not null;default:false"`
+	Password      string
+	// 手机号，同样全部用户唯一
+	Phone sql.NullString `gorm:"unique"`
 
 	// 往这面加
 	Nickname string
-	Birthday string
+	// 生日，数据库里面是 DATE 类型，没有填就是 NULL
+	Birthday sql.NullString `gorm:"type:date"`
 	Abstract string
+	// 时区偏好，IANA 时区名
+	Timezone string
+	// 头像在文件存储里面的 key
+	Avatar string
+	// 个人信息的版本号，每次修改加一，用来做乐观锁
+	Version int64 `gorm:"not null;default:1"`
 
 	// 创建时间，毫秒数
 	Ctime int64
@@ -26,60 +39,158 @@ type User struct {
 }
 
 var (
-	ErrUserDuplicateEmail = errors.New("邮箱冲突")
+	// ErrUserDuplicate 唯一索引冲突，邮箱或者手机号已经存在
+	ErrUserDuplicate      = errors.New("邮箱或手机号冲突")
+	ErrUserDuplicateEmail = ErrUserDuplicate
 	ErrUserNotFound       = gorm.ErrRecordNotFound
+	// ErrVersionConflict 修改的时候版本号对不上，说明别人已经改过了
+	ErrVersionConflict = errors.New("版本号冲突")
 )
 
-type UserDAO struct {
+//go:generate mockgen -source=./user.go -package=daomocks -destination=./mocks/user.mock.go
+type UserDAO interface {
+	FindByEmail(ctx context.Context, email string) (User, error)
+	FindByPhone(ctx context.Context, phone string) (User, error)
+	FindById(ctx context.Context, id int64) (User, error)
+	Insert(ctx context.Context, u User) error
+	// UpdateProfile 只更新 fields 里面的列，零值也会写进去，同时记录是 actor 改的。
+	// version 大于 0 的时候要求数据库里面的版本号和它一致，不一致返回 ErrVersionConflict；
+	// 用户不存在返回 ErrUserNotFound
+	UpdateProfile(ctx context.Context, uid int64, version int64, actor int64, fields map[string]any) error
+	// FindProfileHistory 按照时间倒序分页查询个人信息的修改记录，同时返回总数
+	FindProfileHistory(ctx context.Context, uid int64, offset, limit int) ([]UserProfileHistory, int64, error)
+	// MarkEmailVerified 把邮箱标记为已验证，要求邮箱还是 email，防止验证的是改邮箱之前的地址
+	MarkEmailVerified(ctx context.Context, uid int64, email string) error
+	// UpdatePassword 修改密码，password 是已经加密过的
+	UpdatePassword(ctx context.Context, uid int64, password string) error
+}
+
+// GORMUserDAO 基于 GORM 的 UserDAO 实现
+type GORMUserDAO struct {
 	db *gorm.DB
 }
 
-func NewUserDAO(db *gorm.DB) *UserDAO {
-	return &UserDAO{
+func NewUserDAO(db *gorm.DB) UserDAO {
+	return &GORMUserDAO{
 		db: db,
 	}
 }
 
-func (dao *UserDAO) FindByEmail(ctx context.Context, email string) (User, error) {
+func (dao *GORMUserDAO) FindByEmail(ctx context.Context, email string) (User, error) {
 	var u User
 	//var daotest *gorm.DB
 	err := dao.db.WithContext(ctx).Where("email = ?", email).First(&u).Error
 	return u, err
 }
 
-func (dao *UserDAO) FindById(ctx context.Context, id int64) (User, error) {
+func (dao *GORMUserDAO) FindByPhone(ctx context.Context, phone string) (User, error) {
+	var u User
+	err := dao.db.WithContext(ctx).Where("phone = ?", phone).First(&u).Error
+	return u, err
+}
+
+func (dao *GORMUserDAO) FindById(ctx context.Context, id int64) (User, error) {
 	var u User
 	//var daotest *gorm.DB
 	err := dao.db.WithContext(ctx).Where("id = ?", id).First(&u).Error
 	return u, err
 }
 
-func (dao *UserDAO) Insert(ctx context.Context, u User) error {
+func (dao *GORMUserDAO) Insert(ctx context.Context, u User) error {
 	//存毫秒
 	now := time.Now().UnixMilli()
 	u.Utime = now
 	u.Ctime = now
+	u.Version = 1
 	err := dao.db.WithContext(ctx).Create(&u).Error
-	if mysqlErr, ok := err.(*mysql.MySQLError); ok {
-		const uniqueConflictsErrNo uint16 = 1062
-		if mysqlErr.Number == uniqueConflictsErrNo {
-			//邮箱冲突
-			return ErrUserDuplicateEmail
-		}
+	if dao.isUniqueConflict(err) {
+		//邮箱或者手机号冲突
+		return ErrUserDuplicate
 	}
 	return err
 }
 
+// isUniqueConflict 判断是不是唯一索引冲突，MySQL 和 SQLite 都能识别
+// 用数据库驱动自己的 ErrorTranslator 转换错误，不依赖打开数据库的时候有没有设置 TranslateError
+func (dao *GORMUserDAO) isUniqueConflict(err error) bool {
+	if err == nil {
+		return false
+	}
+	if translator, ok := dao.db.Dialector.(gorm.ErrorTranslator); ok {
+		err = translator.Translate(err)
+	}
+	return errors.Is(err, gorm.ErrDuplicatedKey)
+}
+
 //修改用户信息/users/profile
 
-func (dao *UserDAO) EditUserProfile(ctx context.Context, uid int64, u User) error {
+// UpdateProfile 用 map 更新，这样空字符串和 NULL 也能写进去，
+// 不会像 Updates(&u) 那样把零值跳过。每次更新都会把版本号加一。
+// 在同一个事务里面把改了的字段写进修改记录，修改和记录要么都成功要么都失败
+func (dao *GORMUserDAO) UpdateProfile(ctx context.Context, uid int64, version int64, actor int64,
+	fields map[string]any) error {
 	now := time.Now().UnixMilli()
-	u.Utime = now
-	err := dao.db.WithContext(ctx).Where("id = ?", uid).Updates(&u).Error
-	println(111)
-	if err != nil {
-		return err
+	return dao.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// 锁住这一行，保证记录下来的修改前的值就是这次修改覆盖掉的值
+		var before User
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", uid).First(&before).Error
+		if err != nil {
+			return err
+		}
+		if version > 0 && before.Version != version {
+			return ErrVersionConflict
+		}
+
+		updates := make(map[string]any, len(fields)+2)
+		for k, v := range fields {
+			updates[k] = v
+		}
+		updates["utime"] = now
+		updates["version"] = gorm.Expr("version + 1")
+		err = tx.Model(&User{}).Where("id = ?", uid).Updates(updates).Error
+		if err != nil {
+			return err
+		}
+
+		histories := profileHistories(before, fields, actor, now)
+		if len(histories) == 0 {
+			return nil
+		}
+		return tx.Create(&histories).Error
+	})
+}
 
+func (dao *GORMUserDAO) MarkEmailVerified(ctx context.Context, uid int64, email string) error {
+	res := dao.db.WithContext(ctx).Model(&User{}).
+		Where("id = ? AND email = ?", uid, email).
+		Updates(map[string]any{
+			"email_verified": true,
+			"utime":          time.Now().UnixMilli(),
+			"version":        gorm.Expr("version + 1"),
+		})
+	if res.Error != nil {
+		return res.Error
 	}
-	return err
+	if res.RowsAffected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// UpdatePassword 密码不在个人信息里面，不修改版本号，也不记录修改记录
+func (dao *GORMUserDAO) UpdatePassword(ctx context.Context, uid int64, password string) error {
+	res := dao.db.WithContext(ctx).Model(&User{}).
+		Where("id = ?", uid).
+		Updates(map[string]any{
+			"password": password,
+			"utime":    time.Now().UnixMilli(),
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
 }
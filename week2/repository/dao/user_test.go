@@ -0,0 +1,211 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"path/filepath"
+	"testing"
+)
+
+// newSQLiteDB 每个测试一个单独的 SQLite 数据库文件
+func newSQLiteDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "webook.db")))
+	require.NoError(t, err)
+	require.NoError(t, InitTable(db))
+	return db
+}
+
+func TestGORMUserDAO_Insert(t *testing.T) {
+	testCases := []struct {
+		name    string
+		before  []User
+		user    User
+		wantErr error
+	}{
+		{
+			name: "插入成功",
+			user: User{
+				Email: sql.NullString{String: "123@qq.com", Valid: true},
+			},
+		},
+		{
+			name: "邮箱冲突",
+			before: []User{
+				{Email: sql.NullString{String: "123@qq.com", Valid: true}},
+			},
+			user: User{
+				Email: sql.NullString{String: "123@qq.com", Valid: true},
+			},
+			wantErr: ErrUserDuplicateEmail,
+		},
+		{
+			name: "手机号冲突",
+			before: []User{
+				{Phone: sql.NullString{String: "15212345678", Valid: true}},
+			},
+			user: User{
+				Phone: sql.NullString{String: "15212345678", Valid: true},
+			},
+			wantErr: ErrUserDuplicate,
+		},
+		{
+			name: "都没有邮箱不算冲突",
+			before: []User{
+				{Phone: sql.NullString{String: "15212345678", Valid: true}},
+			},
+			user: User{
+				Phone: sql.NullString{String: "15212345679", Valid: true},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := NewUserDAO(newSQLiteDB(t))
+			for _, u := range tc.before {
+				require.NoError(t, d.Insert(context.Background(), u))
+			}
+			err := d.Insert(context.Background(), tc.user)
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
+
+func TestGORMUserDAO_FindByPhone(t *testing.T) {
+	d := NewUserDAO(newSQLiteDB(t))
+	_, err := d.FindByPhone(context.Background(), "15212345678")
+	assert.Equal(t, ErrUserNotFound, err)
+
+	require.NoError(t, d.Insert(context.Background(), User{
+		Phone: sql.NullString{String: "15212345678", Valid: true},
+	}))
+	u, err := d.FindByPhone(context.Background(), "15212345678")
+	require.NoError(t, err)
+	assert.Equal(t, "15212345678", u.Phone.String)
+	assert.False(t, u.Email.Valid)
+}
+
+func TestGORMUserDAO_UpdateProfile(t *testing.T) {
+	d := NewUserDAO(newSQLiteDB(t))
+	ctx := context.Background()
+	require.NoError(t, d.Insert(ctx, User{
+		Email: sql.NullString{String: "123@qq.com", Valid: true},
+	}))
+	require.NoError(t, d.UpdateProfile(ctx, 1, 1, 1, map[string]any{
+		"nickname": "大明",
+		"birthday": sql.NullString{String: "1992-01-01", Valid: true},
+		"abstract": "我是大明",
+		"timezone": "Asia/Tokyo",
+	}))
+
+	// 客服清空个人简介和生日，昵称没有变化，时区没有传，不检查版本号
+	require.NoError(t, d.UpdateProfile(ctx, 1, 0, 99, map[string]any{
+		"nickname": "大明",
+		"abstract": "",
+		"birthday": sql.NullString{},
+	}))
+	u, err := d.FindById(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "大明", u.Nickname)
+	assert.Equal(t, "Asia/Tokyo", u.Timezone)
+	assert.Equal(t, "", u.Abstract)
+	assert.False(t, u.Birthday.Valid)
+	// 邮箱不在更新的列里面，不会被清掉
+	assert.Equal(t, "123@qq.com", u.Email.String)
+	assert.Equal(t, int64(3), u.Version)
+
+	// 拿着旧的版本号去改，不会覆盖别人的修改
+	err = d.UpdateProfile(ctx, 1, 2, 1, map[string]any{"nickname": "小明"})
+	assert.Equal(t, ErrVersionConflict, err)
+	u, err = d.FindById(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "大明", u.Nickname)
+
+	err = d.UpdateProfile(ctx, 2, 0, 2, map[string]any{"nickname": "小明"})
+	assert.Equal(t, ErrUserNotFound, err)
+
+	// 只记录了真的变了的字段，失败的修改不会留下记录
+	hs, total, err := d.FindProfileHistory(ctx, 1, 0, 3)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), total)
+	type change struct {
+		Field    string
+		OldValue string
+		NewValue string
+		Actor    int64
+	}
+	changes := make([]change, 0, len(hs))
+	for _, h := range hs {
+		changes = append(changes, change{h.Field, h.OldValue, h.NewValue, h.Actor})
+	}
+	assert.Equal(t, []change{
+		{"abstract", "我是大明", "", 99},
+		{"birthday", "1992-01-01", "", 99},
+		{"timezone", "", "Asia/Tokyo", 1},
+	}, changes)
+}
+
+func TestGORMUserDAO_MarkEmailVerified(t *testing.T) {
+	d := NewUserDAO(newSQLiteDB(t))
+	ctx := context.Background()
+	require.NoError(t, d.Insert(ctx, User{
+		Email: sql.NullString{String: "123@qq.com", Valid: true},
+	}))
+	u, err := d.FindById(ctx, 1)
+	require.NoError(t, err)
+	assert.False(t, u.EmailVerified)
+
+	// 验证的是旧邮箱，不算数
+	assert.Equal(t, ErrUserNotFound, d.MarkEmailVerified(ctx, 1, "456@qq.com"))
+	require.NoError(t, d.MarkEmailVerified(ctx, 1, "123@qq.com"))
+	u, err = d.FindById(ctx, 1)
+	require.NoError(t, err)
+	assert.True(t, u.EmailVerified)
+}
+
+func TestGORMUserDAO_UpdatePassword(t *testing.T) {
+	d := NewUserDAO(newSQLiteDB(t))
+	ctx := context.Background()
+	require.NoError(t, d.Insert(ctx, User{
+		Email:    sql.NullString{String: "123@qq.com", Valid: true},
+		Password: "old",
+	}))
+
+	require.NoError(t, d.UpdatePassword(ctx, 1, "new"))
+	u, err := d.FindById(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "new", u.Password)
+	// 密码不算个人信息，版本号不变
+	assert.Equal(t, int64(1), u.Version)
+
+	assert.Equal(t, ErrUserNotFound, d.UpdatePassword(ctx, 2, "new"))
+}
+
+func TestMigrations_BirthdayToDate(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "webook.db")))
+	require.NoError(t, err)
+	m := NewMigrator(db)
+	ctx := context.Background()
+	// 先回到生日还是字符串的版本，写入一些历史数据
+	require.NoError(t, m.Up(ctx))
+	// 一直回滚到 v3 之前，后面加了新的迁移也不用改这里
+	for i := 3; i <= len(migrations); i++ {
+		require.NoError(t, m.Down(ctx))
+	}
+	require.NoError(t, db.Exec("INSERT INTO users (email, birthday) VALUES (?, ?), (?, ?)",
+		"1@qq.com", "1992-01-01", "2@qq.com", "2024-13-45").Error)
+
+	require.NoError(t, m.Up(ctx))
+	d := NewUserDAO(db)
+	u, err := d.FindByEmail(ctx, "1@qq.com")
+	require.NoError(t, err)
+	assert.Equal(t, "1992-01-01", u.Birthday.String[:10])
+	// 不合法的生日被丢掉了
+	u, err = d.FindByEmail(ctx, "2@qq.com")
+	require.NoError(t, err)
+	assert.False(t, u.Birthday.Valid)
+}
@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"awesomeProject/webook/internal/repository/cache"
+	"context"
+	"time"
+)
+
+//go:generate mockgen -source=./login_attempt.go -package=repomocks -destination=./mocks/login_attempt.mock.go
+type LoginAttemptRepository interface {
+	// IncrFail 失败次数加一，返回加一之后的次数
+	IncrFail(ctx context.Context, key string, window time.Duration) (int, error)
+	Lock(ctx context.Context, key string, until time.Time) error
+	// LockedUntil 没有锁定返回零值
+	LockedUntil(ctx context.Context, key string) (time.Time, error)
+	Reset(ctx context.Context, key string) error
+}
+
+// CachedLoginAttemptRepository 失败记录只存在缓存里面，丢了顶多让人多试几次
+type CachedLoginAttemptRepository struct {
+	cache cache.LoginAttemptCache
+}
+
+func NewLoginAttemptRepository(c cache.LoginAttemptCache) LoginAttemptRepository {
+	return &CachedLoginAttemptRepository{
+		cache: c,
+	}
+}
+
+func (repo *CachedLoginAttemptRepository) IncrFail(ctx context.Context, key string, window time.Duration) (int, error) {
+	return repo.cache.IncrFail(ctx, key, window)
+}
+
+func (repo *CachedLoginAttemptRepository) Lock(ctx context.Context, key string, until time.Time) error {
+	return repo.cache.Lock(ctx, key, until)
+}
+
+func (repo *CachedLoginAttemptRepository) LockedUntil(ctx context.Context, key string) (time.Time, error) {
+	return repo.cache.LockedUntil(ctx, key)
+}
+
+func (repo *CachedLoginAttemptRepository) Reset(ctx context.Context, key string) error {
+	return repo.cache.Reset(ctx, key)
+}
@@ -0,0 +1,38 @@
+package repository
+
+import (
+	"awesomeProject/webook/internal/repository/cache"
+	"context"
+	"time"
+)
+
+//go:generate mockgen -source=./session.go -package=repomocks -destination=./mocks/session.mock.go
+type SessionRepository interface {
+	// Revoke 让 at 之前登录的 session 全部失效
+	Revoke(ctx context.Context, uid int64, at time.Time) error
+	// RevokedAt 最近一次撤销的时间，没有撤销过返回零值
+	RevokedAt(ctx context.Context, uid int64) (time.Time, error)
+}
+
+// CachedSessionRepository 撤销记录只存在缓存里面
+type CachedSessionRepository struct {
+	cache cache.SessionCache
+}
+
+func NewSessionRepository(c cache.SessionCache) SessionRepository {
+	return &CachedSessionRepository{
+		cache: c,
+	}
+}
+
+func (repo *CachedSessionRepository) Revoke(ctx context.Context, uid int64, at time.Time) error {
+	return repo.cache.SetRevokedAt(ctx, uid, at)
+}
+
+func (repo *CachedSessionRepository) RevokedAt(ctx context.Context, uid int64) (time.Time, error) {
+	t, err := repo.cache.GetRevokedAt(ctx, uid)
+	if err == cache.ErrKeyNotExist {
+		return time.Time{}, nil
+	}
+	return t, err
+}
@@ -2,100 +2,295 @@ package repository
 
 import (
 	"awesomeProject/webook/internal/domain"
+	"awesomeProject/webook/internal/repository/cache"
 	"awesomeProject/webook/internal/repository/dao"
-	"fmt"
+	"database/sql"
 	"golang.org/x/net/context"
+	"golang.org/x/sync/singleflight"
+	"log"
+	"strconv"
 	"time"
 )
 
 var (
+	ErrUserDuplicate      = dao.ErrUserDuplicate
 	ErrUserDuplicateEmail = dao.ErrUserDuplicateEmail
 	ErrUserNotFound       = dao.ErrUserNotFound
+	ErrVersionConflict    = dao.ErrVersionConflict
 )
 
-type UserRepository struct {
-	dao *dao.UserDAO
+//go:generate mockgen -source=./user.go -package=repomocks -destination=./mocks/user.mock.go
+type UserRepository interface {
+	FindByEmail(ctx context.Context, email string) (domain.User, error)
+	FindByPhone(ctx context.Context, phone string) (domain.User, error)
+	FindById(ctx context.Context, id int64) (domain.User, error)
+	// FindByIdWithPassword 直接查数据库，带上加密后的密码，修改密码的时候用
+	FindByIdWithPassword(ctx context.Context, id int64) (domain.User, error)
+	FindOrCreate(ctx context.Context, phone string) (domain.User, error)
+	Create(ctx context.Context, u domain.User) error
+	Edit(ctx context.Context, id int64, u domain.User) error
+	UpdateProfile(ctx context.Context, id int64, patch domain.UserProfilePatch) error
+	// ProfileHistory 分页查询个人信息的修改记录，同时返回总数
+	ProfileHistory(ctx context.Context, id int64, offset, limit int) ([]domain.ProfileChange, int64, error)
+	// MarkEmailVerified 邮箱还是 email 的话标记为已验证，否则返回 ErrUserNotFound
+	MarkEmailVerified(ctx context.Context, id int64, email string) error
+	// UpdatePassword 修改密码，password 是已经加密过的
+	UpdatePassword(ctx context.Context, id int64, password string) error
 }
 
-func NewUserRepository(dao *dao.UserDAO) *UserRepository {
-	return &UserRepository{
-		dao: dao,
+// CachedUserRepository 带缓存的 UserRepository 实现
+type CachedUserRepository struct {
+	dao   dao.UserDAO
+	cache cache.UserCache
+	// sf 合并同一个用户并发的缓存未命中，热门用户的缓存过期时只有一个请求去查数据库
+	sf singleflight.Group
+}
+
+func NewUserRepository(dao dao.UserDAO, c cache.UserCache) UserRepository {
+	return &CachedUserRepository{
+		dao:   dao,
+		cache: c,
 	}
 }
 
-func (r *UserRepository) FindByEmail(ctx context.Context, email string) (domain.User, error) {
+func (r *CachedUserRepository) FindByEmail(ctx context.Context, email string) (domain.User, error) {
 	u, err := r.dao.FindByEmail(ctx, email)
 	if err != nil {
 		return domain.User{}, err
 	}
-	return domain.User{
-		Id:       u.Id,
-		Email:    u.Email,
-		Password: u.Password,
-	}, nil
+	return r.toDomain(u), nil
 }
 
-func (r *UserRepository) FindById(ctx context.Context, id int64) (domain.User, error) {
+func (r *CachedUserRepository) FindByPhone(ctx context.Context, phone string) (domain.User, error) {
+	u, err := r.dao.FindByPhone(ctx, phone)
+	if err != nil {
+		return domain.User{}, err
+	}
+	return r.toDomain(u), nil
+}
 
-	u, err := r.dao.FindById(ctx, id)
+// FindOrCreate 按手机号查找用户，找不到就创建一个。
+// 两个请求同时首次登录时，后插入的那个会撞上唯一索引，这时再查一次拿到先插入的用户，
+// 所以并发下返回的是同一个用户，而不是 ErrUserDuplicate
+func (r *CachedUserRepository) FindOrCreate(ctx context.Context, phone string) (domain.User, error) {
+	u, err := r.FindByPhone(ctx, phone)
+	if err != ErrUserNotFound {
+		// 找到了，或者是系统错误
+		return u, err
+	}
+	err = r.Create(ctx, domain.User{
+		Phone: phone,
+	})
+	if err != nil && err != ErrUserDuplicate {
+		return domain.User{}, err
+	}
+	// 插入成功或者别人抢先插入了，都再查一次
+	// 这里如果有主从延迟，要强制读主库
+	return r.FindByPhone(ctx, phone)
+}
+
+// FindById 先从缓存里面找，再从 dao 里面找，找到了回写缓存
+func (r *CachedUserRepository) FindById(ctx context.Context, id int64) (domain.User, error) {
+	u, err := r.cache.Get(ctx, id)
+	if err == nil {
+		return u, nil
+	}
+	// 缓存里面没有，或者缓存出错了（比如 Redis 崩了），都去数据库里面查
+	// 有 singleflight 兜着，同一个用户同时只会有一个请求打到数据库
+	val, err, _ := r.sf.Do(strconv.FormatInt(id, 10), func() (interface{}, error) {
+		u, err := r.findByIdFromDB(ctx, id)
+		if err != nil {
+			return domain.User{}, err
+		}
+		// 回写缓存失败了也不影响这次查询，最多下次再查一次数据库
+		_ = r.cache.Set(ctx, u)
+		return u, nil
+	})
 	if err != nil {
 		return domain.User{}, err
 	}
-	ctime := changeTime(u.Ctime)
-	utime := changeTime(u.Utime)
-	return domain.User{
-		Id:    u.Id,
-		Email: u.Email,
-		//Password: u.Password,
-		Nickname: u.Nickname,
-		Birthday: u.Birthday,
-		Abstract: u.Abstract,
-		Ctime:    ctime,
-		Utime:    utime,
-	}, nil
+	return val.(domain.User), nil
 }
 
-func changeTime(srcTime int64) string {
-	millisecondTimestamp := int64(srcTime) // 假设这是一个毫秒时间戳示例
+// FindByIdWithPassword 缓存里面没有密码，所以不走缓存
+func (r *CachedUserRepository) FindByIdWithPassword(ctx context.Context, id int64) (domain.User, error) {
+	ue, err := r.dao.FindById(ctx, id)
+	if err != nil {
+		return domain.User{}, err
+	}
+	return r.toDomain(ue), nil
+}
+
+func (r *CachedUserRepository) findByIdFromDB(ctx context.Context, id int64) (domain.User, error) {
+	ue, err := r.dao.FindById(ctx, id)
+	if err != nil {
+		return domain.User{}, err
+	}
+	u := r.toDomain(ue)
+	// 个人信息用不到密码，也不要把密码放进缓存
+	u.Password = ""
+	return u, nil
+}
 
-	// 将毫秒时间戳除以1000得到秒级时间戳
-	secondTimestamp := millisecondTimestamp / 1000
+func (r *CachedUserRepository) Create(ctx context.Context, u domain.User) error {
+	println(1111)
+	return r.dao.Insert(ctx, r.toEntity(u))
+}
+
+func (r *CachedUserRepository) toDomain(u dao.User) domain.User {
+	return domain.User{
+		Id:            u.Id,
+		Email:         u.Email.String,
+		EmailVerified: u.EmailVerified,
+		Phone:         u.Phone.String,
+		Password:      u.Password,
+		Nickname:      u.Nickname,
+		Birthday:      r.birthdayToDomain(u.Birthday),
+		Abstract:      u.Abstract,
+		Timezone:      u.Timezone,
+		Avatar:        u.Avatar,
+		Version:       u.Version,
+		// 数据库里面存的是毫秒数，时区留给展示的时候决定
+		Ctime: time.UnixMilli(u.Ctime),
+		Utime: time.UnixMilli(u.Utime),
+	}
+}
+
+// toEntity 空字符串存成 NULL，避免没有邮箱或者手机号的用户互相冲突
+func (r *CachedUserRepository) toEntity(u domain.User) dao.User {
+	return dao.User{
+		Id: u.Id,
+		Email: sql.NullString{
+			String: u.Email,
+			Valid:  u.Email != "",
+		},
+		Phone: sql.NullString{
+			String: u.Phone,
+			Valid:  u.Phone != "",
+		},
+		EmailVerified: u.EmailVerified,
+		Password:      u.Password,
+	}
+}
 
-	// 将秒级时间戳转换为time.Time类型
-	timestampTime := time.Unix(secondTimestamp, 0)
+// birthdayToDomain MySQL 开了 parseTime 的话，DATE 会被读成 1992-01-01T00:00:00Z，所以只取日期部分
+func (r *CachedUserRepository) birthdayToDomain(b sql.NullString) time.Time {
+	s := b.String
+	if !b.Valid || len(s) < len(domain.BirthdayLayout) {
+		return time.Time{}
+	}
+	t, err := time.Parse(domain.BirthdayLayout, s[:len(domain.BirthdayLayout)])
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
 
-	// 载入中国时区
-	chinaTimezone, _ := time.LoadLocation("Asia/Shanghai")
+func (r *CachedUserRepository) birthdayToEntity(t time.Time) sql.NullString {
+	if t.IsZero() {
+		return sql.NullString{}
+	}
+	return sql.NullString{
+		String: t.Format(domain.BirthdayLayout),
+		Valid:  true,
+	}
+}
 
-	// 将时间调整为中国时区
-	timestampTimeInChina := timestampTime.In(chinaTimezone)
+// Edit 修改个人信息，u.Version 大于 0 的时候会检查版本号
+func (r *CachedUserRepository) Edit(ctx context.Context, id int64, u domain.User) error {
+	fields := map[string]any{
+		"nickname": u.Nickname,
+		"birthday": r.birthdayToEntity(u.Birthday),
+		"abstract": u.Abstract,
+	}
+	// 时区是可选的，没有传就不修改
+	if u.Timezone != "" {
+		fields["timezone"] = u.Timezone
+	}
+	// 这个接口只有用户自己能调
+	return r.updateProfile(ctx, id, u.Version, id, fields)
+}
 
-	// 格式化为日期时间字符串
-	formattedDate := timestampTimeInChina.Format("2006-01-02 15:04:05") // 格式可以根据需求进行调整
+// UpdateProfile 只更新 patch 里面设置了的字段
+func (r *CachedUserRepository) UpdateProfile(ctx context.Context, id int64, patch domain.UserProfilePatch) error {
+	fields := make(map[string]any, 5)
+	if patch.Nickname != nil {
+		fields["nickname"] = *patch.Nickname
+	}
+	if patch.Birthday != nil {
+		fields["birthday"] = r.birthdayToEntity(*patch.Birthday)
+	}
+	if patch.Abstract != nil {
+		fields["abstract"] = *patch.Abstract
+	}
+	if patch.Timezone != nil {
+		fields["timezone"] = *patch.Timezone
+	}
+	if patch.Avatar != nil {
+		fields["avatar"] = *patch.Avatar
+	}
+	actor := patch.Actor
+	if actor == 0 {
+		actor = id
+	}
+	return r.updateProfile(ctx, id, patch.Version, actor, fields)
+}
 
-	fmt.Println("Formatted Date in China Timezone:", formattedDate)
-	return formattedDate
+func (r *CachedUserRepository) updateProfile(ctx context.Context, id int64, version int64, actor int64,
+	fields map[string]any) error {
+	err := r.dao.UpdateProfile(ctx, id, version, actor, fields)
+	if err != nil {
+		return err
+	}
+	// 先更新数据库，再删缓存，下次 FindById 会重新加载
+	r.deleteCache(ctx, id)
+	return nil
+}
 
+// deleteCache 数据库已经提交了，删缓存失败也要当成成功，不然客户端重试会因为版本号对不上而失败
+// 缓存有过期时间，最多读到一段时间的旧数据
+func (r *CachedUserRepository) deleteCache(ctx context.Context, id int64) {
+	if err := r.cache.Delete(ctx, id); err != nil {
+		log.Printf("删除用户缓存失败 uid: %d, err: %v", id, err)
+	}
 }
 
-func (r *UserRepository) Create(ctx context.Context, u domain.User) error {
-	println(1111)
-	return r.dao.Insert(ctx, dao.User{
-		Email:    u.Email,
-		Password: u.Password,
-	})
+func (r *CachedUserRepository) ProfileHistory(ctx context.Context, id int64,
+	offset, limit int) ([]domain.ProfileChange, int64, error) {
+	hs, total, err := r.dao.FindProfileHistory(ctx, id, offset, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	res := make([]domain.ProfileChange, 0, len(hs))
+	for _, h := range hs {
+		res = append(res, domain.ProfileChange{
+			Id:     h.Id,
+			Uid:    h.Uid,
+			Field:  h.Field,
+			Before: h.OldValue,
+			After:  h.NewValue,
+			Actor:  h.Actor,
+			Ctime:  time.UnixMilli(h.Ctime),
+		})
+	}
+	return res, total, nil
 }
 
-//func (r *UserRepository) FindById(int64) {
-//	//先从cache里面找
-//	//再从dao里面找
-//	//找到了回写cache
-//}
+func (r *CachedUserRepository) MarkEmailVerified(ctx context.Context, id int64, email string) error {
+	err := r.dao.MarkEmailVerified(ctx, id, email)
+	if err != nil {
+		return err
+	}
+	r.deleteCache(ctx, id)
+	return nil
+}
 
-func (r *UserRepository) Edit(ctx context.Context, id int64, u domain.User) error {
-	return r.dao.EditUserProfile(ctx, id, dao.User{
-		Nickname: u.Nickname,
-		Birthday: u.Birthday,
-		Abstract: u.Abstract,
-	})
+func (r *CachedUserRepository) UpdatePassword(ctx context.Context, id int64, password string) error {
+	err := r.dao.UpdatePassword(ctx, id, password)
+	if err != nil {
+		return err
+	}
+	// 缓存里面没有密码，删掉是为了让 Utime 这些字段重新加载
+	r.deleteCache(ctx, id)
+	return nil
 }
@@ -0,0 +1,303 @@
+package repository
+
+import (
+	"awesomeProject/webook/internal/domain"
+	"awesomeProject/webook/internal/repository/cache"
+	cachemocks "awesomeProject/webook/internal/repository/cache/mocks"
+	"awesomeProject/webook/internal/repository/dao"
+	daomocks "awesomeProject/webook/internal/repository/dao/mocks"
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"gorm.io/gorm"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newSQLiteDB 每个测试一个单独的 SQLite 数据库文件
+// 并发写的时候 SQLite 会锁库，等一会儿再写，不然直接报 database is locked
+func newSQLiteDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "webook.db") + "?_pragma=busy_timeout(5000)"))
+	require.NoError(t, err)
+	require.NoError(t, dao.InitTable(db))
+	return db
+}
+
+func TestCachedUserRepository_FindById(t *testing.T) {
+	testCases := []struct {
+		name     string
+		mock     func(ctrl *gomock.Controller) (dao.UserDAO, cache.UserCache)
+		id       int64
+		wantUser domain.User
+		wantErr  error
+	}{
+		{
+			name: "缓存命中",
+			mock: func(ctrl *gomock.Controller) (dao.UserDAO, cache.UserCache) {
+				d := daomocks.NewMockUserDAO(ctrl)
+				c := cachemocks.NewMockUserCache(ctrl)
+				c.EXPECT().Get(gomock.Any(), int64(123)).Return(domain.User{
+					Id:    123,
+					Email: "123@qq.com",
+				}, nil)
+				return d, c
+			},
+			id: 123,
+			wantUser: domain.User{
+				Id:    123,
+				Email: "123@qq.com",
+			},
+		},
+		{
+			name: "缓存未命中，查数据库并回写缓存",
+			mock: func(ctrl *gomock.Controller) (dao.UserDAO, cache.UserCache) {
+				d := daomocks.NewMockUserDAO(ctrl)
+				c := cachemocks.NewMockUserCache(ctrl)
+				c.EXPECT().Get(gomock.Any(), int64(123)).Return(domain.User{}, cache.ErrKeyNotExist)
+				d.EXPECT().FindById(gomock.Any(), int64(123)).Return(dao.User{
+					Id: 123,
+					Email: sql.NullString{
+						String: "123@qq.com",
+						Valid:  true,
+					},
+				}, nil)
+				c.EXPECT().Set(gomock.Any(), gomock.Any()).Return(nil)
+				return d, c
+			},
+			id: 123,
+			wantUser: domain.User{
+				Id:    123,
+				Email: "123@qq.com",
+				Ctime: time.UnixMilli(0),
+				Utime: time.UnixMilli(0),
+			},
+		},
+		{
+			name: "用户不存在",
+			mock: func(ctrl *gomock.Controller) (dao.UserDAO, cache.UserCache) {
+				d := daomocks.NewMockUserDAO(ctrl)
+				c := cachemocks.NewMockUserCache(ctrl)
+				c.EXPECT().Get(gomock.Any(), int64(123)).Return(domain.User{}, cache.ErrKeyNotExist)
+				d.EXPECT().FindById(gomock.Any(), int64(123)).Return(dao.User{}, dao.ErrUserNotFound)
+				return d, c
+			},
+			id:      123,
+			wantErr: ErrUserNotFound,
+		},
+		{
+			name: "缓存出错也去查数据库",
+			mock: func(ctrl *gomock.Controller) (dao.UserDAO, cache.UserCache) {
+				d := daomocks.NewMockUserDAO(ctrl)
+				c := cachemocks.NewMockUserCache(ctrl)
+				c.EXPECT().Get(gomock.Any(), int64(123)).Return(domain.User{}, errors.New("redis 崩了"))
+				d.EXPECT().FindById(gomock.Any(), int64(123)).Return(dao.User{Id: 123}, nil)
+				c.EXPECT().Set(gomock.Any(), gomock.Any()).Return(errors.New("redis 崩了"))
+				return d, c
+			},
+			id: 123,
+			wantUser: domain.User{
+				Id:    123,
+				Ctime: time.UnixMilli(0),
+				Utime: time.UnixMilli(0),
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			repo := NewUserRepository(tc.mock(ctrl))
+			u, err := repo.FindById(context.Background(), tc.id)
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantUser, u)
+		})
+	}
+}
+
+// barrierUserDAO 前 n 次 FindByPhone 要等 n 个都查完了才返回
+// 这样所有 goroutine 都会以为用户不存在，一起去插入，必然有人撞上唯一索引
+type barrierUserDAO struct {
+	dao.UserDAO
+	n     int
+	mu    sync.Mutex
+	cnt   int
+	ready chan struct{}
+}
+
+func (d *barrierUserDAO) FindByPhone(ctx context.Context, phone string) (dao.User, error) {
+	u, err := d.UserDAO.FindByPhone(ctx, phone)
+	d.mu.Lock()
+	d.cnt++
+	cnt := d.cnt
+	if cnt == d.n {
+		close(d.ready)
+	}
+	d.mu.Unlock()
+	if cnt <= d.n {
+		<-d.ready
+	}
+	return u, err
+}
+
+func TestCachedUserRepository_FindOrCreate_Concurrent(t *testing.T) {
+	db := newSQLiteDB(t)
+	const n = 20
+	const phone = "15212345678"
+	d := &barrierUserDAO{UserDAO: dao.NewUserDAO(db), n: n, ready: make(chan struct{})}
+	repo := NewUserRepository(d, cache.NewUserMemoryCache())
+
+	var wg sync.WaitGroup
+	users := make([]domain.User, n)
+	errs := make([]error, n)
+	// 同一个手机号同时第一次登录
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			users[i], errs[i] = repo.FindOrCreate(context.Background(), phone)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < n; i++ {
+		require.NoError(t, errs[i])
+		assert.NotZero(t, users[i].Id)
+		assert.Equal(t, users[0].Id, users[i].Id)
+		assert.Equal(t, phone, users[i].Phone)
+	}
+	var cnt int64
+	require.NoError(t, db.Model(&dao.User{}).Where("phone = ?", phone).Count(&cnt).Error)
+	assert.Equal(t, int64(1), cnt)
+}
+
+func TestCachedUserRepository_FindById_Singleflight(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := daomocks.NewMockUserDAO(ctrl)
+	c := cachemocks.NewMockUserCache(ctrl)
+	const n = 10
+
+	// 所有请求都没有命中缓存之后，数据库才返回
+	var missed sync.WaitGroup
+	missed.Add(n)
+	c.EXPECT().Get(gomock.Any(), int64(123)).Times(n).
+		DoAndReturn(func(ctx context.Context, id int64) (domain.User, error) {
+			missed.Done()
+			return domain.User{}, cache.ErrKeyNotExist
+		})
+	d.EXPECT().FindById(gomock.Any(), int64(123)).Times(1).
+		DoAndReturn(func(ctx context.Context, id int64) (dao.User, error) {
+			missed.Wait()
+			// 给最后一个未命中的请求一点时间进入 singleflight
+			time.Sleep(50 * time.Millisecond)
+			return dao.User{Id: 123, Password: "hash"}, nil
+		})
+	c.EXPECT().Set(gomock.Any(), gomock.Any()).Times(1).Return(nil)
+
+	repo := NewUserRepository(d, c)
+	var wg sync.WaitGroup
+	users := make([]domain.User, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			u, err := repo.FindById(context.Background(), 123)
+			assert.NoError(t, err)
+			users[i] = u
+		}(i)
+	}
+	wg.Wait()
+	for _, u := range users {
+		assert.Equal(t, int64(123), u.Id)
+		// 密码不会出现在个人信息和缓存里面
+		assert.Empty(t, u.Password)
+	}
+}
+
+func TestCachedUserRepository_Edit(t *testing.T) {
+	birthday := time.Date(1992, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name    string
+		mock    func(ctrl *gomock.Controller) (dao.UserDAO, cache.UserCache)
+		wantErr error
+	}{
+		{
+			name: "修改成功，删掉缓存",
+			mock: func(ctrl *gomock.Controller) (dao.UserDAO, cache.UserCache) {
+				d := daomocks.NewMockUserDAO(ctrl)
+				c := cachemocks.NewMockUserCache(ctrl)
+				gomock.InOrder(
+					d.EXPECT().UpdateProfile(gomock.Any(), int64(123), int64(2), int64(123), map[string]any{
+						"nickname": "大明",
+						"birthday": sql.NullString{String: "1992-01-01", Valid: true},
+						"abstract": "hello",
+					}).Return(nil),
+					c.EXPECT().Delete(gomock.Any(), int64(123)).Return(nil),
+				)
+				return d, c
+			},
+		},
+		{
+			name: "删缓存失败也算修改成功",
+			mock: func(ctrl *gomock.Controller) (dao.UserDAO, cache.UserCache) {
+				d := daomocks.NewMockUserDAO(ctrl)
+				c := cachemocks.NewMockUserCache(ctrl)
+				d.EXPECT().UpdateProfile(gomock.Any(), int64(123), int64(2), int64(123), gomock.Any()).Return(nil)
+				c.EXPECT().Delete(gomock.Any(), int64(123)).Return(errors.New("redis 崩了"))
+				return d, c
+			},
+		},
+		{
+			name: "版本号冲突，不删缓存",
+			mock: func(ctrl *gomock.Controller) (dao.UserDAO, cache.UserCache) {
+				d := daomocks.NewMockUserDAO(ctrl)
+				d.EXPECT().UpdateProfile(gomock.Any(), int64(123), int64(2), int64(123), gomock.Any()).
+					Return(dao.ErrVersionConflict)
+				return d, cachemocks.NewMockUserCache(ctrl)
+			},
+			wantErr: ErrVersionConflict,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			repo := NewUserRepository(tc.mock(ctrl))
+			err := repo.Edit(context.Background(), 123, domain.User{
+				Nickname: "大明",
+				Birthday: birthday,
+				Abstract: "hello",
+				Version:  2,
+			})
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
+
+func TestCachedUserRepository_EditInvalidatesCache(t *testing.T) {
+	db := newSQLiteDB(t)
+	repo := NewUserRepository(dao.NewUserDAO(db), cache.NewUserMemoryCache())
+	ctx := context.Background()
+	require.NoError(t, repo.Create(ctx, domain.User{Email: "123@qq.com", Password: "hash"}))
+	u, err := repo.FindByEmail(ctx, "123@qq.com")
+	require.NoError(t, err)
+
+	// 第一次查询之后个人信息进了缓存
+	before, err := repo.FindById(ctx, u.Id)
+	require.NoError(t, err)
+	assert.Empty(t, before.Nickname)
+	require.NoError(t, repo.Edit(ctx, u.Id, domain.User{Nickname: "大明", Version: before.Version}))
+	after, err := repo.FindById(ctx, u.Id)
+	require.NoError(t, err)
+	assert.Equal(t, "大明", after.Nickname)
+	assert.Equal(t, before.Version+1, after.Version)
+}
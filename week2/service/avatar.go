@@ -0,0 +1,179 @@
+package service
+
+import (
+	"awesomeProject/webook/internal/domain"
+	"awesomeProject/webook/internal/repository"
+	"awesomeProject/webook/internal/service/blob"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// MaxAvatarSize 头像文件最大 5MB
+const MaxAvatarSize = 5 << 20
+
+// 头像的宽和高都不能超过这么多像素，防止很小的文件解码出来占用大量内存
+const maxAvatarSide = 4096
+
+// AvatarThumbnailSizes 上传头像的时候生成的缩略图尺寸，都是正方形
+var AvatarThumbnailSizes = []int{64, 256}
+
+var (
+	ErrAvatarTooLarge        = errors.New("头像文件太大")
+	ErrAvatarUnsupportedType = errors.New("头像只支持 JPEG、PNG、GIF 和 WebP 格式")
+	ErrAvatarInvalidImage    = errors.New("头像不是合法的图片")
+)
+
+// avatarTypes 支持的头像格式，按照文件内容识别，不相信客户端传的 Content-Type
+var avatarTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+//go:generate mockgen -source=./avatar.go -package=svcmocks -destination=./mocks/avatar.mock.go
+type AvatarService interface {
+	// Upload 上传头像，返回修改之后的个人信息
+	Upload(ctx context.Context, uid int64, data []byte) (domain.User, error)
+	// URL 头像的访问地址，size 是缩略图的尺寸，0 表示原图
+	URL(key string, size int) string
+}
+
+type avatarService struct {
+	repo    repository.UserRepository
+	storage blob.Storage
+}
+
+func NewAvatarService(repo repository.UserRepository, storage blob.Storage) AvatarService {
+	return &avatarService{
+		repo:    repo,
+		storage: storage,
+	}
+}
+
+// Upload 原图和缩略图都重新编码一遍再保存，顺便去掉了 EXIF 里面的位置信息之类的元数据。
+// JPEG 还是存成 JPEG，其他格式存成 PNG，保留透明背景，GIF 动图只保留第一帧
+func (svc *avatarService) Upload(ctx context.Context, uid int64, data []byte) (domain.User, error) {
+	if len(data) > MaxAvatarSize {
+		return domain.User{}, ErrAvatarTooLarge
+	}
+	contentType := http.DetectContentType(data)
+	if !avatarTypes[contentType] {
+		return domain.User{}, ErrAvatarUnsupportedType
+	}
+	// 先只解析宽高，太大了就不用解码了
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || cfg.Width <= 0 || cfg.Height <= 0 {
+		return domain.User{}, ErrAvatarInvalidImage
+	}
+	if cfg.Width > maxAvatarSide || cfg.Height > maxAvatarSide {
+		return domain.User{}, ErrAvatarTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return domain.User{}, ErrAvatarInvalidImage
+	}
+
+	old, err := svc.repo.FindById(ctx, uid)
+	if err == repository.ErrUserNotFound {
+		return domain.User{}, ErrInvalidUserNotFund
+	}
+	if err != nil {
+		return domain.User{}, err
+	}
+
+	// 用内容的哈希做文件名，内容变了 URL 就变了，CDN 和浏览器可以放心地长时间缓存
+	sum := sha256.Sum256(data)
+	ext := ".png"
+	if contentType == "image/jpeg" {
+		ext = ".jpg"
+	}
+	key := fmt.Sprintf("avatars/%d/%s%s", uid, hex.EncodeToString(sum[:8]), ext)
+	if err = svc.put(ctx, key, img); err != nil {
+		return domain.User{}, err
+	}
+	for _, size := range AvatarThumbnailSizes {
+		if err = svc.put(ctx, thumbnailKey(key, size), thumbnail(img, size)); err != nil {
+			return domain.User{}, err
+		}
+	}
+
+	err = svc.repo.UpdateProfile(ctx, uid, domain.UserProfilePatch{
+		Avatar: &key,
+	})
+	if err == repository.ErrUserNotFound {
+		return domain.User{}, ErrInvalidUserNotFund
+	}
+	if err != nil {
+		return domain.User{}, err
+	}
+	// 旧的头像没有人用了，删不掉也不影响，最多浪费一点空间
+	if old.Avatar != "" && old.Avatar != key {
+		svc.deleteAvatar(ctx, old.Avatar)
+	}
+	return svc.repo.FindById(ctx, uid)
+}
+
+func (svc *avatarService) URL(key string, size int) string {
+	if size > 0 {
+		key = thumbnailKey(key, size)
+	}
+	return svc.storage.URL(key)
+}
+
+// put 按照 key 的扩展名编码图片并保存
+func (svc *avatarService) put(ctx context.Context, key string, img image.Image) error {
+	var (
+		buf         bytes.Buffer
+		err         error
+		contentType string
+	)
+	if path.Ext(key) == ".jpg" {
+		contentType = "image/jpeg"
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90})
+	} else {
+		contentType = "image/png"
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		return err
+	}
+	return svc.storage.Put(ctx, key, buf.Bytes(), contentType)
+}
+
+func (svc *avatarService) deleteAvatar(ctx context.Context, key string) {
+	_ = svc.storage.Delete(ctx, key)
+	for _, size := range AvatarThumbnailSizes {
+		_ = svc.storage.Delete(ctx, thumbnailKey(key, size))
+	}
+}
+
+// thumbnailKey 缩略图的 key，比如 avatars/1/abc.png 的 64 像素缩略图是 avatars/1/abc_64.png
+func thumbnailKey(key string, size int) string {
+	ext := path.Ext(key)
+	return fmt.Sprintf("%s_%d%s", strings.TrimSuffix(key, ext), size, ext)
+}
+
+// thumbnail 从中间裁剪出最大的正方形，再缩放成 size x size
+func thumbnail(src image.Image, size int) image.Image {
+	b := src.Bounds()
+	side := min(b.Dx(), b.Dy())
+	x0 := b.Min.X + (b.Dx()-side)/2
+	y0 := b.Min.Y + (b.Dy()-side)/2
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, image.Rect(x0, y0, x0+side, y0+side), draw.Src, nil)
+	return dst
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"awesomeProject/webook/internal/domain"
+	"awesomeProject/webook/internal/repository"
+	repomocks "awesomeProject/webook/internal/repository/mocks"
+	"awesomeProject/webook/internal/service/blob/local"
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newPNG 生成一张 width x height 的纯色 PNG
+func newPNG(t *testing.T, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{R: 255, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func TestAvatarService_Upload(t *testing.T) {
+	data := newPNG(t, 300, 200)
+	// 上传之后 FindById 返回的头像，真正的 key 由内容的哈希决定
+	const key = "avatars/123/new.png"
+
+	testCases := []struct {
+		name string
+		mock func(ctrl *gomock.Controller) repository.UserRepository
+		data []byte
+		// 上传之前已经存在的文件
+		before   []string
+		wantUser domain.User
+		wantErr  error
+		// 上传之后应该被删掉的文件
+		wantDeleted []string
+	}{
+		{
+			name: "上传成功，删除旧的头像",
+			mock: func(ctrl *gomock.Controller) repository.UserRepository {
+				repo := repomocks.NewMockUserRepository(ctrl)
+				repo.EXPECT().FindById(gomock.Any(), int64(123)).
+					Return(domain.User{Id: 123, Avatar: "avatars/123/old.png"}, nil)
+				repo.EXPECT().UpdateProfile(gomock.Any(), int64(123), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, id int64, patch domain.UserProfilePatch) error {
+						require.NotNil(t, patch.Avatar)
+						assert.Regexp(t, `^avatars/123/[0-9a-f]{16}\.png$`, *patch.Avatar)
+						return nil
+					})
+				repo.EXPECT().FindById(gomock.Any(), int64(123)).
+					Return(domain.User{Id: 123, Avatar: key}, nil)
+				return repo
+			},
+			data:        data,
+			before:      []string{"avatars/123/old.png", "avatars/123/old_64.png", "avatars/123/old_256.png"},
+			wantUser:    domain.User{Id: 123, Avatar: key},
+			wantDeleted: []string{"avatars/123/old.png", "avatars/123/old_64.png", "avatars/123/old_256.png"},
+		},
+		{
+			name: "不是图片",
+			mock: func(ctrl *gomock.Controller) repository.UserRepository {
+				return repomocks.NewMockUserRepository(ctrl)
+			},
+			data:    []byte("<html><body>hello</body></html>"),
+			wantErr: ErrAvatarUnsupportedType,
+		},
+		{
+			name: "看起来是 PNG，但是解析不了",
+			mock: func(ctrl *gomock.Controller) repository.UserRepository {
+				return repomocks.NewMockUserRepository(ctrl)
+			},
+			data:    append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 64)...),
+			wantErr: ErrAvatarInvalidImage,
+		},
+		{
+			name: "图片太宽了",
+			mock: func(ctrl *gomock.Controller) repository.UserRepository {
+				return repomocks.NewMockUserRepository(ctrl)
+			},
+			data:    newPNG(t, maxAvatarSide+1, 1),
+			wantErr: ErrAvatarTooLarge,
+		},
+		{
+			name: "文件太大了",
+			mock: func(ctrl *gomock.Controller) repository.UserRepository {
+				return repomocks.NewMockUserRepository(ctrl)
+			},
+			data:    make([]byte, MaxAvatarSize+1),
+			wantErr: ErrAvatarTooLarge,
+		},
+		{
+			name: "用户不存在",
+			mock: func(ctrl *gomock.Controller) repository.UserRepository {
+				repo := repomocks.NewMockUserRepository(ctrl)
+				repo.EXPECT().FindById(gomock.Any(), int64(123)).
+					Return(domain.User{}, repository.ErrUserNotFound)
+				return repo
+			},
+			data:    data,
+			wantErr: ErrInvalidUserNotFund,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			dir := t.TempDir()
+			storage := local.NewStorage(dir, "/uploads")
+			for _, f := range tc.before {
+				require.NoError(t, storage.Put(context.Background(), f, []byte("old"), "image/png"))
+			}
+			svc := NewAvatarService(tc.mock(ctrl), storage)
+			u, err := svc.Upload(context.Background(), 123, tc.data)
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantUser, u)
+			for _, f := range tc.wantDeleted {
+				_, err = os.Stat(filepath.Join(dir, f))
+				assert.True(t, os.IsNotExist(err), f)
+			}
+			if tc.wantErr != nil {
+				return
+			}
+			// 原图和缩略图都存下来了，缩略图是正方形
+			entries, err := filepath.Glob(filepath.Join(dir, "avatars", "123", "*.png"))
+			require.NoError(t, err)
+			assert.Len(t, entries, 1+len(AvatarThumbnailSizes))
+			for _, size := range AvatarThumbnailSizes {
+				// 排序之后第一个是原图
+				name, err := filepath.Rel(dir, entries[0])
+				require.NoError(t, err)
+				f, err := os.Open(filepath.Join(dir, thumbnailKey(filepath.ToSlash(name), size)))
+				require.NoError(t, err)
+				cfg, _, err := image.DecodeConfig(f)
+				_ = f.Close()
+				require.NoError(t, err)
+				assert.Equal(t, size, cfg.Width)
+				assert.Equal(t, size, cfg.Height)
+			}
+		})
+	}
+}
+
+func TestAvatarService_URL(t *testing.T) {
+	svc := NewAvatarService(nil, local.NewStorage(t.TempDir(), "https://cdn.example.com/"))
+	assert.Equal(t, "https://cdn.example.com/avatars/1/abc.jpg", svc.URL("avatars/1/abc.jpg", 0))
+	assert.Equal(t, "https://cdn.example.com/avatars/1/abc_64.jpg", svc.URL("avatars/1/abc.jpg", 64))
+}
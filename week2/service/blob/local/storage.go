@@ -0,0 +1,79 @@
+package local
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var errInvalidKey = errors.New("文件的 key 不合法")
+
+// Storage 把文件存在本地磁盘上，开发环境或者单机部署用
+// 文件通过 urlPrefix 对外提供访问，比如 gin 的 Static 或者前面的 Nginx
+type Storage struct {
+	dir       string
+	urlPrefix string
+}
+
+func NewStorage(dir, urlPrefix string) *Storage {
+	return &Storage{
+		dir:       dir,
+		urlPrefix: strings.TrimSuffix(urlPrefix, "/"),
+	}
+}
+
+// Put 先写临时文件再改名，读的人不会看到写了一半的文件
+func (s *Storage) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	name, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(name), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	// 改名成功之后这里的删除会失败，不影响
+	defer os.Remove(f.Name())
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+func (s *Storage) Delete(ctx context.Context, key string) error {
+	name, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *Storage) URL(key string) string {
+	return s.urlPrefix + "/" + key
+}
+
+// path 把 key 转换成磁盘上的路径，不允许跳出 dir
+func (s *Storage) path(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || path.Clean(key) != key ||
+		key == ".." || strings.HasPrefix(key, "../") {
+		return "", errInvalidKey
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
@@ -0,0 +1,42 @@
+package local
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStorage(t *testing.T) {
+	dir := t.TempDir()
+	s := NewStorage(dir, "/uploads/")
+	ctx := context.Background()
+
+	require.NoError(t, s.Put(ctx, "avatars/1/abc.png", []byte("v1"), "image/png"))
+	// 覆盖写
+	require.NoError(t, s.Put(ctx, "avatars/1/abc.png", []byte("v2"), "image/png"))
+	data, err := os.ReadFile(filepath.Join(dir, "avatars", "1", "abc.png"))
+	require.NoError(t, err)
+	assert.Equal(t, "v2", string(data))
+	assert.Equal(t, "/uploads/avatars/1/abc.png", s.URL("avatars/1/abc.png"))
+
+	require.NoError(t, s.Delete(ctx, "avatars/1/abc.png"))
+	_, err = os.Stat(filepath.Join(dir, "avatars", "1", "abc.png"))
+	assert.True(t, os.IsNotExist(err))
+	// 删除不存在的文件不算错误
+	assert.NoError(t, s.Delete(ctx, "avatars/1/abc.png"))
+	// 临时文件都清理掉了
+	entries, err := os.ReadDir(filepath.Join(dir, "avatars", "1"))
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestStorage_InvalidKey(t *testing.T) {
+	s := NewStorage(t.TempDir(), "/uploads")
+	for _, key := range []string{"", "/etc/passwd", "../secret", "avatars/../../secret", "avatars//1.png", ".."} {
+		assert.Equal(t, errInvalidKey, s.Put(context.Background(), key, []byte("x"), "text/plain"), key)
+		assert.Equal(t, errInvalidKey, s.Delete(context.Background(), key), key)
+	}
+}
@@ -0,0 +1,16 @@
+package blob
+
+import "context"
+
+// Storage 存文件的抽象，可以是本地磁盘，也可以是对象存储
+// key 是文件在存储里面的路径，比如 avatars/1/abc.png，用 / 分隔
+//
+//go:generate mockgen -source=./types.go -package=blobmocks -destination=./mocks/blob.mock.go
+type Storage interface {
+	// Put 保存文件，key 已经存在就覆盖
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Delete 删除文件，文件不存在不算错误
+	Delete(ctx context.Context, key string) error
+	// URL 返回前端访问这个文件用的地址
+	URL(key string) string
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"awesomeProject/webook/internal/repository"
+	"awesomeProject/webook/internal/service/sms"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+)
+
+// 短信模板 id
+const codeTplId = "1877556"
+
+var (
+	ErrCodeSendTooMany        = repository.ErrCodeSendTooMany
+	ErrCodeVerifyTooManyTimes = repository.ErrCodeVerifyTooManyTimes
+)
+
+//go:generate mockgen -source=./code.go -package=svcmocks -destination=./mocks/code.mock.go
+type CodeService interface {
+	Send(ctx context.Context, biz, phone string) error
+	Verify(ctx context.Context, biz, phone, inputCode string) (bool, error)
+}
+
+type codeService struct {
+	repo   repository.CodeRepository
+	smsSvc sms.Service
+}
+
+func NewCodeService(repo repository.CodeRepository, smsSvc sms.Service) CodeService {
+	return &codeService{
+		repo:   repo,
+		smsSvc: smsSvc,
+	}
+}
+
+// Send 生成一个验证码，存起来再发出去
+// biz 区分业务场景，比如登录和找回密码的验证码互不影响
+func (svc *codeService) Send(ctx context.Context, biz, phone string) error {
+	code, err := generateCode()
+	if err != nil {
+		return err
+	}
+	err = svc.repo.Store(ctx, biz, phone, code)
+	if err != nil {
+		return err
+	}
+	return svc.smsSvc.Send(ctx, codeTplId, []string{code}, phone)
+}
+
+func (svc *codeService) Verify(ctx context.Context, biz, phone, inputCode string) (bool, error) {
+	return svc.repo.Verify(ctx, biz, phone, inputCode)
+}
+
+// generateCode 六位数字，不足的前面补 0
+// 验证码能登录和找回密码，要用 crypto/rand，不能让人猜出下一个验证码
+func generateCode() (string, error) {
+	num, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", num.Int64()), nil
+}
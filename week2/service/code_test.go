@@ -0,0 +1,109 @@
+package service
+
+import (
+	repomocks "awesomeProject/webook/internal/repository/mocks"
+	smsmocks "awesomeProject/webook/internal/service/sms/mocks"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"regexp"
+	"testing"
+)
+
+func TestCodeService_Send(t *testing.T) {
+	testCases := []struct {
+		name    string
+		mock    func(ctrl *gomock.Controller) (*repomocks.MockCodeRepository, *smsmocks.MockService)
+		wantErr error
+	}{
+		{
+			name: "发送成功，短信里面是存起来的验证码",
+			mock: func(ctrl *gomock.Controller) (*repomocks.MockCodeRepository, *smsmocks.MockService) {
+				repo := repomocks.NewMockCodeRepository(ctrl)
+				smsSvc := smsmocks.NewMockService(ctrl)
+				var stored string
+				gomock.InOrder(
+					repo.EXPECT().Store(gomock.Any(), "login", "15212345678", gomock.Any()).
+						DoAndReturn(func(ctx context.Context, biz, phone, code string) error {
+							stored = code
+							return nil
+						}),
+					smsSvc.EXPECT().Send(gomock.Any(), codeTplId, gomock.Any(), "15212345678").
+						DoAndReturn(func(ctx context.Context, tpl string, args []string, numbers ...string) error {
+							assert.Equal(t, []string{stored}, args)
+							return nil
+						}),
+				)
+				return repo, smsSvc
+			},
+		},
+		{
+			name: "发送太频繁，不发短信",
+			mock: func(ctrl *gomock.Controller) (*repomocks.MockCodeRepository, *smsmocks.MockService) {
+				repo := repomocks.NewMockCodeRepository(ctrl)
+				repo.EXPECT().Store(gomock.Any(), "login", "15212345678", gomock.Any()).Return(ErrCodeSendTooMany)
+				return repo, smsmocks.NewMockService(ctrl)
+			},
+			wantErr: ErrCodeSendTooMany,
+		},
+		{
+			name: "短信发送失败",
+			mock: func(ctrl *gomock.Controller) (*repomocks.MockCodeRepository, *smsmocks.MockService) {
+				repo := repomocks.NewMockCodeRepository(ctrl)
+				smsSvc := smsmocks.NewMockService(ctrl)
+				repo.EXPECT().Store(gomock.Any(), "login", "15212345678", gomock.Any()).Return(nil)
+				smsSvc.EXPECT().Send(gomock.Any(), codeTplId, gomock.Any(), "15212345678").
+					Return(errors.New("短信网关返回 502"))
+				return repo, smsSvc
+			},
+			wantErr: errors.New("短信网关返回 502"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			svc := NewCodeService(tc.mock(ctrl))
+			err := svc.Send(context.Background(), "login", "15212345678")
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
+
+func TestCodeService_Verify(t *testing.T) {
+	testCases := []struct {
+		name    string
+		ok      bool
+		err     error
+		wantOk  bool
+		wantErr error
+	}{
+		{name: "验证码正确", ok: true, wantOk: true},
+		{name: "验证码不对"},
+		{name: "验证次数太多", err: ErrCodeVerifyTooManyTimes, wantErr: ErrCodeVerifyTooManyTimes},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			repo := repomocks.NewMockCodeRepository(ctrl)
+			repo.EXPECT().Verify(gomock.Any(), "login", "15212345678", "123456").Return(tc.ok, tc.err)
+			ok, err := NewCodeService(repo, smsmocks.NewMockService(ctrl)).
+				Verify(context.Background(), "login", "15212345678", "123456")
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantOk, ok)
+		})
+	}
+}
+
+func TestGenerateCode(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		code, err := generateCode()
+		require.NoError(t, err)
+		assert.Regexp(t, regexp.MustCompile(`^\d{6}$`), code)
+	}
+}
@@ -0,0 +1,59 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Service 本地的邮件实现，不真的发邮件，每封邮件写成 outbox 目录下的一个 .eml 文件
+// 开发环境用邮件客户端直接打开就能看到，整个验证邮箱的流程可以离线跑通
+type Service struct {
+	dir string
+	// 同一毫秒发了好几封，用序号区分文件名
+	seq atomic.Int64
+	now func() time.Time
+}
+
+func NewService(dir string) *Service {
+	return &Service{
+		dir: dir,
+		now: time.Now,
+	}
+}
+
+func (s *Service) Send(ctx context.Context, to, subject, body string) error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	// 换行会被当成新的邮件头，去掉
+	to, subject = oneLine.Replace(to), oneLine.Replace(subject)
+	now := s.now()
+	name := fmt.Sprintf("%s-%d-%s.eml", now.Format("20060102T150405.000"), s.seq.Add(1), safeName(to))
+	content := fmt.Sprintf("To: %s\r\nSubject: %s\r\nDate: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n",
+		to, subject, now.Format(time.RFC1123Z), body)
+	path := filepath.Join(s.dir, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		return err
+	}
+	log.Printf("模拟发送邮件 to: %s, subject: %s, 保存在 %s", to, subject, path)
+	return nil
+}
+
+var oneLine = strings.NewReplacer("\r", "", "\n", "")
+
+// safeName 邮箱地址里面不适合放进文件名的字符都换成下划线
+func safeName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '@', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, s)
+}
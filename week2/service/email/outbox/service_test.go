@@ -0,0 +1,28 @@
+package outbox
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestService_Send(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outbox")
+	s := NewService(dir)
+	require.NoError(t, s.Send(context.Background(), "123@qq.com", "验证邮箱\r\nBcc: 456@qq.com", "hello"))
+	require.NoError(t, s.Send(context.Background(), "../123@qq.com", "验证邮箱", "hello again"))
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	data, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	// 主题里面的换行被去掉了，不会多出一个邮件头
+	assert.Contains(t, string(data), "Subject: 验证邮箱Bcc: 456@qq.com\r\n")
+	assert.Contains(t, string(data), "\r\n\r\nhello\r\n")
+	// 文件名里面不会有路径分隔符
+	assert.Equal(t, dir, filepath.Dir(files[1]))
+}
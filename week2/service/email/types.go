@@ -0,0 +1,10 @@
+package email
+
+import "context"
+
+// Service 发送邮件的抽象，具体用 SMTP 还是邮件服务商由实现决定
+//
+//go:generate mockgen -source=./types.go -package=emailmocks -destination=./mocks/email.mock.go
+type Service interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"awesomeProject/webook/internal/repository"
+	"awesomeProject/webook/internal/service/email"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// 验证链接的有效期
+const emailVerifyTokenExpiration = 24 * time.Hour
+
+// 验证邮箱的 token 的 audience，和登录用的 JWT 区分开
+const emailVerifyAudience = "verify_email"
+
+var (
+	ErrInvalidEmailVerifyToken = errors.New("验证链接无效或者已经过期")
+	ErrEmailAlreadyVerified    = errors.New("邮箱已经验证过了")
+)
+
+//go:generate mockgen -source=./email_verify.go -package=svcmocks -destination=./mocks/email_verify.mock.go
+type EmailVerifyService interface {
+	// Send 给这个邮箱注册的用户发送验证邮件，已经验证过返回 ErrEmailAlreadyVerified
+	Send(ctx context.Context, email string) error
+	// Verify 校验验证链接里面的 token，通过之后把邮箱标记为已验证
+	Verify(ctx context.Context, token string) error
+}
+
+// emailVerifyClaims 验证邮箱的 token 里面放的东西
+// 带上邮箱，用户改了邮箱之后，发给旧邮箱的链接就失效了
+type emailVerifyClaims struct {
+	jwt.RegisteredClaims
+	Email string `json:"email"`
+}
+
+type emailVerifyService struct {
+	repo     repository.UserRepository
+	emailSvc email.Service
+	// 签名 token 用的密钥，不要和登录的 JWT 共用
+	key []byte
+	// 验证链接的地址，token 作为查询参数拼在后面
+	verifyURL string
+	now       func() time.Time
+}
+
+func NewEmailVerifyService(repo repository.UserRepository, emailSvc email.Service,
+	key []byte, verifyURL string) EmailVerifyService {
+	return &emailVerifyService{
+		repo:      repo,
+		emailSvc:  emailSvc,
+		key:       key,
+		verifyURL: verifyURL,
+		now:       time.Now,
+	}
+}
+
+func (svc *emailVerifyService) Send(ctx context.Context, email string) error {
+	u, err := svc.repo.FindByEmail(ctx, email)
+	if err == repository.ErrUserNotFound {
+		return ErrInvalidUserNotFund
+	}
+	if err != nil {
+		return err
+	}
+	if u.EmailVerified {
+		return ErrEmailAlreadyVerified
+	}
+	now := svc.now()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, emailVerifyClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.FormatInt(u.Id, 10),
+			Audience:  jwt.ClaimStrings{emailVerifyAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(emailVerifyTokenExpiration)),
+		},
+		Email: u.Email,
+	}).SignedString(svc.key)
+	if err != nil {
+		return err
+	}
+	link, err := svc.link(token)
+	if err != nil {
+		return err
+	}
+	body := fmt.Sprintf("欢迎注册 webook，请在 24 小时之内点击下面的链接验证邮箱：\n\n%s\n\n如果不是你本人注册的，请忽略这封邮件。", link)
+	return svc.emailSvc.Send(ctx, u.Email, "请验证你的 webook 邮箱", body)
+}
+
+func (svc *emailVerifyService) Verify(ctx context.Context, token string) error {
+	var claims emailVerifyClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(t *jwt.Token) (interface{}, error) {
+		return svc.key, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithAudience(emailVerifyAudience),
+		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(svc.now))
+	if err != nil {
+		return ErrInvalidEmailVerifyToken
+	}
+	uid, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil {
+		return ErrInvalidEmailVerifyToken
+	}
+	err = svc.repo.MarkEmailVerified(ctx, uid, claims.Email)
+	if err == repository.ErrUserNotFound {
+		// 用户不存在，或者已经换了邮箱
+		return ErrInvalidEmailVerifyToken
+	}
+	return err
+}
+
+// link 把 token 拼到验证链接的查询参数里面
+func (svc *emailVerifyService) link(token string) (string, error) {
+	u, err := url.Parse(svc.verifyURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"awesomeProject/webook/internal/domain"
+	"awesomeProject/webook/internal/repository"
+	repomocks "awesomeProject/webook/internal/repository/mocks"
+	emailmocks "awesomeProject/webook/internal/service/email/mocks"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// sendVerifyEmail 发送一封验证邮件，返回链接里面的 token
+func sendVerifyEmail(t *testing.T, ctrl *gomock.Controller, repo *repomocks.MockUserRepository,
+	key string, now time.Time) string {
+	emailSvc := emailmocks.NewMockService(ctrl)
+	var body string
+	emailSvc.EXPECT().Send(gomock.Any(), "123@qq.com", gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, to, subject, b string) error {
+			body = b
+			return nil
+		})
+	repo.EXPECT().FindByEmail(gomock.Any(), "123@qq.com").
+		Return(domain.User{Id: 123, Email: "123@qq.com"}, nil)
+	svc := NewEmailVerifyService(repo, emailSvc, []byte(key), "http://localhost:8080/users/verify_email")
+	svc.(*emailVerifyService).now = func() time.Time { return now }
+	require.NoError(t, svc.Send(context.Background(), "123@qq.com"))
+
+	link := regexp.MustCompile(`http://\S+`).FindString(body)
+	u, err := url.Parse(link)
+	require.NoError(t, err)
+	assert.Equal(t, "/users/verify_email", u.Path)
+	return u.Query().Get("token")
+}
+
+func TestEmailVerifyService_Verify(t *testing.T) {
+	const key = "verify-key"
+	now := time.Now()
+
+	testCases := []struct {
+		name string
+		mock func(ctrl *gomock.Controller, repo *repomocks.MockUserRepository)
+		// 用什么密钥签发 token
+		signKey string
+		// 签发之后过了多久再验证
+		after   time.Duration
+		wantErr error
+	}{
+		{
+			name: "验证成功",
+			mock: func(ctrl *gomock.Controller, repo *repomocks.MockUserRepository) {
+				repo.EXPECT().MarkEmailVerified(gomock.Any(), int64(123), "123@qq.com").Return(nil)
+			},
+			signKey: key,
+			after:   time.Hour,
+		},
+		{
+			name:    "过期了",
+			mock:    func(ctrl *gomock.Controller, repo *repomocks.MockUserRepository) {},
+			signKey: key,
+			after:   25 * time.Hour,
+			wantErr: ErrInvalidEmailVerifyToken,
+		},
+		{
+			name:    "密钥不对",
+			mock:    func(ctrl *gomock.Controller, repo *repomocks.MockUserRepository) {},
+			signKey: "other-key",
+			wantErr: ErrInvalidEmailVerifyToken,
+		},
+		{
+			name: "已经换了邮箱",
+			mock: func(ctrl *gomock.Controller, repo *repomocks.MockUserRepository) {
+				repo.EXPECT().MarkEmailVerified(gomock.Any(), int64(123), "123@qq.com").
+					Return(repository.ErrUserNotFound)
+			},
+			signKey: key,
+			wantErr: ErrInvalidEmailVerifyToken,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			repo := repomocks.NewMockUserRepository(ctrl)
+			token := sendVerifyEmail(t, ctrl, repo, tc.signKey, now)
+			tc.mock(ctrl, repo)
+
+			svc := NewEmailVerifyService(repo, nil, []byte(key), "")
+			svc.(*emailVerifyService).now = func() time.Time { return now.Add(tc.after) }
+			err := svc.Verify(context.Background(), token)
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
+
+func TestEmailVerifyService_Send(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	repo := repomocks.NewMockUserRepository(ctrl)
+	repo.EXPECT().FindByEmail(gomock.Any(), "123@qq.com").
+		Return(domain.User{Id: 123, Email: "123@qq.com", EmailVerified: true}, nil)
+	svc := NewEmailVerifyService(repo, emailmocks.NewMockService(ctrl), []byte("key"), "")
+	// 已经验证过了就不再发了
+	assert.Equal(t, ErrEmailAlreadyVerified, svc.Send(context.Background(), "123@qq.com"))
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"awesomeProject/webook/internal/repository"
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// LoginLockedError 登录失败次数太多，RetryAfter 之后才能再试
+type LoginLockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginLockedError) Error() string {
+	return fmt.Sprintf("登录失败次数太多，请 %d 秒之后再试", e.Seconds())
+}
+
+// Seconds 向上取整，告诉用户还要等几秒
+func (e *LoginLockedError) Seconds() int64 {
+	return int64(math.Ceil(e.RetryAfter.Seconds()))
+}
+
+const (
+	// loginFailWindow 这么久没有再失败，失败次数就清零
+	loginFailWindow  = 15 * time.Minute
+	loginBackoffBase = time.Second
+	loginBackoffMax  = 5 * time.Minute
+)
+
+// loginRule 前 free 次失败不限制，之后每失败一次等待时间翻倍，失败 lockAfter 次直接锁定 lockout
+type loginRule struct {
+	free      int
+	lockAfter int
+	lockout   time.Duration
+}
+
+var (
+	emailLoginRule = loginRule{free: 3, lockAfter: 10, lockout: 15 * time.Minute}
+	// 一个 IP 后面可能有很多用户，放宽一点
+	ipLoginRule = loginRule{free: 20, lockAfter: 100, lockout: 15 * time.Minute}
+)
+
+// delay 失败 cnt 次之后要等多久才能再试，0 表示不用等
+func (r loginRule) delay(cnt int) time.Duration {
+	if cnt >= r.lockAfter {
+		return r.lockout
+	}
+	if cnt <= r.free {
+		return 0
+	}
+	d := loginBackoffBase
+	for i := r.free + 1; i < cnt && d < loginBackoffMax; i++ {
+		d *= 2
+	}
+	if d > loginBackoffMax {
+		d = loginBackoffMax
+	}
+	return d
+}
+
+//go:generate mockgen -source=./login_guard.go -package=svcmocks -destination=./mocks/login_guard.mock.go
+type LoginGuardService interface {
+	// Check 登录之前检查，邮箱或者 IP 被锁定了返回 *LoginLockedError
+	Check(ctx context.Context, email, ip string) error
+	// Fail 记一次失败，失败多了按照规则锁定
+	Fail(ctx context.Context, email, ip string) error
+	// Unlock 清掉这个邮箱的失败记录，登录成功和管理员解锁的时候用
+	Unlock(ctx context.Context, email string) error
+}
+
+type loginGuardService struct {
+	repo repository.LoginAttemptRepository
+	now  func() time.Time
+}
+
+func NewLoginGuardService(repo repository.LoginAttemptRepository) LoginGuardService {
+	return &loginGuardService{
+		repo: repo,
+		now:  time.Now,
+	}
+}
+
+func (svc *loginGuardService) Check(ctx context.Context, email, ip string) error {
+	now := svc.now()
+	for _, key := range loginKeys(email, ip) {
+		until, err := svc.repo.LockedUntil(ctx, key)
+		if err != nil {
+			return err
+		}
+		if until.After(now) {
+			return &LoginLockedError{RetryAfter: until.Sub(now)}
+		}
+	}
+	return nil
+}
+
+func (svc *loginGuardService) Fail(ctx context.Context, email, ip string) error {
+	keys := loginKeys(email, ip)
+	rules := []loginRule{emailLoginRule, ipLoginRule}
+	for i, key := range keys {
+		cnt, err := svc.repo.IncrFail(ctx, key, loginFailWindow)
+		if err != nil {
+			return err
+		}
+		d := rules[i].delay(cnt)
+		if d == 0 {
+			continue
+		}
+		err = svc.repo.Lock(ctx, key, svc.now().Add(d))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Unlock 只清邮箱的记录，IP 的不清，不然攻击者拿自己的账号登录一次就能把 IP 的记录清掉
+func (svc *loginGuardService) Unlock(ctx context.Context, email string) error {
+	return svc.repo.Reset(ctx, emailLoginKey(email))
+}
+
+// loginKeys 第一个是邮箱，第二个是 IP，拿不到 IP 就只有邮箱
+func loginKeys(email, ip string) []string {
+	keys := []string{emailLoginKey(email)}
+	if ip != "" {
+		keys = append(keys, "ip:"+ip)
+	}
+	return keys
+}
+
+// emailLoginKey 大小写不同也算同一个邮箱，不然换个大小写就能绕过去
+func emailLoginKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
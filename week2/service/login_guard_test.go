@@ -0,0 +1,159 @@
+package service
+
+import (
+	repomocks "awesomeProject/webook/internal/repository/mocks"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+	"testing"
+	"time"
+)
+
+func TestLoginRule_Delay(t *testing.T) {
+	testCases := []struct {
+		name string
+		rule loginRule
+		cnt  int
+		want time.Duration
+	}{
+		{name: "免费次数以内", rule: emailLoginRule, cnt: 3},
+		{name: "第一次退避", rule: emailLoginRule, cnt: 4, want: time.Second},
+		{name: "翻倍", rule: emailLoginRule, cnt: 6, want: 4 * time.Second},
+		{name: "达到阈值锁定", rule: emailLoginRule, cnt: 10, want: 15 * time.Minute},
+		{name: "超过阈值还是锁定", rule: emailLoginRule, cnt: 11, want: 15 * time.Minute},
+		{name: "退避有上限", rule: ipLoginRule, cnt: 99, want: loginBackoffMax},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, tc.rule.delay(tc.cnt))
+		})
+	}
+}
+
+func TestLoginGuardService_Check(t *testing.T) {
+	now := time.Now()
+
+	testCases := []struct {
+		name    string
+		mock    func(repo *repomocks.MockLoginAttemptRepository)
+		ip      string
+		wantErr error
+	}{
+		{
+			name: "没有锁定",
+			mock: func(repo *repomocks.MockLoginAttemptRepository) {
+				repo.EXPECT().LockedUntil(gomock.Any(), "email:123@qq.com").Return(time.Time{}, nil)
+				repo.EXPECT().LockedUntil(gomock.Any(), "ip:127.0.0.1").Return(time.Time{}, nil)
+			},
+			ip: "127.0.0.1",
+		},
+		{
+			name: "邮箱被锁定",
+			mock: func(repo *repomocks.MockLoginAttemptRepository) {
+				repo.EXPECT().LockedUntil(gomock.Any(), "email:123@qq.com").Return(now.Add(1500*time.Millisecond), nil)
+			},
+			ip:      "127.0.0.1",
+			wantErr: &LoginLockedError{RetryAfter: 1500 * time.Millisecond},
+		},
+		{
+			name: "IP 被锁定",
+			mock: func(repo *repomocks.MockLoginAttemptRepository) {
+				repo.EXPECT().LockedUntil(gomock.Any(), "email:123@qq.com").Return(time.Time{}, nil)
+				repo.EXPECT().LockedUntil(gomock.Any(), "ip:127.0.0.1").Return(now.Add(time.Minute), nil)
+			},
+			ip:      "127.0.0.1",
+			wantErr: &LoginLockedError{RetryAfter: time.Minute},
+		},
+		{
+			name: "锁定已经过期",
+			mock: func(repo *repomocks.MockLoginAttemptRepository) {
+				repo.EXPECT().LockedUntil(gomock.Any(), "email:123@qq.com").Return(now.Add(-time.Second), nil)
+			},
+		},
+		{
+			name: "缓存出错",
+			mock: func(repo *repomocks.MockLoginAttemptRepository) {
+				repo.EXPECT().LockedUntil(gomock.Any(), "email:123@qq.com").Return(time.Time{}, errors.New("redis 出错"))
+			},
+			wantErr: errors.New("redis 出错"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			repo := repomocks.NewMockLoginAttemptRepository(ctrl)
+			tc.mock(repo)
+			svc := NewLoginGuardService(repo).(*loginGuardService)
+			svc.now = func() time.Time { return now }
+			// 大小写和空格不影响
+			err := svc.Check(context.Background(), " 123@QQ.com", tc.ip)
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
+
+func TestLoginGuardService_Fail(t *testing.T) {
+	now := time.Now()
+
+	testCases := []struct {
+		name    string
+		mock    func(repo *repomocks.MockLoginAttemptRepository)
+		wantErr error
+	}{
+		{
+			name: "免费次数以内不锁定",
+			mock: func(repo *repomocks.MockLoginAttemptRepository) {
+				repo.EXPECT().IncrFail(gomock.Any(), "email:123@qq.com", loginFailWindow).Return(1, nil)
+				repo.EXPECT().IncrFail(gomock.Any(), "ip:127.0.0.1", loginFailWindow).Return(1, nil)
+			},
+		},
+		{
+			name: "邮箱退避，IP 不用",
+			mock: func(repo *repomocks.MockLoginAttemptRepository) {
+				repo.EXPECT().IncrFail(gomock.Any(), "email:123@qq.com", loginFailWindow).Return(5, nil)
+				repo.EXPECT().Lock(gomock.Any(), "email:123@qq.com", now.Add(2*time.Second)).Return(nil)
+				repo.EXPECT().IncrFail(gomock.Any(), "ip:127.0.0.1", loginFailWindow).Return(5, nil)
+			},
+		},
+		{
+			name: "都锁定",
+			mock: func(repo *repomocks.MockLoginAttemptRepository) {
+				repo.EXPECT().IncrFail(gomock.Any(), "email:123@qq.com", loginFailWindow).Return(10, nil)
+				repo.EXPECT().Lock(gomock.Any(), "email:123@qq.com", now.Add(15*time.Minute)).Return(nil)
+				repo.EXPECT().IncrFail(gomock.Any(), "ip:127.0.0.1", loginFailWindow).Return(100, nil)
+				repo.EXPECT().Lock(gomock.Any(), "ip:127.0.0.1", now.Add(15*time.Minute)).Return(nil)
+			},
+		},
+		{
+			name: "缓存出错",
+			mock: func(repo *repomocks.MockLoginAttemptRepository) {
+				repo.EXPECT().IncrFail(gomock.Any(), "email:123@qq.com", loginFailWindow).Return(0, errors.New("redis 出错"))
+			},
+			wantErr: errors.New("redis 出错"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			repo := repomocks.NewMockLoginAttemptRepository(ctrl)
+			tc.mock(repo)
+			svc := NewLoginGuardService(repo).(*loginGuardService)
+			svc.now = func() time.Time { return now }
+			err := svc.Fail(context.Background(), "123@qq.com", "127.0.0.1")
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
+
+func TestLoginGuardService_Unlock(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	repo := repomocks.NewMockLoginAttemptRepository(ctrl)
+	repo.EXPECT().Reset(gomock.Any(), "email:123@qq.com").Return(nil)
+	assert.NoError(t, NewLoginGuardService(repo).Unlock(context.Background(), "123@QQ.com"))
+}
@@ -0,0 +1,89 @@
+package password
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"testing"
+)
+
+// 测试用的参数小一点，跑得快
+var testArgon2idParams = Argon2idParams{
+	Time:    1,
+	Memory:  1024,
+	Threads: 1,
+	KeyLen:  32,
+	SaltLen: 16,
+}
+
+func TestHasher(t *testing.T) {
+	testCases := []struct {
+		name   string
+		hasher Hasher
+		prefix string
+	}{
+		{
+			name:   "bcrypt",
+			hasher: NewBcryptHasher(bcrypt.MinCost),
+			prefix: "$2a$04$",
+		},
+		{
+			name:   "argon2id",
+			hasher: NewArgon2idHasher(testArgon2idParams),
+			prefix: "$argon2id$v=19$m=1024,t=1,p=1$",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			encoded, err := tc.hasher.Hash("hello#world123")
+			require.NoError(t, err)
+			assert.Contains(t, encoded, tc.prefix)
+			assert.NoError(t, tc.hasher.Verify(encoded, "hello#world123"))
+			assert.Equal(t, ErrMismatch, tc.hasher.Verify(encoded, "hello#world456"))
+			assert.False(t, tc.hasher.NeedsRehash(encoded))
+
+			// 同一个密码每次的盐都不一样
+			again, err := tc.hasher.Hash("hello#world123")
+			require.NoError(t, err)
+			assert.NotEqual(t, encoded, again)
+		})
+	}
+}
+
+func TestHasher_CrossAlgorithm(t *testing.T) {
+	bcryptHasher := NewBcryptHasher(bcrypt.MinCost)
+	argon2idHasher := NewArgon2idHasher(testArgon2idParams)
+	bcryptHash, err := bcryptHasher.Hash("hello#world123")
+	require.NoError(t, err)
+	argon2idHash, err := argon2idHasher.Hash("hello#world123")
+	require.NoError(t, err)
+
+	// 换了算法之后老的哈希还能验证，但是要重新加密
+	assert.NoError(t, argon2idHasher.Verify(bcryptHash, "hello#world123"))
+	assert.True(t, argon2idHasher.NeedsRehash(bcryptHash))
+	assert.NoError(t, bcryptHasher.Verify(argon2idHash, "hello#world123"))
+	assert.True(t, bcryptHasher.NeedsRehash(argon2idHash))
+
+	assert.Equal(t, ErrUnknownHash, bcryptHasher.Verify("plain", "plain"))
+	assert.True(t, bcryptHasher.NeedsRehash("plain"))
+}
+
+func TestHasher_NeedsRehash(t *testing.T) {
+	bcryptHash, err := NewBcryptHasher(bcrypt.MinCost).Hash("hello#world123")
+	require.NoError(t, err)
+	assert.True(t, NewBcryptHasher(bcrypt.MinCost+1).NeedsRehash(bcryptHash))
+	// 参数比当前的强不用重新加密
+	strongerHash, err := NewBcryptHasher(bcrypt.MinCost + 1).Hash("hello#world123")
+	require.NoError(t, err)
+	assert.False(t, NewBcryptHasher(bcrypt.MinCost).NeedsRehash(strongerHash))
+
+	argon2idHash, err := NewArgon2idHasher(testArgon2idParams).Hash("hello#world123")
+	require.NoError(t, err)
+	stronger := testArgon2idParams
+	stronger.Memory = 2048
+	assert.True(t, NewArgon2idHasher(stronger).NeedsRehash(argon2idHash))
+	weaker := testArgon2idParams
+	weaker.Memory = 512
+	assert.False(t, NewArgon2idHasher(weaker).NeedsRehash(argon2idHash))
+}
@@ -0,0 +1,326 @@
+package password
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Class 字符的类型
+type Class string
+
+const (
+	ClassLower  Class = "lower"
+	ClassUpper  Class = "upper"
+	ClassDigit  Class = "digit"
+	ClassSymbol Class = "symbol"
+)
+
+// Classes 所有的字符类型，中文这类没有大小写的字符算特殊字符
+var Classes = []Class{ClassLower, ClassUpper, ClassDigit, ClassSymbol}
+
+var classNames = map[Class]string{
+	ClassLower:  "小写字母",
+	ClassUpper:  "大写字母",
+	ClassDigit:  "数字",
+	ClassSymbol: "特殊字符",
+}
+
+// classPools 每类字符有多少种，用来估算熵
+var classPools = map[Class]float64{
+	ClassLower:  26,
+	ClassUpper:  26,
+	ClassDigit:  10,
+	ClassSymbol: 33,
+}
+
+// 规则的名字，前端可以按照这个展示对应的提示
+const (
+	RuleMinLength      = "min_length"
+	RuleMaxLength      = "max_length"
+	RuleMaxBytes       = "max_bytes"
+	RuleRequirePrefix  = "require_"
+	RuleMinClasses     = "min_classes"
+	RuleBanned         = "banned"
+	RuleSimilarToEmail = "similar_to_email"
+	RuleMinEntropy     = "min_entropy"
+)
+
+// bcrypt 只用前 72 个字节，更长的密码会直接报错
+// 用 argon2id 的时候也保留这个限制，这样随时可以切回 bcrypt
+const maxBytes = 72
+
+//go:embed banned.txt
+var bannedList string
+
+// Violation 一条没有通过的规则
+type Violation struct {
+	Rule string
+	Msg  string
+}
+
+// PolicyError 密码没有通过检查，包含所有没有通过的规则，而不是只报第一个
+type PolicyError struct {
+	Violations []Violation
+	// 密码强度，0 到 4，越大越强
+	Score int
+}
+
+func (e *PolicyError) Error() string {
+	msgs := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		msgs = append(msgs, v.Msg)
+	}
+	return strings.Join(msgs, "；")
+}
+
+// Policy 密码策略，规则都可以配置，内置的常见弱密码列表总是生效
+type Policy struct {
+	minLength  int
+	maxLength  int
+	required   []Class
+	minClasses int
+	// 估算的熵至少多少 bit
+	minEntropy float64
+	banned     map[string]struct{}
+}
+
+// NewPolicy 长度按照字符算，一个中文和一个英文字母一样
+func NewPolicy(minLength, maxLength int) *Policy {
+	p := &Policy{
+		minLength: minLength,
+		maxLength: maxLength,
+		banned:    make(map[string]struct{}),
+	}
+	for _, line := range strings.Split(bannedList, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.banned[strings.ToLower(line)] = struct{}{}
+	}
+	return p
+}
+
+// DefaultPolicy 至少 8 个字符，强度至少 40 bit，不限制字符类型，长一点的短语也能用
+func DefaultPolicy() *Policy {
+	return NewPolicy(8, 64).MinEntropy(40)
+}
+
+// RequireClasses 必须包含这些类型的字符
+func (p *Policy) RequireClasses(classes ...Class) *Policy {
+	p.required = append(p.required, classes...)
+	return p
+}
+
+// MinClasses 至少包含几类字符
+func (p *Policy) MinClasses(n int) *Policy {
+	p.minClasses = n
+	return p
+}
+
+// MinEntropy 估算的熵至少多少 bit
+func (p *Policy) MinEntropy(bits float64) *Policy {
+	p.minEntropy = bits
+	return p
+}
+
+// Ban 额外禁止的密码，不区分大小写
+func (p *Policy) Ban(passwords ...string) *Policy {
+	for _, pwd := range passwords {
+		p.banned[strings.ToLower(pwd)] = struct{}{}
+	}
+	return p
+}
+
+// ReadBannedFile 读取弱密码文件，一行一个，# 开头的是注释
+func ReadBannedFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var res []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		res = append(res, line)
+	}
+	return res, scanner.Err()
+}
+
+// Check 检查密码，email 为空的时候不检查和邮箱的相似度
+// 全部通过返回 nil，否则返回 *PolicyError
+func (p *Policy) Check(password, email string) error {
+	var violations []Violation
+	add := func(rule, msg string) {
+		violations = append(violations, Violation{Rule: rule, Msg: msg})
+	}
+
+	length := utf8.RuneCountInString(password)
+	if length < p.minLength {
+		add(RuleMinLength, fmt.Sprintf("密码至少 %d 个字符", p.minLength))
+	}
+	if p.maxLength > 0 && length > p.maxLength {
+		add(RuleMaxLength, fmt.Sprintf("密码不能超过 %d 个字符", p.maxLength))
+	}
+	if len(password) > maxBytes {
+		add(RuleMaxBytes, fmt.Sprintf("密码不能超过 %d 个字节，一个中文算 3 个字节", maxBytes))
+	}
+
+	classes := classesOf(password)
+	for _, c := range p.required {
+		if !classes[c] {
+			add(RuleRequirePrefix+string(c), "密码必须包含"+classNames[c])
+		}
+	}
+	if len(classes) < p.minClasses {
+		add(RuleMinClasses, fmt.Sprintf("密码至少要包含小写字母、大写字母、数字、特殊字符中的 %d 种", p.minClasses))
+	}
+
+	if p.isBanned(password) {
+		add(RuleBanned, "密码太常见了，很容易被猜到")
+	}
+	if email != "" && similarToEmail(password, email) {
+		add(RuleSimilarToEmail, "密码不能和邮箱太像")
+	}
+	entropy, score := Strength(password)
+	if entropy < p.minEntropy {
+		add(RuleMinEntropy, "密码强度不够，可以用更长的密码，或者混合使用多种字符")
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+	return &PolicyError{
+		Violations: violations,
+		Score:      score,
+	}
+}
+
+// isBanned 不区分大小写，去掉末尾的数字和符号之后再比较一次
+func (p *Policy) isBanned(password string) bool {
+	pwd := strings.ToLower(password)
+	if _, ok := p.banned[pwd]; ok {
+		return true
+	}
+	trimmed := strings.TrimRightFunc(pwd, func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	if trimmed == pwd || utf8.RuneCountInString(trimmed) < 4 {
+		return false
+	}
+	_, ok := p.banned[trimmed]
+	return ok
+}
+
+// Strength 估算密码的熵和强度评分
+// 熵按照用到的字符类型的总数估算，重复的和连续的字符（aaa、abc、321）只算 1 bit
+// 评分 0 到 4：非常弱、弱、一般、强、非常强
+func Strength(password string) (float64, int) {
+	var pool float64
+	for c := range classesOf(password) {
+		pool += classPools[c]
+	}
+	if pool == 0 {
+		return 0, 0
+	}
+	bitsPerChar := math.Log2(pool)
+	var (
+		entropy float64
+		prev    rune
+	)
+	for i, r := range []rune(password) {
+		if i > 0 && (r == prev || r == prev+1 || r == prev-1) {
+			entropy += 1
+		} else {
+			entropy += bitsPerChar
+		}
+		prev = r
+	}
+	switch {
+	case entropy < 28:
+		return entropy, 0
+	case entropy < 36:
+		return entropy, 1
+	case entropy < 60:
+		return entropy, 2
+	case entropy < 80:
+		return entropy, 3
+	default:
+		return entropy, 4
+	}
+}
+
+func classesOf(password string) map[Class]bool {
+	classes := make(map[Class]bool, len(Classes))
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			classes[ClassLower] = true
+		case unicode.IsUpper(r):
+			classes[ClassUpper] = true
+		case r >= '0' && r <= '9':
+			classes[ClassDigit] = true
+		default:
+			classes[ClassSymbol] = true
+		}
+	}
+	return classes
+}
+
+// similarToEmail 密码里面包含邮箱的用户名，或者只改了几个字符
+func similarToEmail(password, email string) bool {
+	name, _, _ := strings.Cut(email, "@")
+	name = alnum(name)
+	pwd := alnum(password)
+	// 用户名太短的话，很多正常的密码都会包含它
+	if utf8.RuneCountInString(name) < 4 || pwd == "" {
+		return false
+	}
+	if strings.Contains(pwd, name) || strings.Contains(name, pwd) {
+		return true
+	}
+	return levenshtein(pwd, name) <= utf8.RuneCountInString(name)/3
+}
+
+// alnum 转成小写，只保留字母和数字
+func alnum(s string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// levenshtein 编辑距离，把 a 改成 b 最少要增删改几个字符
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,124 @@
+package password
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPolicy_Check(t *testing.T) {
+	testCases := []struct {
+		name     string
+		policy   *Policy
+		password string
+		email    string
+		// 没有通过的规则，按照检查的顺序
+		wantRules []string
+	}{
+		{
+			name:     "长一点的短语",
+			policy:   DefaultPolicy(),
+			password: "correct horse battery staple",
+			email:    "alice@qq.com",
+		},
+		{
+			name:     "中文密码",
+			policy:   DefaultPolicy(),
+			password: "我的密码是一首诗歌呀",
+		},
+		{
+			name:      "太短了，强度也不够",
+			policy:    DefaultPolicy(),
+			password:  "abc",
+			wantRules: []string{RuleMinLength, RuleMinEntropy},
+		},
+		{
+			name:      "常见的弱密码，末尾加了数字和符号",
+			policy:    DefaultPolicy(),
+			password:  "Password123!",
+			wantRules: []string{RuleBanned},
+		},
+		{
+			name:      "额外禁止的密码",
+			policy:    DefaultPolicy().Ban("Webook2024!!"),
+			password:  "webook2024!!",
+			wantRules: []string{RuleBanned},
+		},
+		{
+			name:      "包含邮箱的用户名",
+			policy:    DefaultPolicy(),
+			password:  "zhangsan#2024",
+			email:     "ZhangSan@qq.com",
+			wantRules: []string{RuleSimilarToEmail},
+		},
+		{
+			name:      "和邮箱的用户名只差一点",
+			policy:    DefaultPolicy(),
+			password:  "zhangshan",
+			email:     "zhangsan@qq.com",
+			wantRules: []string{RuleSimilarToEmail},
+		},
+		{
+			name:     "邮箱的用户名太短，不检查",
+			policy:   DefaultPolicy(),
+			password: "hello#world123",
+			email:    "123@qq.com",
+		},
+		{
+			name:      "重复和连续的字符强度很低",
+			policy:    DefaultPolicy(),
+			password:  "aaaaaaaaabcdefg",
+			wantRules: []string{RuleMinEntropy},
+		},
+		{
+			name:      "所有的问题一次性报出来",
+			policy:    NewPolicy(10, 64).RequireClasses(ClassUpper, ClassDigit).MinClasses(3).MinEntropy(60),
+			password:  "letmein",
+			wantRules: []string{RuleMinLength, "require_upper", "require_digit", RuleMinClasses, RuleBanned, RuleMinEntropy},
+		},
+		{
+			name:      "太长了",
+			policy:    NewPolicy(8, 16),
+			password:  "一二三四五六七八九十一二三四五六七八九十一二三四五",
+			wantRules: []string{RuleMaxLength, RuleMaxBytes},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.policy.Check(tc.password, tc.email)
+			if len(tc.wantRules) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			require.IsType(t, &PolicyError{}, err)
+			var rules []string
+			for _, v := range err.(*PolicyError).Violations {
+				rules = append(rules, v.Rule)
+				assert.NotEmpty(t, v.Msg)
+			}
+			assert.Equal(t, tc.wantRules, rules)
+		})
+	}
+}
+
+func TestStrength(t *testing.T) {
+	_, score := Strength("")
+	assert.Equal(t, 0, score)
+	_, score = Strength("12345678")
+	assert.Equal(t, 0, score)
+	_, weak := Strength("hello#world123")
+	_, strong := Strength("correct horse battery staple")
+	assert.Less(t, weak, strong)
+	assert.Equal(t, 4, strong)
+}
+
+func TestReadBannedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "banned.txt")
+	require.NoError(t, os.WriteFile(path, []byte("# 公司名\nwebook\n\n  acme  \n"), 0644))
+	banned, err := ReadBannedFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"webook", "acme"}, banned)
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"awesomeProject/webook/internal/domain"
+	"awesomeProject/webook/internal/repository"
+	"awesomeProject/webook/internal/service/email"
+	"awesomeProject/webook/internal/service/sms"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// 找回密码的验证码和登录的验证码分开存，互不影响
+const resetPasswordBiz = "reset_password"
+
+// revokeRetries 重置密码之后撤销 session 最多试几次
+const revokeRetries = 3
+
+// ErrInvalidResetCode 验证码不对，或者已经过期了
+var ErrInvalidResetCode = errors.New("验证码有误")
+
+//go:generate mockgen -source=./password_reset.go -package=svcmocks -destination=./mocks/password_reset.mock.go
+type PasswordResetService interface {
+	// SendCode 给账号发送找回密码的验证码，account 是邮箱或者手机号
+	// 账号不存在也返回 nil，发送频率限制也一样，不让别人通过这个接口试探哪些账号注册过
+	SendCode(ctx context.Context, account string) error
+	// Reset 验证码通过之后设置新密码，并且让这个用户所有的 session 失效
+	Reset(ctx context.Context, account, code, password string) error
+}
+
+type passwordResetService struct {
+	repo       repository.UserRepository
+	codeRepo   repository.CodeRepository
+	userSvc    UserService
+	sessionSvc SessionService
+	smsSvc     sms.Service
+	emailSvc   email.Service
+}
+
+func NewPasswordResetService(repo repository.UserRepository, codeRepo repository.CodeRepository,
+	userSvc UserService, sessionSvc SessionService,
+	smsSvc sms.Service, emailSvc email.Service) PasswordResetService {
+	return &passwordResetService{
+		repo:       repo,
+		codeRepo:   codeRepo,
+		userSvc:    userSvc,
+		sessionSvc: sessionSvc,
+		smsSvc:     smsSvc,
+		emailSvc:   emailSvc,
+	}
+}
+
+func (svc *passwordResetService) SendCode(ctx context.Context, account string) error {
+	account = strings.TrimSpace(account)
+	_, err := svc.findByAccount(ctx, account)
+	exist := err == nil
+	if err != nil && err != repository.ErrUserNotFound {
+		return err
+	}
+	// 账号不存在也照样存验证码，走一样的发送频率限制，
+	// 不然只有注册过的账号才会返回发送太频繁，还是能试探出来
+	code, err := generateCode()
+	if err != nil {
+		return err
+	}
+	err = svc.codeRepo.Store(ctx, resetPasswordBiz, resetCodeKey(account), code)
+	if err != nil {
+		return err
+	}
+	if !exist {
+		return nil
+	}
+	if isEmail(account) {
+		body := fmt.Sprintf("你正在找回 webook 的密码，验证码是 %s，10 分钟之内有效。\n\n如果不是你本人操作，请忽略这封邮件。", code)
+		return svc.emailSvc.Send(ctx, account, "webook 找回密码验证码", body)
+	}
+	// 和登录用同一个验证码短信模板
+	return svc.smsSvc.Send(ctx, codeTplId, []string{code}, account)
+}
+
+func (svc *passwordResetService) Reset(ctx context.Context, account, code, password string) error {
+	account = strings.TrimSpace(account)
+	// 验证码只能用一次，先检查密码策略，免得密码不合格还把验证码用掉了
+	var email string
+	if isEmail(account) {
+		email = account
+	}
+	err := svc.userSvc.ValidatePassword(email, password)
+	if err != nil {
+		return err
+	}
+	ok, err := svc.codeRepo.Verify(ctx, resetPasswordBiz, resetCodeKey(account), code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidResetCode
+	}
+	u, err := svc.findByAccount(ctx, account)
+	if err == repository.ErrUserNotFound {
+		return ErrInvalidUserNotFund
+	}
+	if err != nil {
+		return err
+	}
+	err = svc.userSvc.ResetPassword(ctx, u.Id, password)
+	if err != nil {
+		return err
+	}
+	// 密码可能是泄露了才来找回的，之前登录的设备都要重新登录
+	// 密码已经改好了，验证码也用掉了，撤销失败不能再告诉用户重置失败，重试几次还不行就记下来
+	for i := 0; i < revokeRetries; i++ {
+		err = svc.sessionSvc.Revoke(ctx, u.Id)
+		if err == nil {
+			return nil
+		}
+	}
+	log.Printf("重置密码之后撤销 session 失败 uid: %d, err: %v", u.Id, err)
+	return nil
+}
+
+// findByAccount 带 @ 的按照邮箱查，否则按照手机号查
+func (svc *passwordResetService) findByAccount(ctx context.Context, account string) (domain.User, error) {
+	if isEmail(account) {
+		return svc.repo.FindByEmail(ctx, account)
+	}
+	return svc.repo.FindByPhone(ctx, account)
+}
+
+// resetCodeKey 验证码按照归一化之后的账号存，邮箱不区分大小写，
+// 免得换个大小写就能绕过发送频率限制
+func resetCodeKey(account string) string {
+	if isEmail(account) {
+		return strings.ToLower(account)
+	}
+	return account
+}
+
+func isEmail(account string) bool {
+	return strings.Contains(account, "@")
+}
@@ -0,0 +1,237 @@
+package service
+
+import (
+	"awesomeProject/webook/internal/domain"
+	"awesomeProject/webook/internal/repository"
+	repomocks "awesomeProject/webook/internal/repository/mocks"
+	emailmocks "awesomeProject/webook/internal/service/email/mocks"
+	svcmocks "awesomeProject/webook/internal/service/mocks"
+	"awesomeProject/webook/internal/service/password"
+	smsmocks "awesomeProject/webook/internal/service/sms/mocks"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+	"testing"
+)
+
+// passwordResetMocks 找回密码依赖的所有 mock
+type passwordResetMocks struct {
+	repo       *repomocks.MockUserRepository
+	codeRepo   *repomocks.MockCodeRepository
+	userSvc    *svcmocks.MockUserService
+	sessionSvc *svcmocks.MockSessionService
+	smsSvc     *smsmocks.MockService
+	emailSvc   *emailmocks.MockService
+}
+
+func newPasswordResetMocks(ctrl *gomock.Controller) passwordResetMocks {
+	return passwordResetMocks{
+		repo:       repomocks.NewMockUserRepository(ctrl),
+		codeRepo:   repomocks.NewMockCodeRepository(ctrl),
+		userSvc:    svcmocks.NewMockUserService(ctrl),
+		sessionSvc: svcmocks.NewMockSessionService(ctrl),
+		smsSvc:     smsmocks.NewMockService(ctrl),
+		emailSvc:   emailmocks.NewMockService(ctrl),
+	}
+}
+
+func (m passwordResetMocks) service() PasswordResetService {
+	return NewPasswordResetService(m.repo, m.codeRepo, m.userSvc, m.sessionSvc, m.smsSvc, m.emailSvc)
+}
+
+func TestPasswordResetService_SendCode(t *testing.T) {
+	testCases := []struct {
+		name    string
+		mock    func(m passwordResetMocks)
+		account string
+		wantErr error
+	}{
+		{
+			name: "邮箱发送成功",
+			mock: func(m passwordResetMocks) {
+				m.repo.EXPECT().FindByEmail(gomock.Any(), "123@qq.com").
+					Return(domain.User{Id: 123, Email: "123@qq.com"}, nil)
+				var code string
+				m.codeRepo.EXPECT().Store(gomock.Any(), "reset_password", "123@qq.com", gomock.Any()).
+					DoAndReturn(func(ctx context.Context, biz, account, c string) error {
+						code = c
+						return nil
+					})
+				m.emailSvc.EXPECT().Send(gomock.Any(), "123@qq.com", gomock.Any(), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, to, subject, body string) error {
+						// 邮件里面的验证码就是存起来的那个
+						assert.Contains(t, body, code)
+						return nil
+					})
+			},
+			account: "123@qq.com",
+		},
+		{
+			name: "手机号发送成功",
+			mock: func(m passwordResetMocks) {
+				m.repo.EXPECT().FindByPhone(gomock.Any(), "15212345678").
+					Return(domain.User{Id: 123, Phone: "15212345678"}, nil)
+				m.codeRepo.EXPECT().Store(gomock.Any(), "reset_password", "15212345678", gomock.Any()).Return(nil)
+				m.smsSvc.EXPECT().Send(gomock.Any(), codeTplId, gomock.Any(), "15212345678").Return(nil)
+			},
+			account: "15212345678",
+		},
+		{
+			name: "邮箱大小写不同，验证码存在同一个 key 下面",
+			mock: func(m passwordResetMocks) {
+				m.repo.EXPECT().FindByEmail(gomock.Any(), "Abc@QQ.com").
+					Return(domain.User{Id: 123, Email: "Abc@QQ.com"}, nil)
+				m.codeRepo.EXPECT().Store(gomock.Any(), "reset_password", "abc@qq.com", gomock.Any()).Return(nil)
+				m.emailSvc.EXPECT().Send(gomock.Any(), "Abc@QQ.com", gomock.Any(), gomock.Any()).Return(nil)
+			},
+			account: " Abc@QQ.com ",
+		},
+		{
+			name: "账号不存在，照样存验证码但是不发送",
+			mock: func(m passwordResetMocks) {
+				m.repo.EXPECT().FindByEmail(gomock.Any(), "123@qq.com").
+					Return(domain.User{}, repository.ErrUserNotFound)
+				m.codeRepo.EXPECT().Store(gomock.Any(), "reset_password", "123@qq.com", gomock.Any()).Return(nil)
+			},
+			account: "123@qq.com",
+		},
+		{
+			name: "账号不存在，发送太频繁",
+			mock: func(m passwordResetMocks) {
+				m.repo.EXPECT().FindByPhone(gomock.Any(), "15212345678").
+					Return(domain.User{}, repository.ErrUserNotFound)
+				m.codeRepo.EXPECT().Store(gomock.Any(), "reset_password", "15212345678", gomock.Any()).
+					Return(ErrCodeSendTooMany)
+			},
+			account: "15212345678",
+			wantErr: ErrCodeSendTooMany,
+		},
+		{
+			name: "查询账号出错",
+			mock: func(m passwordResetMocks) {
+				m.repo.EXPECT().FindByEmail(gomock.Any(), "123@qq.com").
+					Return(domain.User{}, errors.New("db 错误"))
+			},
+			account: "123@qq.com",
+			wantErr: errors.New("db 错误"),
+		},
+		{
+			name: "发送太频繁",
+			mock: func(m passwordResetMocks) {
+				m.repo.EXPECT().FindByEmail(gomock.Any(), "123@qq.com").
+					Return(domain.User{Id: 123, Email: "123@qq.com"}, nil)
+				m.codeRepo.EXPECT().Store(gomock.Any(), "reset_password", "123@qq.com", gomock.Any()).
+					Return(ErrCodeSendTooMany)
+			},
+			account: "123@qq.com",
+			wantErr: ErrCodeSendTooMany,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			m := newPasswordResetMocks(ctrl)
+			tc.mock(m)
+			err := m.service().SendCode(context.Background(), tc.account)
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
+
+func TestPasswordResetService_Reset(t *testing.T) {
+	policyErr := &password.PolicyError{
+		Violations: []password.Violation{{Rule: password.RuleBanned, Msg: "密码太常见了"}},
+	}
+	testCases := []struct {
+		name    string
+		mock    func(m passwordResetMocks)
+		wantErr error
+	}{
+		{
+			name: "重置成功，之前的 session 全部失效",
+			mock: func(m passwordResetMocks) {
+				m.userSvc.EXPECT().ValidatePassword("123@qq.com", "Hello#world123").Return(nil)
+				m.codeRepo.EXPECT().Verify(gomock.Any(), "reset_password", "123@qq.com", "123456").Return(true, nil)
+				m.repo.EXPECT().FindByEmail(gomock.Any(), "123@qq.com").
+					Return(domain.User{Id: 123, Email: "123@qq.com"}, nil)
+				m.userSvc.EXPECT().ResetPassword(gomock.Any(), int64(123), "Hello#world123").Return(nil)
+				m.sessionSvc.EXPECT().Revoke(gomock.Any(), int64(123)).Return(nil)
+			},
+		},
+		{
+			name: "撤销 session 重试之后成功",
+			mock: func(m passwordResetMocks) {
+				m.userSvc.EXPECT().ValidatePassword("123@qq.com", "Hello#world123").Return(nil)
+				m.codeRepo.EXPECT().Verify(gomock.Any(), "reset_password", "123@qq.com", "123456").Return(true, nil)
+				m.repo.EXPECT().FindByEmail(gomock.Any(), "123@qq.com").
+					Return(domain.User{Id: 123, Email: "123@qq.com"}, nil)
+				m.userSvc.EXPECT().ResetPassword(gomock.Any(), int64(123), "Hello#world123").Return(nil)
+				gomock.InOrder(
+					m.sessionSvc.EXPECT().Revoke(gomock.Any(), int64(123)).Return(errors.New("redis 错误")),
+					m.sessionSvc.EXPECT().Revoke(gomock.Any(), int64(123)).Return(nil),
+				)
+			},
+		},
+		{
+			name: "撤销 session 一直失败，密码已经改了，照样成功",
+			mock: func(m passwordResetMocks) {
+				m.userSvc.EXPECT().ValidatePassword("123@qq.com", "Hello#world123").Return(nil)
+				m.codeRepo.EXPECT().Verify(gomock.Any(), "reset_password", "123@qq.com", "123456").Return(true, nil)
+				m.repo.EXPECT().FindByEmail(gomock.Any(), "123@qq.com").
+					Return(domain.User{Id: 123, Email: "123@qq.com"}, nil)
+				m.userSvc.EXPECT().ResetPassword(gomock.Any(), int64(123), "Hello#world123").Return(nil)
+				m.sessionSvc.EXPECT().Revoke(gomock.Any(), int64(123)).Return(errors.New("redis 错误")).Times(3)
+			},
+		},
+		{
+			name: "验证码不对",
+			mock: func(m passwordResetMocks) {
+				m.userSvc.EXPECT().ValidatePassword("123@qq.com", "Hello#world123").Return(nil)
+				m.codeRepo.EXPECT().Verify(gomock.Any(), "reset_password", "123@qq.com", "123456").Return(false, nil)
+			},
+			wantErr: ErrInvalidResetCode,
+		},
+		{
+			name: "验证次数太多",
+			mock: func(m passwordResetMocks) {
+				m.userSvc.EXPECT().ValidatePassword("123@qq.com", "Hello#world123").Return(nil)
+				m.codeRepo.EXPECT().Verify(gomock.Any(), "reset_password", "123@qq.com", "123456").
+					Return(false, ErrCodeVerifyTooManyTimes)
+			},
+			wantErr: ErrCodeVerifyTooManyTimes,
+		},
+		{
+			name: "密码不合格，不用掉验证码",
+			mock: func(m passwordResetMocks) {
+				m.userSvc.EXPECT().ValidatePassword("123@qq.com", "Hello#world123").Return(policyErr)
+			},
+			wantErr: policyErr,
+		},
+		{
+			name: "修改密码失败，session 不动",
+			mock: func(m passwordResetMocks) {
+				m.userSvc.EXPECT().ValidatePassword("123@qq.com", "Hello#world123").Return(nil)
+				m.codeRepo.EXPECT().Verify(gomock.Any(), "reset_password", "123@qq.com", "123456").Return(true, nil)
+				m.repo.EXPECT().FindByEmail(gomock.Any(), "123@qq.com").
+					Return(domain.User{Id: 123, Email: "123@qq.com"}, nil)
+				m.userSvc.EXPECT().ResetPassword(gomock.Any(), int64(123), "Hello#world123").
+					Return(errors.New("db 错误"))
+			},
+			wantErr: errors.New("db 错误"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			m := newPasswordResetMocks(ctrl)
+			tc.mock(m)
+			err := m.service().Reset(context.Background(), "123@qq.com", "123456", "Hello#world123")
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"awesomeProject/webook/internal/repository"
+	"context"
+	"time"
+)
+
+//go:generate mockgen -source=./session.go -package=svcmocks -destination=./mocks/session.mock.go
+type SessionService interface {
+	// Revoke 让这个用户现在之前登录的 session 全部失效
+	Revoke(ctx context.Context, uid int64) error
+	// Valid 在 loginTime 登录的 session 还能不能用
+	Valid(ctx context.Context, uid int64, loginTime time.Time) (bool, error)
+}
+
+type sessionService struct {
+	repo repository.SessionRepository
+	now  func() time.Time
+}
+
+func NewSessionService(repo repository.SessionRepository) SessionService {
+	return &sessionService{
+		repo: repo,
+		now:  time.Now,
+	}
+}
+
+func (svc *sessionService) Revoke(ctx context.Context, uid int64) error {
+	return svc.repo.Revoke(ctx, uid, svc.now())
+}
+
+// Valid 撤销之前登录的 session 都不能用了，没有记录登录时间的老 session 当成很早之前登录的
+func (svc *sessionService) Valid(ctx context.Context, uid int64, loginTime time.Time) (bool, error) {
+	revokedAt, err := svc.repo.RevokedAt(ctx, uid)
+	if err != nil {
+		return false, err
+	}
+	return !loginTime.Before(revokedAt), nil
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	repomocks "awesomeProject/webook/internal/repository/mocks"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+	"testing"
+	"time"
+)
+
+func TestSessionService_Valid(t *testing.T) {
+	revokedAt := time.Now()
+
+	testCases := []struct {
+		name      string
+		revokedAt time.Time
+		loginTime time.Time
+		want      bool
+	}{
+		{
+			name:      "没有撤销过",
+			loginTime: revokedAt.Add(-time.Hour),
+			want:      true,
+		},
+		{
+			name:      "撤销之前登录的",
+			revokedAt: revokedAt,
+			loginTime: revokedAt.Add(-time.Second),
+		},
+		{
+			name:      "撤销之后重新登录的",
+			revokedAt: revokedAt,
+			loginTime: revokedAt.Add(time.Second),
+			want:      true,
+		},
+		{
+			name:      "没有记录登录时间的老 session",
+			revokedAt: revokedAt,
+			loginTime: time.Unix(0, 0),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			repo := repomocks.NewMockSessionRepository(ctrl)
+			repo.EXPECT().RevokedAt(gomock.Any(), int64(123)).Return(tc.revokedAt, nil)
+			ok, err := NewSessionService(repo).Valid(context.Background(), 123, tc.loginTime)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, ok)
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Service 通过 HTTP 调用短信网关发短信，具体是哪个短信供应商由网关决定
+// 请求体是 JSON：{"tpl": "...", "args": [...], "numbers": [...]}，网关返回 2xx 表示发送成功
+type Service struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// NewService token 放在 Authorization 请求头里面，为空就不带
+func NewService(url, token string, client *http.Client) *Service {
+	return &Service{
+		url:    url,
+		token:  token,
+		client: client,
+	}
+}
+
+type sendReq struct {
+	Tpl     string   `json:"tpl"`
+	Args    []string `json:"args"`
+	Numbers []string `json:"numbers"`
+}
+
+func (s *Service) Send(ctx context.Context, tpl string, args []string, numbers ...string) error {
+	body, err := json.Marshal(sendReq{
+		Tpl:     tpl,
+		Args:    args,
+		Numbers: numbers,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		// 只取一小段，网关的错误信息不会太长
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("短信网关返回 %d: %s", resp.StatusCode, msg)
+	}
+	return nil
+}
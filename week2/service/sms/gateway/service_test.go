@@ -0,0 +1,55 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestService_Send(t *testing.T) {
+	testCases := []struct {
+		name    string
+		status  int
+		wantErr string
+	}{
+		{
+			name:   "发送成功",
+			status: http.StatusOK,
+		},
+		{
+			name:    "网关出错",
+			status:  http.StatusBadGateway,
+			wantErr: "短信网关返回 502: 供应商不可用",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got sendReq
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, http.MethodPost, r.Method)
+				assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+				assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+				w.WriteHeader(tc.status)
+				if tc.status != http.StatusOK {
+					_, _ = w.Write([]byte("供应商不可用"))
+				}
+			}))
+			defer server.Close()
+
+			s := NewService(server.URL, "secret", server.Client())
+			err := s.Send(context.Background(), "1877556", []string{"123456"}, "15212345678")
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, sendReq{Tpl: "1877556", Args: []string{"123456"}, Numbers: []string{"15212345678"}}, got)
+		})
+	}
+}
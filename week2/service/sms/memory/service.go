@@ -0,0 +1,73 @@
+package memory
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// Message 一条发出去的短信
+type Message struct {
+	Tpl     string
+	Args    []string
+	Numbers []string
+}
+
+// Service 本地的短信实现，不真的发短信，只打日志并且记下最近的几条
+// 开发环境和测试用，整个验证码登录流程可以离线跑通
+type Service struct {
+	// logArgs 日志里面带不带模板参数，参数里面就是验证码，只有开发环境才能打开
+	logArgs bool
+
+	mu sync.Mutex
+	// msgs 是一个环形缓冲区，满了之后覆盖最早的短信，长时间运行也不会越来越大
+	msgs []Message
+	// next 下一条短信放在哪里
+	next int
+	full bool
+}
+
+// NewService capacity 是最多记住多少条短信
+func NewService(capacity int, logArgs bool) *Service {
+	return &Service{
+		logArgs: logArgs,
+		msgs:    make([]Message, capacity),
+	}
+}
+
+func (s *Service) Send(ctx context.Context, tpl string, args []string, numbers ...string) error {
+	if s.logArgs {
+		log.Printf("模拟发送短信 tpl: %s, args: %v, numbers: %v", tpl, args, numbers)
+	} else {
+		log.Printf("模拟发送短信 tpl: %s, numbers: %v", tpl, numbers)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.msgs) == 0 {
+		return nil
+	}
+	s.msgs[s.next] = Message{
+		Tpl:     tpl,
+		Args:    args,
+		Numbers: numbers,
+	}
+	s.next = (s.next + 1) % len(s.msgs)
+	if s.next == 0 {
+		s.full = true
+	}
+	return nil
+}
+
+// Messages 返回最近发送的短信，从早到晚，测试里面可以拿到验证码
+func (s *Service) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.full {
+		res := make([]Message, s.next)
+		copy(res, s.msgs[:s.next])
+		return res
+	}
+	res := make([]Message, 0, len(s.msgs))
+	res = append(res, s.msgs[s.next:]...)
+	return append(res, s.msgs[:s.next]...)
+}
@@ -0,0 +1,31 @@
+package memory
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"testing"
+)
+
+func TestService_Send(t *testing.T) {
+	s := NewService(3, false)
+	assert.Empty(t, s.Messages())
+	for i := 1; i <= 2; i++ {
+		require.NoError(t, s.Send(context.Background(), "1877556", []string{strconv.Itoa(i)}, "15212345678"))
+	}
+	assert.Equal(t, []Message{
+		{Tpl: "1877556", Args: []string{"1"}, Numbers: []string{"15212345678"}},
+		{Tpl: "1877556", Args: []string{"2"}, Numbers: []string{"15212345678"}},
+	}, s.Messages())
+
+	// 满了之后覆盖最早的，只留最近的 3 条
+	for i := 3; i <= 7; i++ {
+		require.NoError(t, s.Send(context.Background(), "1877556", []string{strconv.Itoa(i)}, "15212345678"))
+	}
+	msgs := s.Messages()
+	require.Len(t, msgs, 3)
+	for i, msg := range msgs {
+		assert.Equal(t, []string{strconv.Itoa(i + 5)}, msg.Args)
+	}
+}
@@ -0,0 +1,11 @@
+package sms
+
+import "context"
+
+// Service 发送短信的抽象，具体是哪个短信供应商由实现决定
+// tpl 是模板 id，args 是模板参数，numbers 是接收的手机号
+//
+//go:generate mockgen -source=./types.go -package=smsmocks -destination=./mocks/sms.mock.go
+type Service interface {
+	Send(ctx context.Context, tpl string, args []string, numbers ...string) error
+}
@@ -3,26 +3,56 @@ package service
 import (
 	"awesomeProject/webook/internal/domain"
 	"awesomeProject/webook/internal/repository"
+	"awesomeProject/webook/internal/service/password"
 	"context"
 	"errors"
-	"golang.org/x/crypto/bcrypt"
+	"log"
 )
 
 var ErrUserDuplicateEmail = repository.ErrUserDuplicateEmail
 var ErrInvalidUserOrPassword = errors.New("账号/邮箱或密码不对")
 var ErrInvalidUserNotFund = errors.New("不存在该用户")
 
-type UserService struct {
-	repo *repository.UserRepository
+// ErrProfileConflict 修改个人信息的时候版本号对不上，别的地方已经改过了
+var ErrProfileConflict = errors.New("个人信息已经被修改过了")
+
+//go:generate mockgen -source=./user.go -package=svcmocks -destination=./mocks/user.mock.go
+type UserService interface {
+	Login(ctx context.Context, email, password string) (domain.User, error)
+	SignUp(ctx context.Context, u domain.User) error
+	FindOrCreate(ctx context.Context, phone string) (domain.User, error)
+	EditUserProfile(ctx context.Context, id int64, u domain.User) error
+	Profile(ctx context.Context, id int64) (domain.User, error)
+	// UpdateProfile 部分修改个人信息，返回修改之后的个人信息
+	UpdateProfile(ctx context.Context, id int64, patch domain.UserProfilePatch) (domain.User, error)
+	// ProfileHistory 分页查询个人信息的修改记录，最新的在前面，同时返回总数
+	ProfileHistory(ctx context.Context, id int64, offset, limit int) ([]domain.ProfileChange, int64, error)
+	// ResetPassword 直接设置新密码，调用方负责确认是用户本人
+	ResetPassword(ctx context.Context, id int64, password string) error
+	// ChangePassword 原密码对了才修改，原密码不对返回 ErrInvalidUserOrPassword
+	ChangePassword(ctx context.Context, id int64, oldPassword, newPassword string) error
+	// ValidatePassword 按照密码策略检查密码，没有通过返回 *password.PolicyError
+	// 注册、修改密码、找回密码都会检查，这里单独提供出来给需要提前检查的地方用
+	ValidatePassword(email, pwd string) error
+}
+
+type userService struct {
+	repo   repository.UserRepository
+	policy *password.Policy
+	hasher password.Hasher
 }
 
-func NewUserService(repo *repository.UserRepository) *UserService {
-	return &UserService{
-		repo: repo,
+// NewUserService hasher 决定新密码用什么算法加密，老的哈希不管是什么算法都能验证
+func NewUserService(repo repository.UserRepository, policy *password.Policy,
+	hasher password.Hasher) UserService {
+	return &userService{
+		repo:   repo,
+		policy: policy,
+		hasher: hasher,
 	}
 }
 
-func (svc *UserService) Login(ctx context.Context, email, password string) (domain.User, error) {
+func (svc *userService) Login(ctx context.Context, email, password string) (domain.User, error) {
 	//先找用户
 	u, err := svc.repo.FindByEmail(ctx, email)
 	if err == repository.ErrUserNotFound {
@@ -32,20 +62,28 @@ func (svc *UserService) Login(ctx context.Context, email, password string) (doma
 		return domain.User{}, err
 	}
 	//比较密码
-	err = bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password))
+	err = svc.checkPassword(u, password)
 	if err != nil {
-		return domain.User{}, ErrInvalidUserOrPassword
+		return domain.User{}, err
+	}
+	// 只有登录的时候才拿得到明文密码，趁这个机会把弱的哈希换掉
+	if svc.hasher.NeedsRehash(u.Password) {
+		svc.rehash(ctx, u, password)
 	}
 	return u, nil
 }
 
-func (svc *UserService) SignUp(ctx context.Context, u domain.User) error {
+func (svc *userService) SignUp(ctx context.Context, u domain.User) error {
+	err := svc.ValidatePassword(u.Email, u.Password)
+	if err != nil {
+		return err
+	}
 	//你要考虑加密放在哪些的问题
-	hash, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+	hash, err := svc.hasher.Hash(u.Password)
 	if err != nil {
 		return err
 	}
-	u.Password = string(hash)
+	u.Password = hash
 	return svc.repo.Create(ctx, u)
 	//println(1111)
 	//svc.repo.Create(ctx, u)
@@ -53,11 +91,17 @@ func (svc *UserService) SignUp(ctx context.Context, u domain.User) error {
 	//return svc.repo.Create(ctx, u)
 }
 
-func (svg *UserService) EditUserProfile(ctx context.Context, id int64, u domain.User) error {
-	return svg.repo.Edit(ctx, id, u)
+// FindOrCreate 手机号登录用，用户不存在就直接注册一个
+func (svc *userService) FindOrCreate(ctx context.Context, phone string) (domain.User, error) {
+	return svc.repo.FindOrCreate(ctx, phone)
+}
+
+// EditUserProfile u.Version 大于 0 的时候要求和当前的版本号一致
+func (svg *userService) EditUserProfile(ctx context.Context, id int64, u domain.User) error {
+	return svg.profileErr(svg.repo.Edit(ctx, id, u))
 }
 
-func (svc *UserService) Profile(ctx context.Context, id int64) (domain.User, error) {
+func (svc *userService) Profile(ctx context.Context, id int64) (domain.User, error) {
 	//先找用户
 	u, err := svc.repo.FindById(ctx, id)
 	if err == repository.ErrUserNotFound {
@@ -68,3 +112,97 @@ func (svc *UserService) Profile(ctx context.Context, id int64) (domain.User, err
 	}
 	return u, nil
 }
+
+func (svc *userService) UpdateProfile(ctx context.Context, id int64, patch domain.UserProfilePatch) (domain.User, error) {
+	// 什么都没改就不用碰数据库了
+	if !patch.IsEmpty() {
+		err := svc.repo.UpdateProfile(ctx, id, patch)
+		if err != nil {
+			return domain.User{}, svc.profileErr(err)
+		}
+	}
+	return svc.Profile(ctx, id)
+}
+
+// profileErr 把修改个人信息时仓储层的错误转换成业务错误
+func (svc *userService) profileErr(err error) error {
+	switch err {
+	case repository.ErrUserNotFound:
+		return ErrInvalidUserNotFund
+	case repository.ErrVersionConflict:
+		return ErrProfileConflict
+	default:
+		return err
+	}
+}
+
+func (svc *userService) ProfileHistory(ctx context.Context, id int64,
+	offset, limit int) ([]domain.ProfileChange, int64, error) {
+	return svc.repo.ProfileHistory(ctx, id, offset, limit)
+}
+
+func (svc *userService) ResetPassword(ctx context.Context, id int64, pwd string) error {
+	// 检查和邮箱像不像要用到邮箱
+	u, err := svc.Profile(ctx, id)
+	if err != nil {
+		return err
+	}
+	return svc.setPassword(ctx, u, pwd)
+}
+
+func (svc *userService) ChangePassword(ctx context.Context, id int64, oldPassword, newPassword string) error {
+	// Profile 拿到的用户没有密码，要单独查一次
+	u, err := svc.repo.FindByIdWithPassword(ctx, id)
+	if err == repository.ErrUserNotFound {
+		return ErrInvalidUserNotFund
+	}
+	if err != nil {
+		return err
+	}
+	err = svc.checkPassword(u, oldPassword)
+	if err != nil {
+		return err
+	}
+	return svc.setPassword(ctx, u, newPassword)
+}
+
+func (svc *userService) ValidatePassword(email, pwd string) error {
+	return svc.policy.Check(pwd, email)
+}
+
+// setPassword 检查密码策略，通过之后加密保存
+func (svc *userService) setPassword(ctx context.Context, u domain.User, pwd string) error {
+	err := svc.ValidatePassword(u.Email, pwd)
+	if err != nil {
+		return err
+	}
+	hash, err := svc.hasher.Hash(pwd)
+	if err != nil {
+		return err
+	}
+	err = svc.repo.UpdatePassword(ctx, u.Id, hash)
+	if err == repository.ErrUserNotFound {
+		return ErrInvalidUserNotFund
+	}
+	return err
+}
+
+// checkPassword 登录和修改密码用同样的方式比较密码
+func (svc *userService) checkPassword(u domain.User, pwd string) error {
+	err := svc.hasher.Verify(u.Password, pwd)
+	if err != nil {
+		return ErrInvalidUserOrPassword
+	}
+	return nil
+}
+
+// rehash 用当前的算法和参数重新加密，失败了也不影响这次登录，下次登录再试
+func (svc *userService) rehash(ctx context.Context, u domain.User, pwd string) {
+	hash, err := svc.hasher.Hash(pwd)
+	if err == nil {
+		err = svc.repo.UpdatePassword(ctx, u.Id, hash)
+	}
+	if err != nil {
+		log.Printf("重新加密密码失败 uid: %d, err: %v", u.Id, err)
+	}
+}
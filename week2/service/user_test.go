@@ -0,0 +1,302 @@
+package service
+
+import (
+	"awesomeProject/webook/internal/domain"
+	"awesomeProject/webook/internal/repository"
+	"awesomeProject/webook/internal/repository/cache"
+	"awesomeProject/webook/internal/repository/dao"
+	repomocks "awesomeProject/webook/internal/repository/mocks"
+	"awesomeProject/webook/internal/service/password"
+	"context"
+	"errors"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"path/filepath"
+	"testing"
+)
+
+func TestUserService_Login(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("Huawei@123"), bcrypt.DefaultCost)
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		name     string
+		mock     func(ctrl *gomock.Controller) repository.UserRepository
+		password string
+		wantUser domain.User
+		wantErr  error
+	}{
+		{
+			name: "登录成功",
+			mock: func(ctrl *gomock.Controller) repository.UserRepository {
+				repo := repomocks.NewMockUserRepository(ctrl)
+				repo.EXPECT().FindByEmail(gomock.Any(), "123@qq.com").Return(domain.User{
+					Email:    "123@qq.com",
+					Password: string(hash),
+				}, nil)
+				return repo
+			},
+			password: "Huawei@123",
+			wantUser: domain.User{
+				Email:    "123@qq.com",
+				Password: string(hash),
+			},
+		},
+		{
+			name: "用户不存在",
+			mock: func(ctrl *gomock.Controller) repository.UserRepository {
+				repo := repomocks.NewMockUserRepository(ctrl)
+				repo.EXPECT().FindByEmail(gomock.Any(), "123@qq.com").Return(domain.User{}, repository.ErrUserNotFound)
+				return repo
+			},
+			password: "Huawei@123",
+			wantErr:  ErrInvalidUserOrPassword,
+		},
+		{
+			name: "系统错误",
+			mock: func(ctrl *gomock.Controller) repository.UserRepository {
+				repo := repomocks.NewMockUserRepository(ctrl)
+				repo.EXPECT().FindByEmail(gomock.Any(), "123@qq.com").Return(domain.User{}, errors.New("db 错误"))
+				return repo
+			},
+			password: "Huawei@123",
+			wantErr:  errors.New("db 错误"),
+		},
+		{
+			name: "密码不对",
+			mock: func(ctrl *gomock.Controller) repository.UserRepository {
+				repo := repomocks.NewMockUserRepository(ctrl)
+				repo.EXPECT().FindByEmail(gomock.Any(), "123@qq.com").Return(domain.User{
+					Email:    "123@qq.com",
+					Password: string(hash),
+				}, nil)
+				return repo
+			},
+			password: "Huawei@1234",
+			wantErr:  ErrInvalidUserOrPassword,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			svc := NewUserService(tc.mock(ctrl), password.DefaultPolicy(), password.NewBcryptHasher(bcrypt.DefaultCost))
+			u, err := svc.Login(context.Background(), "123@qq.com", tc.password)
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantUser, u)
+		})
+	}
+}
+
+func TestUserService_LoginRehash(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("Huawei@123"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name   string
+		mock   func(ctrl *gomock.Controller) repository.UserRepository
+		hasher password.Hasher
+	}{
+		{
+			name: "换成了 argon2id，登录的时候重新加密",
+			mock: func(ctrl *gomock.Controller) repository.UserRepository {
+				repo := repomocks.NewMockUserRepository(ctrl)
+				repo.EXPECT().FindByEmail(gomock.Any(), "123@qq.com").
+					Return(domain.User{Id: 123, Email: "123@qq.com", Password: string(hash)}, nil)
+				repo.EXPECT().UpdatePassword(gomock.Any(), int64(123), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, id int64, pwd string) error {
+						assert.Regexp(t, `^\$argon2id\$v=19\$m=1024,t=1,p=1\$`, pwd)
+						return nil
+					})
+				return repo
+			},
+			hasher: password.NewArgon2idHasher(password.Argon2idParams{
+				Time: 1, Memory: 1024, Threads: 1, KeyLen: 32, SaltLen: 16,
+			}),
+		},
+		{
+			name: "提高了 bcrypt 的成本因子",
+			mock: func(ctrl *gomock.Controller) repository.UserRepository {
+				repo := repomocks.NewMockUserRepository(ctrl)
+				repo.EXPECT().FindByEmail(gomock.Any(), "123@qq.com").
+					Return(domain.User{Id: 123, Email: "123@qq.com", Password: string(hash)}, nil)
+				repo.EXPECT().UpdatePassword(gomock.Any(), int64(123), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, id int64, pwd string) error {
+						cost, err := bcrypt.Cost([]byte(pwd))
+						require.NoError(t, err)
+						assert.Equal(t, bcrypt.MinCost+1, cost)
+						return nil
+					})
+				return repo
+			},
+			hasher: password.NewBcryptHasher(bcrypt.MinCost + 1),
+		},
+		{
+			name: "重新加密失败不影响登录",
+			mock: func(ctrl *gomock.Controller) repository.UserRepository {
+				repo := repomocks.NewMockUserRepository(ctrl)
+				repo.EXPECT().FindByEmail(gomock.Any(), "123@qq.com").
+					Return(domain.User{Id: 123, Email: "123@qq.com", Password: string(hash)}, nil)
+				repo.EXPECT().UpdatePassword(gomock.Any(), int64(123), gomock.Any()).
+					Return(errors.New("db 错误"))
+				return repo
+			},
+			hasher: password.NewBcryptHasher(bcrypt.MinCost + 1),
+		},
+		{
+			name: "已经是当前的参数了",
+			mock: func(ctrl *gomock.Controller) repository.UserRepository {
+				repo := repomocks.NewMockUserRepository(ctrl)
+				repo.EXPECT().FindByEmail(gomock.Any(), "123@qq.com").
+					Return(domain.User{Id: 123, Email: "123@qq.com", Password: string(hash)}, nil)
+				return repo
+			},
+			hasher: password.NewBcryptHasher(bcrypt.MinCost),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			svc := NewUserService(tc.mock(ctrl), password.DefaultPolicy(), tc.hasher)
+			u, err := svc.Login(context.Background(), "123@qq.com", "Huawei@123")
+			require.NoError(t, err)
+			assert.Equal(t, int64(123), u.Id)
+		})
+	}
+}
+
+func TestUserService_ResetPassword(t *testing.T) {
+	testCases := []struct {
+		name     string
+		mock     func(ctrl *gomock.Controller) repository.UserRepository
+		password string
+		wantErr  error
+		// 没有通过的密码规则
+		wantRules []string
+	}{
+		{
+			name: "重置成功",
+			mock: func(ctrl *gomock.Controller) repository.UserRepository {
+				repo := repomocks.NewMockUserRepository(ctrl)
+				repo.EXPECT().FindById(gomock.Any(), int64(123)).
+					Return(domain.User{Id: 123, Email: "zhangsan@qq.com"}, nil)
+				repo.EXPECT().UpdatePassword(gomock.Any(), int64(123), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, id int64, pwd string) error {
+						// 存下来的是 bcrypt 加密之后的密码
+						assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(pwd), []byte("Hello#world123")))
+						return nil
+					})
+				return repo
+			},
+			password: "Hello#world123",
+		},
+		{
+			name: "密码和邮箱太像，还是弱密码",
+			mock: func(ctrl *gomock.Controller) repository.UserRepository {
+				repo := repomocks.NewMockUserRepository(ctrl)
+				repo.EXPECT().FindById(gomock.Any(), int64(123)).
+					Return(domain.User{Id: 123, Email: "qwerty@qq.com"}, nil)
+				return repo
+			},
+			password:  "qwerty123",
+			wantRules: []string{password.RuleBanned, password.RuleSimilarToEmail, password.RuleMinEntropy},
+		},
+		{
+			name: "用户不存在",
+			mock: func(ctrl *gomock.Controller) repository.UserRepository {
+				repo := repomocks.NewMockUserRepository(ctrl)
+				repo.EXPECT().FindById(gomock.Any(), int64(123)).
+					Return(domain.User{}, repository.ErrUserNotFound)
+				return repo
+			},
+			password: "Hello#world123",
+			wantErr:  ErrInvalidUserNotFund,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			svc := NewUserService(tc.mock(ctrl), password.DefaultPolicy(), password.NewBcryptHasher(bcrypt.DefaultCost))
+			err := svc.ResetPassword(context.Background(), 123, tc.password)
+			if len(tc.wantRules) == 0 {
+				assert.Equal(t, tc.wantErr, err)
+				return
+			}
+			var policyErr *password.PolicyError
+			require.ErrorAs(t, err, &policyErr)
+			var rules []string
+			for _, v := range policyErr.Violations {
+				rules = append(rules, v.Rule)
+			}
+			assert.Equal(t, tc.wantRules, rules)
+		})
+	}
+}
+
+// TestUserService_ChangePassword 走真正的仓储，缓存里面的用户是没有密码的，
+// 只用 mock 的话测不出来修改密码拿到的是缓存里面的用户
+func TestUserService_ChangePassword(t *testing.T) {
+	testCases := []struct {
+		name        string
+		oldPassword string
+		wantErr     error
+		// wantPassword 修改之后能登录的密码
+		wantPassword string
+	}{
+		{
+			name:         "修改成功",
+			oldPassword:  "hello#world123",
+			wantPassword: "hello#world456",
+		},
+		{
+			name:         "原密码不对",
+			oldPassword:  "hello#world",
+			wantErr:      ErrInvalidUserOrPassword,
+			wantPassword: "hello#world123",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "webook.db")))
+			require.NoError(t, err)
+			require.NoError(t, dao.InitTable(db))
+			repo := repository.NewUserRepository(dao.NewUserDAO(db), cache.NewUserMemoryCache())
+			svc := NewUserService(repo, password.DefaultPolicy(), password.NewBcryptHasher(bcrypt.MinCost))
+			ctx := context.Background()
+
+			err = svc.SignUp(ctx, domain.User{Email: "123@qq.com", Password: "hello#world123"})
+			require.NoError(t, err)
+			u, err := svc.Login(ctx, "123@qq.com", "hello#world123")
+			require.NoError(t, err)
+			// 先查一次个人信息，让用户进缓存
+			_, err = svc.Profile(ctx, u.Id)
+			require.NoError(t, err)
+
+			err = svc.ChangePassword(ctx, u.Id, tc.oldPassword, "hello#world456")
+			assert.Equal(t, tc.wantErr, err)
+			_, err = svc.Login(ctx, "123@qq.com", tc.wantPassword)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestUserService_ChangePassword_UserNotFound(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	repo := repomocks.NewMockUserRepository(ctrl)
+	repo.EXPECT().FindByIdWithPassword(gomock.Any(), int64(123)).
+		Return(domain.User{}, repository.ErrUserNotFound)
+	svc := NewUserService(repo, password.DefaultPolicy(), password.NewBcryptHasher(bcrypt.DefaultCost))
+	err := svc.ChangePassword(context.Background(), 123, "hello#world123", "hello#world456")
+	assert.Equal(t, ErrInvalidUserNotFund, err)
+}
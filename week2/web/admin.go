@@ -0,0 +1,69 @@
+package web
+
+import (
+	"awesomeProject/webook/internal/service"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// AdminHandler 管理员用的接口，哪些用户是管理员由配置决定
+type AdminHandler struct {
+	loginGuardSvc service.LoginGuardService
+	admins        map[int64]bool
+}
+
+func NewAdminHandler(loginGuardSvc service.LoginGuardService, uids []int64) *AdminHandler {
+	admins := make(map[int64]bool, len(uids))
+	for _, uid := range uids {
+		admins[uid] = true
+	}
+	return &AdminHandler{
+		loginGuardSvc: loginGuardSvc,
+		admins:        admins,
+	}
+}
+
+func (h *AdminHandler) RegisterRoutes(server *gin.Engine) {
+	ag := server.Group("/admin", h.RequireAdmin())
+	ag.POST("/users/unlock", h.UnlockUser)
+}
+
+// RequireAdmin 没有登录返回 401，不是管理员返回 403
+func (h *AdminHandler) RequireAdmin() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		uid, ok := loginUid(ctx)
+		if !ok {
+			ctx.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		if !h.admins[uid] {
+			ctx.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+	}
+}
+
+// UnlockUser 提前解锁因为登录失败太多次被锁定的账号
+func (h *AdminHandler) UnlockUser(ctx *gin.Context) {
+	type UnlockReq struct {
+		Email string `json:"email"`
+	}
+	var req UnlockReq
+	if err := ctx.Bind(&req); err != nil {
+		return
+	}
+	if strings.TrimSpace(req.Email) == "" {
+		ctx.JSON(http.StatusOK, Result{Code: 4, Msg: "邮箱不能为空"})
+		return
+	}
+	if err := h.loginGuardSvc.Unlock(ctx, req.Email); err != nil {
+		ctx.JSON(http.StatusOK, Result{Code: 5, Msg: "系统错误"})
+		return
+	}
+	uid, _ := loginUid(ctx)
+	// 管理员操作留个记录
+	log.Printf("管理员 %d 解锁了账号 %s", uid, req.Email)
+	ctx.JSON(http.StatusOK, Result{Msg: "解锁成功"})
+}
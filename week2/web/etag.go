@@ -0,0 +1,33 @@
+package web
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var errInvalidIfMatch = errors.New("If-Match 格式不对")
+
+// profileETag 个人信息的 ETag 就是版本号，比如 "3"
+func profileETag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// parseIfMatch 从 If-Match 里面解析出期望的版本号
+// 没有带或者是 * 返回 0，表示不检查版本号；弱 ETag W/"3" 也当成 "3" 处理
+func parseIfMatch(header string) (int64, error) {
+	header = strings.TrimSpace(header)
+	if header == "" || header == "*" {
+		return 0, nil
+	}
+	header = strings.TrimPrefix(header, "W/")
+	val, err := strconv.Unquote(header)
+	if err != nil {
+		return 0, errInvalidIfMatch
+	}
+	version, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || version <= 0 {
+		return 0, errInvalidIfMatch
+	}
+	return version, nil
+}
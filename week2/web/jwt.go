@@ -0,0 +1,61 @@
+package web
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"time"
+)
+
+// ClaimsKey JWT 中间件校验通过之后，把 *UserClaims 放在 gin.Context 的这个 key 下面
+const ClaimsKey = "claims"
+
+// UidKey 登录中间件校验通过之后，把用户 id（int64）放在 gin.Context 的这个 key 下面，
+// 后面的中间件不用关心是 session 还是 JWT 登录的
+const UidKey = "uid"
+
+// jwtExpiration JWT 的有效期，过期之后要重新登录
+const jwtExpiration = 30 * time.Minute
+
+// UserClaims JWT 里面带的用户信息
+type UserClaims struct {
+	jwt.RegisteredClaims
+	Uid int64
+	// 登录时候的 User-Agent，token 被偷走之后换个浏览器就用不了
+	UserAgent string
+}
+
+// newUserClaims 签发时间也用来判断 token 有没有被撤销
+func newUserClaims(ctx *gin.Context, uid int64) UserClaims {
+	now := time.Now()
+	return UserClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(jwtExpiration)),
+		},
+		Uid:       uid,
+		UserAgent: ctx.GetHeader("User-Agent"),
+	}
+}
+
+// setJWTToken 签发 token 放在 x-jwt-token 响应头里面
+func (u *UserHandler) setJWTToken(ctx *gin.Context, claims UserClaims) error {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS512, claims)
+	tokenStr, err := token.SignedString(u.jwtKey)
+	if err != nil {
+		return err
+	}
+	ctx.Header("x-jwt-token", tokenStr)
+	return nil
+}
+
+// loginUid 当前登录用户的 id，JWT 模式下从 claims 里面取，session 模式下从 session 里面取
+func loginUid(ctx *gin.Context) (int64, bool) {
+	if val, ok := ctx.Get(ClaimsKey); ok {
+		claims, ok := val.(*UserClaims)
+		if !ok {
+			return 0, false
+		}
+		return claims.Uid, true
+	}
+	return sessionUid(ctx)
+}
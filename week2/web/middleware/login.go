@@ -1,17 +1,27 @@
 package middleware
 
 import (
+	"awesomeProject/webook/internal/web"
+	"context"
 	"encoding/gob"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"net/http"
+	"strings"
 	"time"
 )
 
 //LoginMiddlewareBuilder扩展性
 
 type LoginMiddlewareBuilder struct {
-	paths []string
+	paths    []string
+	prefixes []string
+	checker  SessionChecker
+}
+
+// SessionChecker 检查 session 有没有被撤销，比如找回密码之后之前登录的 session 都要失效
+type SessionChecker interface {
+	Valid(ctx context.Context, uid int64, loginTime time.Time) (bool, error)
 }
 
 func NewLoginMiddlewareBuilder() *LoginMiddlewareBuilder {
@@ -22,6 +32,18 @@ func (l *LoginMiddlewareBuilder) IgnorePaths(path string) *LoginMiddlewareBuilde
 	return l
 }
 
+// IgnorePathPrefix 以 prefix 开头的路径都不需要登录，比如头像这类静态文件
+func (l *LoginMiddlewareBuilder) IgnorePathPrefix(prefix string) *LoginMiddlewareBuilder {
+	l.prefixes = append(l.prefixes, prefix)
+	return l
+}
+
+// CheckSession 每个请求都检查 session 有没有被撤销
+func (l *LoginMiddlewareBuilder) CheckSession(checker SessionChecker) *LoginMiddlewareBuilder {
+	l.checker = checker
+	return l
+}
+
 func (l *LoginMiddlewareBuilder) Build() gin.HandlerFunc {
 	// 用 Go 的方式编码解码
 	gob.Register(time.Now())
@@ -32,6 +54,11 @@ func (l *LoginMiddlewareBuilder) Build() gin.HandlerFunc {
 				return
 			}
 		}
+		for _, prefix := range l.prefixes {
+			if strings.HasPrefix(ctx.Request.URL.Path, prefix) {
+				return
+			}
+		}
 		sess := sessions.Default(ctx)
 		println(1111)
 		id := sess.Get("userId")
@@ -41,6 +68,27 @@ func (l *LoginMiddlewareBuilder) Build() gin.HandlerFunc {
 			ctx.AbortWithStatus(http.StatusUnauthorized)
 			return
 		}
+		uid, _ := id.(int64)
+		if l.checker != nil {
+			// 登录的时候记下来的纳秒时间戳，老的 session 没有这个值，当成很早之前登录的
+			loginTime, _ := sess.Get("login_time").(int64)
+			ok, err := l.checker.Valid(ctx, uid, time.Unix(0, loginTime))
+			if err != nil {
+				ctx.AbortWithStatus(http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				// 已经被撤销了，顺便把 cookie 清掉
+				sess.Clear()
+				sess.Options(sessions.Options{
+					MaxAge: -1,
+				})
+				_ = sess.Save()
+				ctx.AbortWithStatus(http.StatusUnauthorized)
+				return
+			}
+		}
+		ctx.Set(web.UidKey, uid)
 		updateTime := sess.Get("update_time")
 		//sess.Set("userId", id)
 		sess.Options(sessions.Options{
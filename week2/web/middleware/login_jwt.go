@@ -1,26 +1,47 @@
 package middleware
 
 import (
+	"awesomeProject/webook/internal/web"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"net/http"
 	"strings"
+	"time"
 )
 
 //LoginMiddlewareBuilder扩展性
 
 type LoginJWTMiddlewareBuilder struct {
-	paths []string
+	paths    []string
+	prefixes []string
+	key      []byte
+	checker  SessionChecker
 }
 
-func NewLoginJWTMiddlewareBuilder() *LoginJWTMiddlewareBuilder {
-	return &LoginJWTMiddlewareBuilder{}
+// NewLoginJWTMiddlewareBuilder key 是签名 JWT 用的密钥，要和 UserHandler 用的一致
+func NewLoginJWTMiddlewareBuilder(key []byte) *LoginJWTMiddlewareBuilder {
+	return &LoginJWTMiddlewareBuilder{
+		key: key,
+	}
 }
 func (l *LoginJWTMiddlewareBuilder) IgnorePaths(path string) *LoginJWTMiddlewareBuilder {
 	l.paths = append(l.paths, path)
 	return l
 }
 
+// IgnorePathPrefix 以 prefix 开头的路径都不需要登录，比如头像这类静态文件
+func (l *LoginJWTMiddlewareBuilder) IgnorePathPrefix(prefix string) *LoginJWTMiddlewareBuilder {
+	l.prefixes = append(l.prefixes, prefix)
+	return l
+}
+
+// CheckSession 检查 token 有没有被撤销，比如找回密码之后之前签发的 token 都要失效
+// 签发时间只精确到秒，撤销的同一秒里面签发的 token 也会被当成撤销了
+func (l *LoginJWTMiddlewareBuilder) CheckSession(checker SessionChecker) *LoginJWTMiddlewareBuilder {
+	l.checker = checker
+	return l
+}
+
 func (l *LoginJWTMiddlewareBuilder) Build() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		//不需要登录校验
@@ -29,6 +50,11 @@ func (l *LoginJWTMiddlewareBuilder) Build() gin.HandlerFunc {
 				return
 			}
 		}
+		for _, prefix := range l.prefixes {
+			if strings.HasPrefix(ctx.Request.URL.Path, prefix) {
+				return
+			}
+		}
 		//sess := sessions.Default(ctx)
 		//id := sess.Get("userID")
 		//if id == nil {
@@ -51,19 +77,44 @@ func (l *LoginJWTMiddlewareBuilder) Build() gin.HandlerFunc {
 		}
 
 		tokenStr := segs[1]
-		token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-			return []byte("95osj3fUD7fo0mlYdDbncXz4VD2igvf0"), nil
-		})
+		claims := &web.UserClaims{}
+		// 只接受签名用的算法，没有过期时间的 token 也不要
+		token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
+			return l.key, nil
+		}, jwt.WithValidMethods([]string{jwt.SigningMethodHS512.Alg()}), jwt.WithExpirationRequired())
 		if err != nil {
 			//没登录
 			ctx.AbortWithStatus(http.StatusUnauthorized)
 			return
 		}
-		if token == nil || !token.Valid {
+		if token == nil || !token.Valid || claims.Uid <= 0 {
 			//没登录
 			ctx.AbortWithStatus(http.StatusUnauthorized)
 			return
 		}
+		if claims.UserAgent != ctx.GetHeader("User-Agent") {
+			// 换了浏览器，token 可能是被偷走的
+			ctx.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		if l.checker != nil {
+			// 没有签发时间的 token 当成很早之前签发的
+			var issuedAt time.Time
+			if claims.IssuedAt != nil {
+				issuedAt = claims.IssuedAt.Time
+			}
+			ok, err := l.checker.Valid(ctx, claims.Uid, issuedAt)
+			if err != nil {
+				ctx.AbortWithStatus(http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				ctx.AbortWithStatus(http.StatusUnauthorized)
+				return
+			}
+		}
+		ctx.Set(web.ClaimsKey, claims)
+		ctx.Set(web.UidKey, claims.Uid)
 	}
 
 }
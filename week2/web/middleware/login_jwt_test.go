@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"awesomeProject/webook/internal/web"
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// checkerFunc 测试用的 SessionChecker
+type checkerFunc func(ctx context.Context, uid int64, loginTime time.Time) (bool, error)
+
+func (f checkerFunc) Valid(ctx context.Context, uid int64, loginTime time.Time) (bool, error) {
+	return f(ctx, uid, loginTime)
+}
+
+func TestLoginJWTMiddlewareBuilder(t *testing.T) {
+	key := []byte("95osj3fUD7fo0mlYdDbncXz4VD2igvf0")
+	const ua = "Mozilla/5.0"
+	now := time.Now()
+	sign := func(claims web.UserClaims, method jwt.SigningMethod, key any) string {
+		tokenStr, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		require.NoError(t, err)
+		return tokenStr
+	}
+	claims := func(exp time.Time) web.UserClaims {
+		return web.UserClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				IssuedAt:  jwt.NewNumericDate(now),
+				ExpiresAt: jwt.NewNumericDate(exp),
+			},
+			Uid:       123,
+			UserAgent: ua,
+		}
+	}
+	valid := sign(claims(now.Add(time.Minute)), jwt.SigningMethodHS512, key)
+
+	testCases := []struct {
+		name      string
+		token     string
+		userAgent string
+		checker   SessionChecker
+		wantCode  int
+		wantUid   int64
+	}{
+		{
+			name:      "校验通过",
+			token:     valid,
+			userAgent: ua,
+			wantCode:  http.StatusOK,
+			wantUid:   123,
+		},
+		{
+			name:      "过期了",
+			token:     sign(claims(now.Add(-time.Minute)), jwt.SigningMethodHS512, key),
+			userAgent: ua,
+			wantCode:  http.StatusUnauthorized,
+		},
+		{
+			name:      "没有过期时间",
+			token:     sign(web.UserClaims{Uid: 123, UserAgent: ua}, jwt.SigningMethodHS512, key),
+			userAgent: ua,
+			wantCode:  http.StatusUnauthorized,
+		},
+		{
+			name:      "签名算法不对",
+			token:     sign(claims(now.Add(time.Minute)), jwt.SigningMethodHS256, key),
+			userAgent: ua,
+			wantCode:  http.StatusUnauthorized,
+		},
+		{
+			name:      "换了浏览器",
+			token:     valid,
+			userAgent: "curl/8.0",
+			wantCode:  http.StatusUnauthorized,
+		},
+		{
+			name:      "已经撤销了",
+			token:     valid,
+			userAgent: ua,
+			checker: checkerFunc(func(ctx context.Context, uid int64, loginTime time.Time) (bool, error) {
+				assert.Equal(t, int64(123), uid)
+				assert.Equal(t, now.Unix(), loginTime.Unix())
+				return false, nil
+			}),
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:      "检查撤销出错",
+			token:     valid,
+			userAgent: ua,
+			checker: checkerFunc(func(ctx context.Context, uid int64, loginTime time.Time) (bool, error) {
+				return false, errors.New("redis 错误")
+			}),
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			builder := NewLoginJWTMiddlewareBuilder(key)
+			if tc.checker != nil {
+				builder.CheckSession(tc.checker)
+			}
+			server := gin.New()
+			server.Use(builder.Build())
+			var uid int64
+			server.GET("/users/profile", func(ctx *gin.Context) {
+				val, _ := ctx.Get(web.ClaimsKey)
+				uid = val.(*web.UserClaims).Uid
+				// 限流这些后面的中间件直接拿用户 id
+				assert.Equal(t, uid, ctx.GetInt64(web.UidKey))
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/users/profile", nil)
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+			req.Header.Set("User-Agent", tc.userAgent)
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+
+			assert.Equal(t, tc.wantCode, resp.Code)
+			assert.Equal(t, tc.wantUid, uid)
+		})
+	}
+}
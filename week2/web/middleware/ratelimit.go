@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"awesomeProject/webook/internal/ratelimit"
+	"awesomeProject/webook/internal/web"
+	"github.com/gin-gonic/gin"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// KeyFunc 决定按照什么限流，返回值相同的请求共享限额
+type KeyFunc func(ctx *gin.Context) string
+
+// KeyByIP 按照客户端 IP 限流
+func KeyByIP(ctx *gin.Context) string {
+	return "ip:" + ctx.ClientIP()
+}
+
+// KeyByUid 按照登录用户限流，没有登录的按照 IP 限流
+// 用户 id 是登录中间件放进来的，所以要注册在登录中间件（session 或者 JWT）后面，
+// 注册在前面的话拿不到用户 id，全部退化成按照 IP 限流
+func KeyByUid(ctx *gin.Context) string {
+	val, _ := ctx.Get(web.UidKey)
+	if uid, ok := val.(int64); ok {
+		return "uid:" + strconv.FormatInt(uid, 10)
+	}
+	return KeyByIP(ctx)
+}
+
+// KeyByRoute 按照路由限流，所有人共享一个接口的限额
+func KeyByRoute(ctx *gin.Context) string {
+	return "route:" + route(ctx)
+}
+
+// route 匹配到的路由，比如 POST /users/login，没有匹配到的用请求的路径
+func route(ctx *gin.Context) string {
+	path := ctx.FullPath()
+	if path == "" {
+		path = ctx.Request.URL.Path
+	}
+	return ctx.Request.Method + " " + path
+}
+
+// RateLimitBuilder 限流中间件，Limit 对所有接口生效，Route 对单个接口额外限流
+// 放在 server.Use 里面的时候已经匹配好了路由，可以按照路由区分
+type RateLimitBuilder struct {
+	limiter ratelimit.Limiter
+	routes  map[string]ratelimit.Limiter
+	key     KeyFunc
+}
+
+// NewRateLimitBuilder 默认按照 IP 限流
+func NewRateLimitBuilder() *RateLimitBuilder {
+	return &RateLimitBuilder{
+		routes: make(map[string]ratelimit.Limiter),
+		key:    KeyByIP,
+	}
+}
+
+// KeyBy 按照什么限流，可以是 KeyByIP、KeyByUid、KeyByRoute 或者自己写的 KeyFunc
+func (b *RateLimitBuilder) KeyBy(key KeyFunc) *RateLimitBuilder {
+	b.key = key
+	return b
+}
+
+// Limit 所有接口都要经过的限流
+func (b *RateLimitBuilder) Limit(limiter ratelimit.Limiter) *RateLimitBuilder {
+	b.limiter = limiter
+	return b
+}
+
+// Route 单独给某个接口限流，path 是注册路由时候的路径，比如 /users/login
+// 同一个 key 在不同接口的限额是分开算的
+func (b *RateLimitBuilder) Route(method, path string, limiter ratelimit.Limiter) *RateLimitBuilder {
+	b.routes[method+" "+path] = limiter
+	return b
+}
+
+func (b *RateLimitBuilder) Build() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		key := b.key(ctx)
+		if b.limiter != nil && b.limited(ctx, b.limiter, key) {
+			return
+		}
+		r := route(ctx)
+		if limiter, ok := b.routes[r]; ok {
+			b.limited(ctx, limiter, r+":"+key)
+		}
+	}
+}
+
+// limited 被限流了返回 429 和 Retry-After
+// 限流器出错的时候放行，不能因为 Redis 出问题整个网站都用不了
+func (b *RateLimitBuilder) limited(ctx *gin.Context, limiter ratelimit.Limiter, key string) bool {
+	limited, retryAfter, err := limiter.Limit(ctx, key)
+	if err != nil {
+		log.Printf("限流出错 key: %s, err: %v", key, err)
+		return false
+	}
+	if !limited {
+		return false
+	}
+	// Retry-After 只能是整数秒，向上取整，至少 1 秒
+	secs := int64(math.Max(1, math.Ceil(retryAfter.Seconds())))
+	ctx.Header("Retry-After", strconv.FormatInt(secs, 10))
+	ctx.AbortWithStatus(http.StatusTooManyRequests)
+	return true
+}
@@ -0,0 +1,213 @@
+package middleware
+
+import (
+	"awesomeProject/webook/internal/ratelimit"
+	"awesomeProject/webook/internal/web"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRateLimitBuilder(t *testing.T) {
+	server := gin.New()
+	server.Use(NewRateLimitBuilder().
+		Limit(ratelimit.NewSlidingWindowMemoryLimiter(time.Minute, 3)).
+		Route(http.MethodPost, "/users/login", ratelimit.NewSlidingWindowMemoryLimiter(time.Minute, 1)).
+		Build())
+	server.POST("/users/login", func(ctx *gin.Context) {})
+	server.GET("/users/profile", func(ctx *gin.Context) {})
+
+	serve := func(method, path, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = ip + ":1234"
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, req)
+		return resp
+	}
+
+	// 登录接口单独限流，一分钟一次
+	assert.Equal(t, http.StatusOK, serve(http.MethodPost, "/users/login", "10.0.0.1").Code)
+	resp := serve(http.MethodPost, "/users/login", "10.0.0.1")
+	assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+	assert.Equal(t, "60", resp.Header().Get("Retry-After"))
+	// 别的 IP 不受影响
+	assert.Equal(t, http.StatusOK, serve(http.MethodPost, "/users/login", "10.0.0.2").Code)
+
+	// 所有接口加起来一分钟三次，上面被限流的请求也算
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/users/profile", "10.0.0.1").Code)
+	resp = serve(http.MethodGet, "/users/profile", "10.0.0.1")
+	assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+	assert.NotEmpty(t, resp.Header().Get("Retry-After"))
+}
+
+func TestKeyFunc(t *testing.T) {
+	testCases := []struct {
+		name    string
+		key     KeyFunc
+		method  string
+		path    string
+		before  func(ctx *gin.Context)
+		wantKey string
+	}{
+		{
+			name:    "按照 IP",
+			key:     KeyByIP,
+			method:  http.MethodGet,
+			path:    "/users/profile",
+			wantKey: "ip:10.0.0.1",
+		},
+		{
+			name:   "按照用户",
+			key:    KeyByUid,
+			method: http.MethodGet,
+			path:   "/users/profile",
+			before: func(ctx *gin.Context) {
+				ctx.Set(web.UidKey, int64(123))
+			},
+			wantKey: "uid:123",
+		},
+		{
+			name:    "没有登录，按照 IP",
+			key:     KeyByUid,
+			method:  http.MethodGet,
+			path:    "/users/profile",
+			wantKey: "ip:10.0.0.1",
+		},
+		{
+			name:   "用户 id 类型不对，按照 IP",
+			key:    KeyByUid,
+			method: http.MethodGet,
+			path:   "/users/profile",
+			before: func(ctx *gin.Context) {
+				ctx.Set(web.UidKey, "123")
+			},
+			wantKey: "ip:10.0.0.1",
+		},
+		{
+			name:    "按照路由，用注册时候的路径",
+			key:     KeyByRoute,
+			method:  http.MethodGet,
+			path:    "/users/123",
+			wantKey: "route:GET /users/:id",
+		},
+		{
+			name:    "没有匹配到路由，用请求的路径",
+			key:     KeyByRoute,
+			method:  http.MethodPost,
+			path:    "/not/found",
+			wantKey: "route:POST /not/found",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var key string
+			server := gin.New()
+			server.Use(func(ctx *gin.Context) {
+				if tc.before != nil {
+					tc.before(ctx)
+				}
+				key = tc.key(ctx)
+			})
+			server.GET("/users/profile", func(ctx *gin.Context) {})
+			server.GET("/users/:id", func(ctx *gin.Context) {})
+
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			req.RemoteAddr = "10.0.0.1:1234"
+			server.ServeHTTP(httptest.NewRecorder(), req)
+			assert.Equal(t, tc.wantKey, key)
+		})
+	}
+}
+
+// TestRateLimitBuilder_KeyByUid 同一个 IP 后面的不同用户分开限流
+func TestRateLimitBuilder_KeyByUid(t *testing.T) {
+	server := gin.New()
+	server.Use(sessions.Sessions("mysession", cookie.NewStore([]byte("secret"))))
+	server.Use(NewLoginMiddlewareBuilder().IgnorePaths("/users/login").Build())
+	server.Use(NewRateLimitBuilder().
+		KeyBy(KeyByUid).
+		Route(http.MethodGet, "/users/profile", ratelimit.NewSlidingWindowMemoryLimiter(time.Minute, 1)).
+		Build())
+	server.POST("/users/login", func(ctx *gin.Context) {
+		uid, _ := strconv.ParseInt(ctx.Query("uid"), 10, 64)
+		sess := sessions.Default(ctx)
+		sess.Set("userId", uid)
+		require.NoError(t, sess.Save())
+	})
+	server.GET("/users/profile", func(ctx *gin.Context) {})
+
+	login := func(uid string) string {
+		req := httptest.NewRequest(http.MethodPost, "/users/login?uid="+uid, nil)
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusOK, resp.Code)
+		return resp.Header().Get("Set-Cookie")
+	}
+	profile := func(cookie string) int {
+		req := httptest.NewRequest(http.MethodGet, "/users/profile", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("Cookie", cookie)
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	user1, user2 := login("1"), login("2")
+	assert.Equal(t, http.StatusOK, profile(user1))
+	assert.Equal(t, http.StatusTooManyRequests, profile(user1))
+	assert.Equal(t, http.StatusOK, profile(user2))
+}
+
+// TestRateLimitBuilder_KeyByUidBeforeLogin 注册在 session 中间件前面也不会 panic，退化成按照 IP 限流
+func TestRateLimitBuilder_KeyByUidBeforeLogin(t *testing.T) {
+	server := gin.New()
+	server.Use(NewRateLimitBuilder().
+		KeyBy(KeyByUid).
+		Limit(ratelimit.NewSlidingWindowMemoryLimiter(time.Minute, 1)).
+		Build())
+	server.Use(sessions.Sessions("mysession", cookie.NewStore([]byte("secret"))))
+	server.GET("/users/profile", func(ctx *gin.Context) {})
+
+	serve := func(ip string) int {
+		req := httptest.NewRequest(http.MethodGet, "/users/profile", nil)
+		req.RemoteAddr = ip + ":1234"
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, req)
+		return resp.Code
+	}
+	assert.Equal(t, http.StatusOK, serve("10.0.0.1"))
+	assert.Equal(t, http.StatusTooManyRequests, serve("10.0.0.1"))
+	assert.Equal(t, http.StatusOK, serve("10.0.0.2"))
+}
+
+func TestRateLimitBuilder_TokenBucket(t *testing.T) {
+	server := gin.New()
+	// 一秒一个令牌，最多攒两个
+	server.Use(NewRateLimitBuilder().
+		Limit(ratelimit.NewTokenBucketMemoryLimiter(time.Second, 2)).
+		Build())
+	server.GET("/users/profile", func(ctx *gin.Context) {})
+
+	serve := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/users/profile", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, req)
+		return resp
+	}
+
+	// 允许突发两个请求
+	assert.Equal(t, http.StatusOK, serve().Code)
+	assert.Equal(t, http.StatusOK, serve().Code)
+	resp := serve()
+	assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+	assert.Equal(t, "1", resp.Header().Get("Retry-After"))
+}
@@ -0,0 +1,49 @@
+package web
+
+import (
+	"awesomeProject/webook/internal/domain"
+	"strings"
+	"time"
+)
+
+const (
+	// 用户没有设置时区的时候用的默认时区
+	defaultTimezone = "Asia/Shanghai"
+	// 默认的时间格式，保留毫秒
+	defaultTimeLayout = "2006-01-02 15:04:05.000"
+	// RFC 3339 带毫秒，客户端可以自己换算成本地时间
+	rfc3339MilliLayout = "2006-01-02T15:04:05.000Z07:00"
+)
+
+// userLocation 加载用户的时区，没有设置或者加载失败都用默认时区
+func userLocation(timezone string) *time.Location {
+	if timezone == "" {
+		timezone = defaultTimezone
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err == nil {
+		return loc
+	}
+	loc, err = time.LoadLocation(defaultTimezone)
+	if err == nil {
+		return loc
+	}
+	// 连默认时区都加载不了，说明运行环境没有时区数据
+	return time.UTC
+}
+
+// timeLayout 根据 time_format 参数选择时间格式
+func timeLayout(format string) string {
+	if strings.EqualFold(format, "rfc3339") {
+		return rfc3339MilliLayout
+	}
+	return defaultTimeLayout
+}
+
+// formatBirthday 生日是日期，不需要换算时区，没有填就返回空字符串
+func formatBirthday(birthday time.Time) string {
+	if birthday.IsZero() {
+		return ""
+	}
+	return birthday.Format(domain.BirthdayLayout)
+}
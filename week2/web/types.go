@@ -0,0 +1,9 @@
+package web
+
+// Result 统一的 JSON 响应
+// Code 0 表示成功，4 表示用户输入有误，5 表示系统错误，6 表示登录失败次数太多被暂时锁定
+type Result struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+	Data any    `json:"data"`
+}
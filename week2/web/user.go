@@ -3,6 +3,8 @@ package web
 import (
 	"awesomeProject/webook/internal/domain"
 	"awesomeProject/webook/internal/service"
+	"awesomeProject/webook/internal/service/password"
+	"errors"
 	"fmt"
 	regexp "github.com/dlclark/regexp2"
 	"github.com/gin-contrib/sessions"
@@ -10,31 +12,89 @@ import (
 	"github.com/gin-gonic/gin/binding"
 	"github.com/go-playground/validator/v10"
 	"github.com/golang-jwt/jwt/v5"
+	"io"
+	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 	"unicode/utf8"
 )
 
+// biz 短信验证码登录的业务标识
+const biz = "login"
+
 type UserHandler struct {
-	svc         *service.UserService
-	emailExp    *regexp.Regexp
-	passwordExp *regexp.Regexp
+	svc       service.UserService
+	codeSvc   service.CodeService
+	avatarSvc service.AvatarService
+	// 验证邮箱用的服务
+	emailVerifySvc service.EmailVerifyService
+	// 找回密码用的服务
+	passwordResetSvc service.PasswordResetService
+	// 修改密码之后让别的 session 失效
+	sessionSvc service.SessionService
+	// 可选，设置了之后密码登录失败太多次会被暂时锁定
+	loginGuardSvc service.LoginGuardService
+	jwtKey        []byte
+	emailExp      *regexp.Regexp
+	// 登录成功之后签发 JWT，不设置 session
+	jwtLogin bool
 }
 
-func NewUserHandler(svc *service.UserService) *UserHandler {
-	const (
-		emailRegexPattern    = "^\\w+([-+.]\\w+)*@\\w+([-.]\\w+)*\\.\\w+([-.]\\w+)*$"
-		passwordRegexPattern = `^(?=.*[A-Za-z])(?=.*\d)(?=.*[$@$!%*#?&])[A-Za-z\d$@$!%*#?&]{8,}$`
-	)
+func NewUserHandler(svc service.UserService, codeSvc service.CodeService) *UserHandler {
+	// 密码由 UserService 按照密码策略检查
+	const emailRegexPattern = "^\\w+([-+.]\\w+)*@\\w+([-.]\\w+)*\\.\\w+([-.]\\w+)*$"
 	emailExp := regexp.MustCompile(emailRegexPattern, regexp.None)
-	passwordExp := regexp.MustCompile(passwordRegexPattern, regexp.None)
 	return &UserHandler{
-		svc:         svc,
-		emailExp:    emailExp,
-		passwordExp: passwordExp,
+		svc:      svc,
+		codeSvc:  codeSvc,
+		emailExp: emailExp,
 	}
 }
 
+// WithJWTKey 设置签名 JWT 用的密钥
+func (u *UserHandler) WithJWTKey(key []byte) *UserHandler {
+	u.jwtKey = key
+	return u
+}
+
+// WithJWTLogin 登录成功之后签发 JWT，不再设置 session，要和 JWT 登录中间件一起用
+func (u *UserHandler) WithJWTLogin() *UserHandler {
+	u.jwtLogin = true
+	return u
+}
+
+// WithAvatarService 设置上传头像用的服务
+func (u *UserHandler) WithAvatarService(svc service.AvatarService) *UserHandler {
+	u.avatarSvc = svc
+	return u
+}
+
+// WithEmailVerifyService 设置验证邮箱用的服务，设置了之后注册成功会发送验证邮件
+func (u *UserHandler) WithEmailVerifyService(svc service.EmailVerifyService) *UserHandler {
+	u.emailVerifySvc = svc
+	return u
+}
+
+// WithPasswordResetService 设置找回密码用的服务
+func (u *UserHandler) WithPasswordResetService(svc service.PasswordResetService) *UserHandler {
+	u.passwordResetSvc = svc
+	return u
+}
+
+// WithSessionService 设置撤销 session 用的服务
+func (u *UserHandler) WithSessionService(svc service.SessionService) *UserHandler {
+	u.sessionSvc = svc
+	return u
+}
+
+// WithLoginGuardService 设置防暴力破解用的服务
+func (u *UserHandler) WithLoginGuardService(svc service.LoginGuardService) *UserHandler {
+	u.loginGuardSvc = svc
+	return u
+}
+
 //func (u *UserHandler) RegisterRoutesV1(ug *gin.RouterGroup) {
 //	ug.GET("/profile", u.Profile)
 //	ug.POST("/login", u.Login)
@@ -45,11 +105,25 @@ func NewUserHandler(svc *service.UserService) *UserHandler {
 func (u *UserHandler) RegisterRoutes(server *gin.Engine) {
 	ug := server.Group("/users")
 	ug.GET("/profile", u.Profile)
+	ug.PATCH("/profile", u.PatchProfile)
+	ug.GET("/profile/history", u.ProfileHistory)
+	// 头像别人也能看到，没有验证邮箱的账号不能上传
+	ug.POST("/avatar", u.RequireVerifiedEmail(), u.UploadAvatar)
+	ug.GET("/verify_email", u.VerifyEmail)
+	ug.POST("/verify_email/resend", u.ResendVerifyEmail)
 	ug.POST("/signup", u.SignUp)
-	ug.POST("/login", u.Login)
+	if u.jwtLogin {
+		ug.POST("/login", u.LoginJWT)
+	} else {
+		ug.POST("/login", u.Login)
+	}
 	ug.GET("/logout", u.Logout)
-	//ug.POST("/login", u.LoginJWT)
 	ug.POST("/edit", u.Edit)
+	ug.POST("/login_sms/code/send", u.SendLoginSMSCode)
+	ug.POST("/login_sms", u.LoginSMS)
+	ug.POST("/password", u.ChangePassword)
+	ug.POST("/password/reset/code", u.SendResetPasswordCode)
+	ug.POST("/password/reset", u.ResetPassword)
 }
 
 func (u *UserHandler) SignUp(ctx *gin.Context) {
@@ -77,16 +151,6 @@ func (u *UserHandler) SignUp(ctx *gin.Context) {
 		ctx.String(http.StatusOK, "两次输入的密码不一致")
 		return
 	}
-	ok, err = u.passwordExp.MatchString(req.Password)
-	if err != nil {
-		//记录日志
-		ctx.String(http.StatusOK, "系统错误")
-		return
-	}
-	if !ok {
-		ctx.String(http.StatusOK, "密码必须大于8位,包括数字、特殊字符")
-		return
-	}
 	//ctx.String(http.StatusOK, "注册成功")
 	//fmt.Printf("%v", req)
 	//这面就是数据库操作
@@ -101,17 +165,90 @@ func (u *UserHandler) SignUp(ctx *gin.Context) {
 		ctx.String(http.StatusOK, "邮箱冲突")
 		return
 	}
+	// 密码没有通过的规则全部告诉用户
+	var policyErr *password.PolicyError
+	if errors.As(err, &policyErr) {
+		ctx.String(http.StatusOK, policyErr.Error())
+		return
+	}
 
 	if err != nil {
 		ctx.String(http.StatusOK, "系统异常")
 		return
 	}
+	// 验证邮件发送失败不影响注册，用户登录之后可以重新发送
+	if u.emailVerifySvc != nil {
+		if err = u.emailVerifySvc.Send(ctx, req.Email); err != nil {
+			log.Printf("发送验证邮件失败 email: %s, err: %v", req.Email, err)
+		}
+	}
 	ctx.String(http.StatusOK, "注册成功")
 }
 
+// VerifyEmail 验证邮件里面的链接，?token=xxx，不需要登录
+func (u *UserHandler) VerifyEmail(ctx *gin.Context) {
+	err := u.emailVerifySvc.Verify(ctx, ctx.Query("token"))
+	switch err {
+	case nil:
+		ctx.JSON(http.StatusOK, Result{Msg: "邮箱验证成功"})
+	case service.ErrInvalidEmailVerifyToken:
+		ctx.JSON(http.StatusOK, Result{Code: 4, Msg: err.Error()})
+	default:
+		ctx.JSON(http.StatusOK, Result{Code: 5, Msg: "系统错误"})
+	}
+}
+
+// ResendVerifyEmail 重新发送验证邮件，比如之前的链接过期了
+func (u *UserHandler) ResendVerifyEmail(ctx *gin.Context) {
+	uid, ok := loginUid(ctx)
+	if !ok {
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	user, err := u.svc.Profile(ctx, uid)
+	if err != nil {
+		ctx.JSON(http.StatusOK, Result{Code: 5, Msg: "系统错误"})
+		return
+	}
+	if user.Email == "" {
+		ctx.JSON(http.StatusOK, Result{Code: 4, Msg: "没有绑定邮箱"})
+		return
+	}
+	err = u.emailVerifySvc.Send(ctx, user.Email)
+	switch err {
+	case nil:
+		ctx.JSON(http.StatusOK, Result{Msg: "验证邮件已经发送"})
+	case service.ErrEmailAlreadyVerified:
+		ctx.JSON(http.StatusOK, Result{Code: 4, Msg: err.Error()})
+	default:
+		ctx.JSON(http.StatusOK, Result{Code: 5, Msg: "系统错误"})
+	}
+}
+
+// RequireVerifiedEmail 挂在路由上，填了邮箱但是还没有验证的用户不能访问
+// 手机号注册的用户没有邮箱，不受影响
+func (u *UserHandler) RequireVerifiedEmail() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		uid, ok := loginUid(ctx)
+		if !ok {
+			ctx.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		user, err := u.svc.Profile(ctx, uid)
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusOK, Result{Code: 5, Msg: "系统错误"})
+			return
+		}
+		if user.EmailUnverified() {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, Result{Code: 4, Msg: "请先验证邮箱"})
+			return
+		}
+	}
+}
+
 func (u *UserHandler) Login(ctx *gin.Context) {
 	type LoginReq struct {
-		Email    string `json:email`
+		Email    string `json:"email"`
 		Password string `json:"password"`
 	}
 	var req LoginReq
@@ -120,37 +257,304 @@ func (u *UserHandler) Login(ctx *gin.Context) {
 	}
 	println(req.Email)
 	println(req.Password)
-	user, err := u.svc.Login(ctx, req.Email, req.Password)
+	user, ok := u.passwordLogin(ctx, req.Email, req.Password)
+	if !ok {
+		return
+	}
 	fmt.Printf("%v", user)
+
+	//步骤2
+	//这里登录成功了，设置session
+	if err := u.setLoginSession(ctx, user.Id); err != nil {
+		ctx.String(http.StatusOK, "系统错误")
+		return
+	}
+	ctx.String(http.StatusOK, "登录成功")
+
+	return
+}
+
+// passwordLogin 邮箱密码登录，session 和 JWT 两种方式共用
+// 失败次数太多会被暂时锁定，返回 false 的时候已经写好了响应
+func (u *UserHandler) passwordLogin(ctx *gin.Context, email, pwd string) (domain.User, bool) {
+	if u.loginGuardSvc != nil {
+		err := u.loginGuardSvc.Check(ctx, email, ctx.ClientIP())
+		if err != nil {
+			u.loginLocked(ctx, err)
+			return domain.User{}, false
+		}
+	}
+	user, err := u.svc.Login(ctx, email, pwd)
 	if err == service.ErrInvalidUserOrPassword {
+		if u.loginGuardSvc != nil {
+			if err = u.loginGuardSvc.Fail(ctx, email, ctx.ClientIP()); err != nil {
+				log.Printf("记录登录失败出错 email: %s, err: %v", email, err)
+			}
+		}
 		ctx.String(http.StatusOK, "用户名或者密码不对")
-		return
+		return domain.User{}, false
 	}
 	if err != nil {
+		ctx.String(http.StatusOK, "系统错误")
+		return domain.User{}, false
+	}
+	if u.loginGuardSvc != nil {
+		// 清不掉也不影响这次登录，顶多下次失败的时候多算几次
+		if err = u.loginGuardSvc.Unlock(ctx, email); err != nil {
+			log.Printf("清除登录失败记录出错 email: %s, err: %v", email, err)
+		}
+	}
+	return user, true
+}
+
+// loginLocked 被锁定了返回 429 和 Retry-After，业务码是 6
+func (u *UserHandler) loginLocked(ctx *gin.Context, err error) {
+	var lockedErr *service.LoginLockedError
+	if !errors.As(err, &lockedErr) {
 		ctx.String(http.StatusOK, "系统错误")
 		return
 	}
+	ctx.Header("Retry-After", strconv.FormatInt(lockedErr.Seconds(), 10))
+	ctx.JSON(http.StatusTooManyRequests, Result{
+		Code: 6,
+		Msg:  lockedErr.Error(),
+		Data: LoginLockedVo{RetryAfter: lockedErr.Seconds()},
+	})
+}
 
-	//步骤2
-	//这里登录成功了，设置session
-	//sess := sessions.Default(ctx)
+// setLogin 按照登录方式设置 session 或者签发 JWT，短信登录用
+func (u *UserHandler) setLogin(ctx *gin.Context, uid int64) error {
+	if u.jwtLogin {
+		return u.setJWTToken(ctx, newUserClaims(ctx, uid))
+	}
+	return u.setLoginSession(ctx, uid)
+}
+
+// setLoginSession 登录成功后设置 session，密码登录和短信登录共用
+func (u *UserHandler) setLoginSession(ctx *gin.Context, uid int64) error {
 	sess := sessions.Default(ctx)
-	sess.Set("userId", user.Id)
+	sess.Set("userId", uid)
+	// 找回密码之后，这个时间之前登录的 session 都会失效
+	sess.Set("login_time", time.Now().UnixNano())
 	sess.Options(sessions.Options{
 		//Secure: true,
 		HttpOnly: true,
 		MaxAge:   120,
 	})
-	sess.Save()
-	//sess.Save()
-	ctx.String(http.StatusOK, "登录成功")
+	return sess.Save()
+}
 
-	return
+func (u *UserHandler) SendLoginSMSCode(ctx *gin.Context) {
+	type Req struct {
+		Phone string `json:"phone"`
+	}
+	var req Req
+	if err := ctx.Bind(&req); err != nil {
+		return
+	}
+	if req.Phone == "" {
+		ctx.JSON(http.StatusOK, Result{
+			Code: 4,
+			Msg:  "请输入手机号码",
+		})
+		return
+	}
+	err := u.codeSvc.Send(ctx, biz, req.Phone)
+	switch err {
+	case nil:
+		ctx.JSON(http.StatusOK, Result{
+			Msg: "发送成功",
+		})
+	case service.ErrCodeSendTooMany:
+		ctx.JSON(http.StatusOK, Result{
+			Code: 4,
+			Msg:  "短信发送太频繁，请稍后再试",
+		})
+	default:
+		ctx.JSON(http.StatusOK, Result{
+			Code: 5,
+			Msg:  "系统错误",
+		})
+	}
+}
+
+func (u *UserHandler) LoginSMS(ctx *gin.Context) {
+	type Req struct {
+		Phone string `json:"phone"`
+		Code  string `json:"code"`
+	}
+	var req Req
+	if err := ctx.Bind(&req); err != nil {
+		return
+	}
+	ok, err := u.codeSvc.Verify(ctx, biz, req.Phone, req.Code)
+	if err == service.ErrCodeVerifyTooManyTimes {
+		ctx.JSON(http.StatusOK, Result{
+			Code: 4,
+			Msg:  "验证次数太多，请重新获取验证码",
+		})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusOK, Result{
+			Code: 5,
+			Msg:  "系统错误",
+		})
+		return
+	}
+	if !ok {
+		ctx.JSON(http.StatusOK, Result{
+			Code: 4,
+			Msg:  "验证码有误",
+		})
+		return
+	}
+	// 手机号第一次登录就直接注册
+	user, err := u.svc.FindOrCreate(ctx, req.Phone)
+	if err != nil {
+		ctx.JSON(http.StatusOK, Result{
+			Code: 5,
+			Msg:  "系统错误",
+		})
+		return
+	}
+	if err = u.setLogin(ctx, user.Id); err != nil {
+		ctx.JSON(http.StatusOK, Result{
+			Code: 5,
+			Msg:  "系统错误",
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, Result{
+		Code: 5,
+		Msg:  "验证码校验通过",
+	})
+}
+
+// ChangePassword 登录之后修改密码，要输入原密码
+// 修改成功之后别的设备都要重新登录，当前这个 session 继续有效，JWT 登录的换一个新的 token
+func (u *UserHandler) ChangePassword(ctx *gin.Context) {
+	type Req struct {
+		OldPassword     string `json:"oldPassword"`
+		Password        string `json:"password"`
+		ConfirmPassword string `json:"confirmPassword"`
+	}
+	var req Req
+	if err := ctx.Bind(&req); err != nil {
+		return
+	}
+	uid, ok := loginUid(ctx)
+	if !ok {
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	if req.ConfirmPassword != req.Password {
+		ctx.JSON(http.StatusOK, Result{Code: 4, Msg: "两次输入的密码不一致"})
+		return
+	}
+	if req.Password == req.OldPassword {
+		ctx.JSON(http.StatusOK, Result{Code: 4, Msg: "新密码不能和原密码一样"})
+		return
+	}
+
+	err := u.svc.ChangePassword(ctx, uid, req.OldPassword, req.Password)
+	if res, ok := passwordPolicyResult(err); ok {
+		ctx.JSON(http.StatusOK, res)
+		return
+	}
+	switch err {
+	case nil:
+	case service.ErrInvalidUserOrPassword:
+		ctx.JSON(http.StatusOK, Result{Code: 4, Msg: "原密码不对"})
+		return
+	case service.ErrInvalidUserNotFund:
+		ctx.JSON(http.StatusNotFound, Result{Code: 4, Msg: "没有查询到该用户"})
+		return
+	default:
+		ctx.JSON(http.StatusOK, Result{Code: 5, Msg: "系统错误"})
+		return
+	}
+	// 先撤销所有的 session，再把当前 session 的登录时间刷新成撤销之后，这样只有当前 session 还能用
+	if err = u.sessionSvc.Revoke(ctx, uid); err != nil {
+		ctx.JSON(http.StatusOK, Result{Code: 5, Msg: "系统错误"})
+		return
+	}
+	if u.jwtLogin {
+		// 签发时间只精确到秒，向上取整到撤销之后的下一秒，不然新的 token 也会被当成撤销之前签发的
+		claims := newUserClaims(ctx, uid)
+		claims.IssuedAt = jwt.NewNumericDate(time.Now().Truncate(time.Second).Add(time.Second))
+		err = u.setJWTToken(ctx, claims)
+	} else {
+		err = u.setLoginSession(ctx, uid)
+	}
+	if err != nil {
+		ctx.JSON(http.StatusOK, Result{Code: 5, Msg: "系统错误"})
+		return
+	}
+	ctx.JSON(http.StatusOK, Result{Msg: "密码修改成功，其他设备需要重新登录"})
+}
+
+// SendResetPasswordCode 发送找回密码的验证码，account 是邮箱或者手机号
+// 账号不存在也提示发送成功，不暴露哪些账号注册过
+func (u *UserHandler) SendResetPasswordCode(ctx *gin.Context) {
+	type Req struct {
+		Account string `json:"account"`
+	}
+	var req Req
+	if err := ctx.Bind(&req); err != nil {
+		return
+	}
+	if req.Account == "" {
+		ctx.JSON(http.StatusOK, Result{Code: 4, Msg: "请输入邮箱或者手机号码"})
+		return
+	}
+	err := u.passwordResetSvc.SendCode(ctx, req.Account)
+	switch err {
+	case nil:
+		ctx.JSON(http.StatusOK, Result{Msg: "发送成功"})
+	case service.ErrCodeSendTooMany:
+		ctx.JSON(http.StatusOK, Result{Code: 4, Msg: "验证码发送太频繁，请稍后再试"})
+	default:
+		ctx.JSON(http.StatusOK, Result{Code: 5, Msg: "系统错误"})
+	}
+}
+
+// ResetPassword 用验证码设置新密码，成功之后所有设备都要重新登录
+func (u *UserHandler) ResetPassword(ctx *gin.Context) {
+	type Req struct {
+		Account         string `json:"account"`
+		Code            string `json:"code"`
+		Password        string `json:"password"`
+		ConfirmPassword string `json:"confirmPassword"`
+	}
+	var req Req
+	if err := ctx.Bind(&req); err != nil {
+		return
+	}
+	if req.ConfirmPassword != req.Password {
+		ctx.JSON(http.StatusOK, Result{Code: 4, Msg: "两次输入的密码不一致"})
+		return
+	}
+	err := u.passwordResetSvc.Reset(ctx, req.Account, req.Code, req.Password)
+	if res, ok := passwordPolicyResult(err); ok {
+		ctx.JSON(http.StatusOK, res)
+		return
+	}
+	switch err {
+	case nil:
+		ctx.JSON(http.StatusOK, Result{Msg: "密码重置成功，请重新登录"})
+	case service.ErrInvalidResetCode, service.ErrInvalidUserNotFund:
+		ctx.JSON(http.StatusOK, Result{Code: 4, Msg: "验证码有误"})
+	case service.ErrCodeVerifyTooManyTimes:
+		ctx.JSON(http.StatusOK, Result{Code: 4, Msg: "验证次数太多，请重新获取验证码"})
+	default:
+		ctx.JSON(http.StatusOK, Result{Code: 5, Msg: "系统错误"})
+	}
 }
 
 func (u *UserHandler) LoginJWT(ctx *gin.Context) {
 	type LoginReq struct {
-		Email    string `json:email`
+		Email    string `json:"email"`
 		Password string `json:"password"`
 	}
 	var req LoginReq
@@ -159,16 +563,11 @@ func (u *UserHandler) LoginJWT(ctx *gin.Context) {
 	}
 	println(req.Email)
 	println(req.Password)
-	user, err := u.svc.Login(ctx, req.Email, req.Password)
-	fmt.Printf("%v", user)
-	if err == service.ErrInvalidUserOrPassword {
-		ctx.String(http.StatusOK, "用户名或者密码不对")
-		return
-	}
-	if err != nil {
-		ctx.String(http.StatusOK, "系统错误")
+	user, ok := u.passwordLogin(ctx, req.Email, req.Password)
+	if !ok {
 		return
 	}
+	fmt.Printf("%v", user)
 
 	//步骤2
 	//这里登录成功了，设置session
@@ -179,13 +578,10 @@ func (u *UserHandler) LoginJWT(ctx *gin.Context) {
 	//ctx.String(http.StatusOK, "登录成功")
 
 	//这里使用jwt
-	token := jwt.New(jwt.SigningMethodHS512)
-	tokenStr, err := token.SignedString([]byte("95osj3fUD7fo0mlYdDbncXz4VD2igvf0"))
-	if err != nil {
+	if err := u.setJWTToken(ctx, newUserClaims(ctx, user.Id)); err != nil {
 		ctx.String(http.StatusInternalServerError, "系统错误")
 		return
 	}
-	ctx.Header("x-jwt-token", tokenStr)
 	ctx.String(http.StatusOK, "登录成功")
 	//println(user)
 	//fmt.Printf(user)
@@ -212,16 +608,14 @@ func (cv *CustomValidator) ValidateStruct(obj interface{}) error {
 		for _, err := range err.(validator.ValidationErrors) {
 			errMsgs = append(errMsgs, fmt.Sprintf("Field %s: %s", err.Field(), err.Tag()))
 		}
-		return fmt.Errorf(strings.Join(errMsgs, "; "))
+		return errors.New(strings.Join(errMsgs, "; "))
 	}
 
 	return nil
 }
 
 func (u *UserHandler) Profile(ctx *gin.Context) {
-	sess := sessions.Default(ctx)
-	id := sess.Get("userId")
-	value, ok := id.(int64)
+	value, ok := loginUid(ctx)
 	if !ok {
 		//println(1111)
 		return
@@ -231,48 +625,339 @@ func (u *UserHandler) Profile(ctx *gin.Context) {
 		ctx.String(http.StatusOK, "没有查询到该用户")
 		return
 	}
-	//ctime = user.Ctime.Format("2006-01-02 15:04:05")
-	//utime = user.Utime.Format("2006-01-02 15:04:05")
-	//message := string[]{
-	//	Id:       str(user.Id),
-	//	Email:    user.Email,
-	//	Password: user.Password,
-	//
-	//	//添加如下字段，用户昵称，生日和个人简介
-	//	Nickname: user.Nickname,
-	//	Birthday: user.Birthday,
-	//	Abstract: user.Abstract,
-	//	Ctime:    ctime,
-	//	Utime:    utime,
-	//}
-	type UserReq struct {
-		Id    int64
-		Email string
-		//Password string
+	if err != nil {
+		ctx.String(http.StatusOK, "系统错误")
+		return
+	}
+	// 修改的时候把 ETag 放到 If-Match 里面，防止覆盖别人的修改
+	ctx.Header("ETag", profileETag(user.Version))
+	ctx.JSON(http.StatusOK, u.toProfileVo(ctx, user))
 
-		//添加如下字段，用户昵称，生日和个人简介
-		Nickname string
-		Birthday string
-		Abstract string
-		Ctime    string
-		Utime    string
+}
+
+// ProfileVo 返回给前端的个人信息
+type ProfileVo struct {
+	Id            int64
+	Email         string
+	EmailVerified bool
+	//Password string
+
+	//添加如下字段，用户昵称，生日和个人简介
+	Nickname string
+	Birthday string
+	Abstract string
+	Timezone string
+	// 头像原图的地址，没有上传过头像就是空的
+	Avatar string
+	// 缩略图的地址，key 是缩略图的边长
+	AvatarThumbnails map[int]string
+	Version          int64
+	Ctime            string
+	Utime            string
+}
+
+// toProfileVo 按照用户自己的时区展示，?time_format=rfc3339 输出带时区偏移的 RFC 3339 格式
+func (u *UserHandler) toProfileVo(ctx *gin.Context, user domain.User) ProfileVo {
+	loc := userLocation(user.Timezone)
+	layout := timeLayout(ctx.Query("time_format"))
+	var (
+		avatar     string
+		thumbnails map[int]string
+	)
+	if user.Avatar != "" && u.avatarSvc != nil {
+		avatar = u.avatarSvc.URL(user.Avatar, 0)
+		thumbnails = make(map[int]string, len(service.AvatarThumbnailSizes))
+		for _, size := range service.AvatarThumbnailSizes {
+			thumbnails[size] = u.avatarSvc.URL(user.Avatar, size)
+		}
+	}
+	return ProfileVo{
+		Id:               user.Id,
+		Email:            user.Email,
+		EmailVerified:    user.EmailVerified,
+		Nickname:         user.Nickname,
+		Birthday:         formatBirthday(user.Birthday),
+		Abstract:         user.Abstract,
+		Timezone:         loc.String(),
+		Avatar:           avatar,
+		AvatarThumbnails: thumbnails,
+		Version:          user.Version,
+		Ctime:            user.Ctime.In(loc).Format(layout),
+		Utime:            user.Utime.In(loc).Format(layout),
 	}
+}
 
+// PatchProfile 部分修改个人信息，只修改请求里面带了的字段。
+// 字段不传或者传 null 表示不修改，传空字符串表示清空。
+// 带了 If-Match 的话，版本号对不上返回 409
+func (u *UserHandler) PatchProfile(ctx *gin.Context) {
+	type PatchProfileReq struct {
+		Nickname *string `json:"nickname"`
+		Birthday *string `json:"birthday"`
+		Abstract *string `json:"abstract"`
+		Timezone *string `json:"timezone"`
+	}
+	var req PatchProfileReq
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusOK, Result{Code: 4, Msg: "参数错误"})
+		return
+	}
+	version, err := parseIfMatch(ctx.GetHeader("If-Match"))
 	if err != nil {
-		ctx.String(http.StatusOK, "系统错误")
+		ctx.JSON(http.StatusBadRequest, Result{Code: 4, Msg: err.Error()})
+		return
+	}
+	uid, ok := loginUid(ctx)
+	if !ok {
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	patch := domain.UserProfilePatch{
+		Nickname: req.Nickname,
+		Abstract: req.Abstract,
+		Timezone: req.Timezone,
+		Version:  version,
+	}
+	if req.Nickname != nil && !nicknameValid(*req.Nickname) {
+		ctx.JSON(http.StatusOK, Result{Code: 4, Msg: "昵称字符串长度小于10，英文字符和中文长度一样"})
+		return
+	}
+	if req.Birthday != nil {
+		// 空字符串清空生日，零值会存成 NULL
+		var birthday time.Time
+		if *req.Birthday != "" {
+			birthday, err = domain.ParseBirthday(*req.Birthday, time.Now())
+			if err != nil {
+				ctx.JSON(http.StatusOK, Result{Code: 4, Msg: "生日格式：YYYY-MM-DD，例如 1992-01-01，必须是真实的日期并且不能晚于今天"})
+				return
+			}
+		}
+		patch.Birthday = &birthday
+	}
+	if req.Abstract != nil && !abstractValid(*req.Abstract) {
+		ctx.JSON(http.StatusOK, Result{Code: 4, Msg: "验证个人简历长度小于500，英文字符和中文长度一样"})
 		return
 	}
-	userReq := UserReq{
-		Id:       user.Id,
-		Email:    user.Email,
-		Nickname: user.Nickname,
-		Birthday: user.Birthday,
-		Abstract: user.Abstract,
-		Ctime:    user.Ctime,
-		Utime:    user.Utime,
+	if req.Timezone != nil && *req.Timezone != "" {
+		if _, err := time.LoadLocation(*req.Timezone); err != nil {
+			ctx.JSON(http.StatusOK, Result{Code: 4, Msg: "时区格式：IANA 时区名，例如 Asia/Shanghai"})
+			return
+		}
 	}
-	ctx.JSON(http.StatusOK, userReq)
 
+	user, err := u.svc.UpdateProfile(ctx, uid, patch)
+	switch err {
+	case nil:
+	case service.ErrInvalidUserNotFund:
+		ctx.JSON(http.StatusNotFound, Result{Code: 4, Msg: "没有查询到该用户"})
+		return
+	case service.ErrProfileConflict:
+		ctx.JSON(http.StatusConflict, Result{Code: 4, Msg: "个人信息已经被修改过了，请刷新之后重试"})
+		return
+	default:
+		ctx.JSON(http.StatusOK, Result{Code: 5, Msg: "系统错误"})
+		return
+	}
+	ctx.Header("ETag", profileETag(user.Version))
+	ctx.JSON(http.StatusOK, Result{
+		Msg:  "修改个人信息成功",
+		Data: u.toProfileVo(ctx, user),
+	})
+}
+
+// UploadAvatar 上传头像，multipart/form-data，文件放在 avatar 字段里面
+// 返回修改之后的个人信息，里面有头像和缩略图的地址
+func (u *UserHandler) UploadAvatar(ctx *gin.Context) {
+	uid, ok := loginUid(ctx)
+	if !ok {
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	// 整个请求体也要限制大小，多留 1MB 给 multipart 的边界和别的字段
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, service.MaxAvatarSize+1<<20)
+	fh, err := ctx.FormFile("avatar")
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		ctx.JSON(http.StatusRequestEntityTooLarge, Result{Code: 4, Msg: "头像不能超过 5MB"})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusOK, Result{Code: 4, Msg: "请上传头像"})
+		return
+	}
+	if fh.Size > service.MaxAvatarSize {
+		ctx.JSON(http.StatusRequestEntityTooLarge, Result{Code: 4, Msg: "头像不能超过 5MB"})
+		return
+	}
+	f, err := fh.Open()
+	if err != nil {
+		ctx.JSON(http.StatusOK, Result{Code: 5, Msg: "系统错误"})
+		return
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, service.MaxAvatarSize+1))
+	if err != nil {
+		ctx.JSON(http.StatusOK, Result{Code: 5, Msg: "系统错误"})
+		return
+	}
+
+	user, err := u.avatarSvc.Upload(ctx, uid, data)
+	switch err {
+	case nil:
+	case service.ErrAvatarTooLarge:
+		ctx.JSON(http.StatusRequestEntityTooLarge, Result{Code: 4, Msg: "头像不能超过 5MB，宽高不能超过 4096 像素"})
+		return
+	case service.ErrAvatarUnsupportedType, service.ErrAvatarInvalidImage:
+		ctx.JSON(http.StatusOK, Result{Code: 4, Msg: err.Error()})
+		return
+	case service.ErrInvalidUserNotFund:
+		ctx.JSON(http.StatusNotFound, Result{Code: 4, Msg: "没有查询到该用户"})
+		return
+	default:
+		ctx.JSON(http.StatusOK, Result{Code: 5, Msg: "系统错误"})
+		return
+	}
+	ctx.Header("ETag", profileETag(user.Version))
+	ctx.JSON(http.StatusOK, Result{
+		Msg:  "上传头像成功",
+		Data: u.toProfileVo(ctx, user),
+	})
+}
+
+const (
+	// 修改记录默认一页多少条
+	defaultHistoryPageSize = 20
+	// 修改记录一页最多多少条
+	maxHistoryPageSize = 100
+)
+
+// ProfileChangeVo 个人信息的一条修改记录
+type ProfileChangeVo struct {
+	Field  string
+	Before string
+	After  string
+	// 谁改的，用户自己改就是用户自己的 id
+	Actor int64
+	Ctime string
+}
+
+// ProfileHistoryVo 一页修改记录
+type ProfileHistoryVo struct {
+	Total    int64
+	Page     int
+	PageSize int
+	Items    []ProfileChangeVo
+}
+
+// ProfileHistory 分页查询个人信息的修改记录，最新的在前面
+// ?page=1&page_size=20，page 从 1 开始，page_size 最多 100
+func (u *UserHandler) ProfileHistory(ctx *gin.Context) {
+	page, err := queryInt(ctx, "page", 1)
+	if err != nil || page < 1 {
+		ctx.JSON(http.StatusOK, Result{Code: 4, Msg: "分页参数不对"})
+		return
+	}
+	pageSize, err := queryInt(ctx, "page_size", defaultHistoryPageSize)
+	if err != nil || pageSize < 1 || pageSize > maxHistoryPageSize {
+		ctx.JSON(http.StatusOK, Result{Code: 4, Msg: "分页参数不对"})
+		return
+	}
+	uid, ok := loginUid(ctx)
+	if !ok {
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	// 修改时间要按照用户自己的时区展示
+	user, err := u.svc.Profile(ctx, uid)
+	if err == service.ErrInvalidUserNotFund {
+		ctx.JSON(http.StatusNotFound, Result{Code: 4, Msg: "没有查询到该用户"})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusOK, Result{Code: 5, Msg: "系统错误"})
+		return
+	}
+	changes, total, err := u.svc.ProfileHistory(ctx, uid, (page-1)*pageSize, pageSize)
+	if err != nil {
+		ctx.JSON(http.StatusOK, Result{Code: 5, Msg: "系统错误"})
+		return
+	}
+
+	loc := userLocation(user.Timezone)
+	layout := timeLayout(ctx.Query("time_format"))
+	items := make([]ProfileChangeVo, 0, len(changes))
+	for _, c := range changes {
+		items = append(items, ProfileChangeVo{
+			Field:  c.Field,
+			Before: c.Before,
+			After:  c.After,
+			Actor:  c.Actor,
+			Ctime:  c.Ctime.In(loc).Format(layout),
+		})
+	}
+	ctx.JSON(http.StatusOK, Result{
+		Data: ProfileHistoryVo{
+			Total:    total,
+			Page:     page,
+			PageSize: pageSize,
+			Items:    items,
+		},
+	})
+}
+
+// PasswordPolicyVo 密码没有通过的规则和强度评分
+type PasswordPolicyVo struct {
+	Violations []password.Violation
+	// 0 到 4，越大越强
+	Score int
+}
+
+// LoginLockedVo 登录被暂时锁定，还要等多少秒才能再试
+type LoginLockedVo struct {
+	RetryAfter int64
+}
+
+// passwordPolicyResult 密码没有通过密码策略的话，返回所有没有通过的规则
+func passwordPolicyResult(err error) (Result, bool) {
+	var policyErr *password.PolicyError
+	if !errors.As(err, &policyErr) {
+		return Result{}, false
+	}
+	return Result{
+		Code: 4,
+		Msg:  policyErr.Error(),
+		Data: PasswordPolicyVo{
+			Violations: policyErr.Violations,
+			Score:      policyErr.Score,
+		},
+	}, true
+}
+
+// queryInt 读取整数类型的查询参数，没有带就返回 def
+func queryInt(ctx *gin.Context, key string, def int) (int, error) {
+	val, ok := ctx.GetQuery(key)
+	if !ok {
+		return def, nil
+	}
+	return strconv.Atoi(val)
+}
+
+// nicknameValid 昵称字符串长度小于10，英文字符和中文长度一样
+func nicknameValid(nickname string) bool {
+	return utf8.RuneCountInString(nickname) < 10
+}
+
+// abstractValid 个人简介长度不超过500，英文字符和中文长度一样
+func abstractValid(abstract string) bool {
+	return utf8.RuneCountInString(abstract) <= 500
+}
+
+// sessionUid 从 session 里面取出登录用户的 id
+func sessionUid(ctx *gin.Context) (int64, bool) {
+	uid, ok := sessions.Default(ctx).Get("userId").(int64)
+	return uid, ok
 }
 
 func (u *UserHandler) Edit(ctx *gin.Context) {
@@ -280,6 +965,8 @@ func (u *UserHandler) Edit(ctx *gin.Context) {
 		Nickname string `json:"nickname" binding:"required,customNicknameValid"`
 		Birthday string `json:"birthday" binding:"required,customBirthdayValid"`
 		Abstract string `json:"abstract" binding:"required,customAbstractValid"`
+		// 可选，IANA 时区名，比如 America/New_York
+		Timezone string `json:"timezone" binding:"omitempty,timezone"`
 	}
 	// 使用自定义验证器
 	validatorInstance := validator.New()
@@ -289,26 +976,24 @@ func (u *UserHandler) Edit(ctx *gin.Context) {
 	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
 		_ = v.RegisterValidation("customNicknameValid", func(fl validator.FieldLevel) bool {
 			// 验证昵称长度：昵称字符串长度小于10，英文字符和中文长度一样
-			return utf8.RuneCountInString(fl.Field().String()) < 10
+			return nicknameValid(fl.Field().String())
 		})
 
 		_ = v.RegisterValidation("customBirthdayValid", func(fl validator.FieldLevel) bool {
-			// 验证生日格式：YYYY-MM-DD，例如 1992-01-01
-			birthdayPattern := regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`, 0)
-			flag, _ := birthdayPattern.MatchString(fl.Field().String())
-			return flag
+			// 验证生日格式：YYYY-MM-DD，例如 1992-01-01，并且是一个真实存在的、不晚于今天的日期
+			_, err := domain.ParseBirthday(fl.Field().String(), time.Now())
+			return err == nil
 		})
 
 		_ = v.RegisterValidation("customAbstractValid", func(fl validator.FieldLevel) bool {
 			// 验证个人简历：长度小于500，英文字符和中文长度一样
-			return utf8.RuneCountInString(fl.Field().String()) <= 500
+			return abstractValid(fl.Field().String())
 			//return len(fl.Field().String()) <= 500
 		})
 	}
 	ctx.Set("custom_validator", customValidator)
 
 	var req EditUserProfile
-	sess := sessions.Default(ctx)
 	//if err := ctx.Bind(&req); err != nil {
 	//	//println(1111)
 	//	return
@@ -323,9 +1008,11 @@ func (u *UserHandler) Edit(ctx *gin.Context) {
 				case "customNicknameValid":
 					errMsg = "昵称字符串长度小于10，英文字符和中文长度一样"
 				case "customBirthdayValid":
-					errMsg = "生日格式：YYYY-MM-DD，例如 1992-01-01"
+					errMsg = "生日格式：YYYY-MM-DD，例如 1992-01-01，必须是真实的日期并且不能晚于今天"
 				case "customAbstractValid":
 					errMsg = "验证个人简历长度小于500，英文字符和中文长度一样"
+				case "timezone":
+					errMsg = "时区格式：IANA 时区名，例如 Asia/Shanghai"
 				}
 			}
 		}
@@ -335,9 +1022,7 @@ func (u *UserHandler) Edit(ctx *gin.Context) {
 		return
 	}
 	//println(1111)
-	id := sess.Get("userId")
-	println(id)
-	value, ok := id.(int64)
+	value, ok := loginUid(ctx)
 	if !ok {
 		println(1111)
 		return
@@ -350,14 +1035,34 @@ func (u *UserHandler) Edit(ctx *gin.Context) {
 	//ok, err := u.emailExp.MatchString(req.Email)
 	//todo,校验
 
+	// 带了 If-Match 就检查版本号，防止覆盖别人的修改
+	version, err := parseIfMatch(ctx.GetHeader("If-Match"))
+	if err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// 上面已经校验过了，这里不会出错
+	birthday, _ := domain.ParseBirthday(req.Birthday, time.Now())
+
 	//调用一下svc的方法
-	err := u.svc.EditUserProfile(ctx, value, domain.User{
+	err = u.svc.EditUserProfile(ctx, value, domain.User{
 		Nickname: req.Nickname,
-		Birthday: req.Birthday,
+		Birthday: birthday,
 		Abstract: req.Abstract,
+		Timezone: req.Timezone,
+		Version:  version,
 	})
 
-	if err != nil {
+	switch err {
+	case nil:
+	case service.ErrInvalidUserNotFund:
+		ctx.String(http.StatusNotFound, "没有查询到该用户")
+		return
+	case service.ErrProfileConflict:
+		ctx.String(http.StatusConflict, "个人信息已经被修改过了，请刷新之后重试")
+		return
+	default:
 		ctx.String(http.StatusOK, "系统异常")
 		return
 	}
@@ -0,0 +1,152 @@
+package cache
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// newCodeCacheFunc 创建一个待测的 CodeCache，同时返回一个拨动时间的方法
+type newCodeCacheFunc func(t *testing.T) (CodeCache, func(d time.Duration))
+
+func TestCodeMemoryCache(t *testing.T) {
+	testCodeCache(t, func(t *testing.T) (CodeCache, func(d time.Duration)) {
+		clock := &fakeClock{now: time.UnixMilli(1000000)}
+		c := NewCodeMemoryCache(WithCodeClock(clock.Now), WithCodeCleanInterval(0))
+		return c, clock.Add
+	})
+}
+
+func TestCodeRedisCache(t *testing.T) {
+	testCodeCache(t, func(t *testing.T) (CodeCache, func(d time.Duration)) {
+		mr := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{
+			Addr: mr.Addr(),
+		})
+		t.Cleanup(func() {
+			_ = client.Close()
+		})
+		return NewCodeRedisCache(client), mr.FastForward
+	})
+}
+
+// testCodeCache 所有 CodeCache 的实现都必须通过的测试
+func testCodeCache(t *testing.T, newCache newCodeCacheFunc) {
+	t.Run("Set", func(t *testing.T) {
+		testCodeCacheSet(t, newCache)
+	})
+	t.Run("Verify", func(t *testing.T) {
+		testCodeCacheVerify(t, newCache)
+	})
+}
+
+func testCodeCacheSet(t *testing.T, newCache newCodeCacheFunc) {
+	testCases := []struct {
+		name string
+		// 第一次发送之后过了多久再发
+		after time.Duration
+		// 第二次发送的是不是另外一个业务
+		biz     string
+		wantErr error
+	}{
+		{
+			name:    "一分钟内重复发送",
+			after:   30 * time.Second,
+			biz:     "login",
+			wantErr: ErrCodeSendTooMany,
+		},
+		{
+			name:  "超过一分钟可以重新发送",
+			after: time.Minute,
+			biz:   "login",
+		},
+		{
+			name:  "过期之后可以重新发送",
+			after: 11 * time.Minute,
+			biz:   "login",
+		},
+		{
+			name:  "不同业务互不影响",
+			after: time.Second,
+			biz:   "reset_password",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, advance := newCache(t)
+			err := c.Set(context.Background(), "login", "15212345678", "123456")
+			require.NoError(t, err)
+			advance(tc.after)
+			err = c.Set(context.Background(), tc.biz, "15212345678", "654321")
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
+
+func testCodeCacheVerify(t *testing.T, newCache newCodeCacheFunc) {
+	testCases := []struct {
+		name string
+		// 依次输入的验证码
+		inputs  []string
+		after   time.Duration
+		wantOk  bool
+		wantErr error
+	}{
+		{
+			name:   "验证成功",
+			inputs: []string{"123456"},
+			wantOk: true,
+		},
+		{
+			name:   "验证码不对",
+			inputs: []string{"000000"},
+		},
+		{
+			name:   "输错两次之后输对",
+			inputs: []string{"000000", "000000", "123456"},
+			wantOk: true,
+		},
+		{
+			name:    "输错三次之后就不能再验证了",
+			inputs:  []string{"000000", "000000", "000000", "123456"},
+			wantErr: ErrCodeVerifyTooManyTimes,
+		},
+		{
+			name:    "验证成功之后不能重复使用",
+			inputs:  []string{"123456", "123456"},
+			wantErr: ErrCodeVerifyTooManyTimes,
+		},
+		{
+			name:   "验证码过期",
+			inputs: []string{"123456"},
+			after:  10 * time.Minute,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, advance := newCache(t)
+			err := c.Set(context.Background(), "login", "15212345678", "123456")
+			require.NoError(t, err)
+			advance(tc.after)
+			var ok bool
+			for _, input := range tc.inputs {
+				ok, err = c.Verify(context.Background(), "login", "15212345678", input)
+			}
+			assert.Equal(t, tc.wantOk, ok)
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+
+	t.Run("没有发送过验证码", func(t *testing.T) {
+		c, _ := newCache(t)
+		ok, err := c.Verify(context.Background(), "login", "15212345678", "123456")
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+}
@@ -1,80 +1,437 @@
 package cache
 
 import (
+	"container/list"
 	"context"
-	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
-// 定义错误变量，用于表示不同的错误情况
-var (
-	LocallErrCodeSendTooMany       = errors.New("发送验证码太频繁")
-	LocalErrCodeVerifyTooManyTimes = errors.New("验证次数太多")
-	LocalErrUnknowForCode          = errors.New("我也不知道发生什么，反正是和code有关")
+const (
+	// 后台清理过期验证码的周期
+	defaultCodeCleanInterval = time.Minute
+	// 每个业务默认最多缓存多少个验证码
+	defaultCodeMaxEntries = 100000
+	// 默认分片数
+	defaultCodeShards = 32
 )
 
-// CodeCache 接口定义了缓存操作的方法
-type CodeCache interface {
-	Set(ctx context.Context, biz, phone, code string) error
-	Verify(ctx context.Context, biz, phone, inputCode string) (bool, error)
+// codeItem 缓存里面的一条验证码记录
+type codeItem struct {
+	key  string
+	code string
+	// 剩余可验证次数，验证成功之后置为 0，防止同一个验证码被重复使用
+	cnt int
+	// 过期时间
+	expireAt time.Time
+	// 最近一次使用的序号，所有分片共用一个递增的序号，越小越久没用过
+	used uint64
 }
 
-// CodeMemoryCache 是 CodeCache 接口的实现，基于本地内存缓存
+// codeBizLimit 一个业务在所有分片上一共缓存了多少个验证码
+type codeBizLimit struct {
+	// 最多缓存多少个验证码，小于等于 0 表示不限制
+	capacity int64
+	size     atomic.Int64
+	// 超过容量之后同一个业务同时只有一个 goroutine 在淘汰，不会多淘汰
+	evictMu sync.Mutex
+}
+
+// codeBucket 一个分片里面一个业务的验证码，按照最近使用的顺序排好
+// 每个业务单独限制大小，这样别的业务刷验证码不会把登录的验证码挤出去
+type codeBucket struct {
+	items map[string]*list.Element // key 到链表节点，节点的值是 *codeItem
+	lru   *list.List               // 最近用过的在前面
+	limit *codeBizLimit
+	// 所有分片共用的使用序号，移到链表头的时候更新，链表里面的序号从前往后是递减的
+	seq *atomic.Uint64
+}
+
+func newCodeBucket(limit *codeBizLimit, seq *atomic.Uint64) *codeBucket {
+	return &codeBucket{
+		items: make(map[string]*list.Element),
+		lru:   list.New(),
+		limit: limit,
+		seq:   seq,
+	}
+}
+
+func (b *codeBucket) get(key string) (*codeItem, bool) {
+	elem, ok := b.items[key]
+	if !ok {
+		return nil, false
+	}
+	b.lru.MoveToFront(elem)
+	item := elem.Value.(*codeItem)
+	item.used = b.seq.Add(1)
+	return item, true
+}
+
+// put 放入验证码，返回是不是新增的 key
+func (b *codeBucket) put(item *codeItem) bool {
+	item.used = b.seq.Add(1)
+	if elem, ok := b.items[item.key]; ok {
+		elem.Value = item
+		b.lru.MoveToFront(elem)
+		return false
+	}
+	b.items[item.key] = b.lru.PushFront(item)
+	b.limit.size.Add(1)
+	return true
+}
+
+func (b *codeBucket) remove(elem *list.Element) {
+	b.lru.Remove(elem)
+	delete(b.items, elem.Value.(*codeItem).key)
+	b.limit.size.Add(-1)
+}
+
+// CodeCacheStats 本地验证码缓存的统计数据
+type CodeCacheStats struct {
+	// Verify 的时候找到了没过期的验证码
+	Hits int64
+	// Verify 的时候没有验证码，或者已经过期
+	Misses int64
+	// 因为超过容量被淘汰的验证码个数
+	Evictions int64
+}
+
+// codeShard 一个分片，有自己的锁，不同分片之间互不影响
+type codeShard struct {
+	mu      sync.Mutex             // 用于并发安全的互斥锁
+	buckets map[string]*codeBucket // 按照 biz 分开存储验证码
+	stats   CodeCacheStats
+}
+
+// CodeMemoryCache 是 CodeCache 接口的实现，基于本地内存缓存，规则和 CodeRedisCache 保持一致
+// 每个业务的验证码个数有上限，超过了按照 LRU 淘汰，防止有人用随机手机号把内存刷爆。
+// 被淘汰的手机号可以马上重新发送验证码，发送频率要靠限流兜底。
+//
+// 验证码按照 key(biz, phone) 的哈希分散到多个分片上，每个分片一把锁，
+// 登录高峰的时候不同手机号基本不会抢同一把锁。
+// 容量限制是整个业务的，不是每个分片的：超过容量之后比较每个分片里面最久没用过的验证码，
+// 淘汰其中最久没用过的那个，所以 LRU 在所有分片之间也是精确的
 type CodeMemoryCache struct {
-	cache map[string]string // 使用 map 存储验证码
-	mu    sync.Mutex        // 用于并发安全的互斥锁
+	shards []*codeShard
+	// 业务到 *codeBizLimit
+	limits sync.Map
+	// 所有分片共用的使用序号
+	seq atomic.Uint64
+
+	expiration    time.Duration
+	sendInterval  time.Duration
+	verifyCnt     int
+	cleanInterval time.Duration
+	maxEntries    int
+	bizMaxEntries map[string]int
+	shardCnt      int
+	// now 获取当前时间，测试的时候可以替换掉，不用真的等时间过去
+	now func() time.Time
+
+	closeOnce sync.Once
+	closeCh   chan struct{}
+}
+
+// CodeMemoryCacheOption 用于修改 CodeMemoryCache 的默认配置
+type CodeMemoryCacheOption func(c *CodeMemoryCache)
+
+// WithCodeExpiration 设置验证码有效期
+func WithCodeExpiration(expiration time.Duration) CodeMemoryCacheOption {
+	return func(c *CodeMemoryCache) {
+		c.expiration = expiration
+	}
+}
+
+// WithCodeSendInterval 设置两次发送之间的最小间隔
+func WithCodeSendInterval(interval time.Duration) CodeMemoryCacheOption {
+	return func(c *CodeMemoryCache) {
+		c.sendInterval = interval
+	}
+}
+
+// WithCodeVerifyCnt 设置一个验证码最多验证几次
+func WithCodeVerifyCnt(cnt int) CodeMemoryCacheOption {
+	return func(c *CodeMemoryCache) {
+		c.verifyCnt = cnt
+	}
+}
+
+// WithCodeCleanInterval 设置后台清理过期验证码的周期，小于等于 0 表示不启动后台清理
+func WithCodeCleanInterval(interval time.Duration) CodeMemoryCacheOption {
+	return func(c *CodeMemoryCache) {
+		c.cleanInterval = interval
+	}
+}
+
+// WithCodeMaxEntries 设置每个业务默认最多缓存多少个验证码，小于等于 0 表示不限制
+func WithCodeMaxEntries(n int) CodeMemoryCacheOption {
+	return func(c *CodeMemoryCache) {
+		c.maxEntries = n
+	}
+}
+
+// WithCodeBizMaxEntries 单独设置某个业务最多缓存多少个验证码
+func WithCodeBizMaxEntries(biz string, n int) CodeMemoryCacheOption {
+	return func(c *CodeMemoryCache) {
+		c.bizMaxEntries[biz] = n
+	}
+}
+
+// WithCodeShards 设置分片数，1 就是所有验证码共用一把锁
+func WithCodeShards(n int) CodeMemoryCacheOption {
+	return func(c *CodeMemoryCache) {
+		c.shardCnt = n
+	}
+}
+
+// WithCodeClock 替换获取当前时间的方法，主要是测试用
+func WithCodeClock(now func() time.Time) CodeMemoryCacheOption {
+	return func(c *CodeMemoryCache) {
+		c.now = now
+	}
 }
 
 // NewCodeMemoryCache 创建一个新的 CodeMemoryCache 实例
-func NewCodeMemoryCache() *CodeMemoryCache {
-	return &CodeMemoryCache{
-		cache: make(map[string]string), // 初始化存储验证码的 map
+// 会启动一个后台 goroutine 定期清理过期的验证码，不用的时候调用 Close 停掉它
+func NewCodeMemoryCache(opts ...CodeMemoryCacheOption) *CodeMemoryCache {
+	c := &CodeMemoryCache{
+		expiration:    defaultCodeExpiration,
+		sendInterval:  defaultCodeSendInterval,
+		verifyCnt:     defaultCodeVerifyCnt,
+		cleanInterval: defaultCodeCleanInterval,
+		maxEntries:    defaultCodeMaxEntries,
+		bizMaxEntries: make(map[string]int),
+		shardCnt:      defaultCodeShards,
+		now:           time.Now,
+		closeCh:       make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.shardCnt <= 0 {
+		c.shardCnt = 1
 	}
+	c.shards = make([]*codeShard, c.shardCnt)
+	for i := range c.shards {
+		c.shards[i] = &codeShard{
+			buckets: make(map[string]*codeBucket), // 初始化存储验证码的 map
+		}
+	}
+	if c.cleanInterval > 0 {
+		go c.janitor()
+	}
+	return c
 }
 
 // Set 方法用于将验证码存入缓存
 func (c *CodeMemoryCache) Set(ctx context.Context, biz, phone, code string) error {
-	c.mu.Lock()         // 锁定互斥锁，保证并发安全
-	defer c.mu.Unlock() // 在函数结束后解锁
+	added, err := c.set(biz, phone, code)
+	if err != nil {
+		return err
+	}
+	// 放进去之后再淘汰，淘汰的时候要看所有分片，不能拿着这个分片的锁
+	if added {
+		c.evict(biz)
+	}
+	return nil
+}
 
+func (c *CodeMemoryCache) set(biz, phone, code string) (bool, error) {
 	key := c.key(biz, phone) // 生成用于存储的键
+	shard := c.shard(key)
+	shard.mu.Lock()         // 锁定分片的互斥锁，保证并发安全
+	defer shard.mu.Unlock() // 在函数结束后解锁
 
-	// 如果已经存在相同的键（即相同的业务和手机号），返回发送验证码太频繁的错误
-	if _, exists := c.cache[key]; exists {
-		return ErrCodeSendTooMany
+	now := c.now()
+	bucket := c.bucket(shard, biz)
+
+	// 上一个验证码还没过期，并且发送时间距离现在不到一分钟，返回发送验证码太频繁的错误
+	if item, exists := bucket.get(key); exists && now.Before(item.expireAt) {
+		sendAt := item.expireAt.Add(-c.expiration)
+		if now.Sub(sendAt) < c.sendInterval {
+			return false, ErrCodeSendTooMany
+		}
 	}
 
-	// 否则，将验证码存入缓存
-	c.cache[key] = code
-	return nil
+	// 否则，将验证码存入缓存，覆盖掉旧的验证码
+	return bucket.put(&codeItem{
+		key:      key,
+		code:     code,
+		cnt:      c.verifyCnt,
+		expireAt: now.Add(c.expiration),
+	}), nil
+}
+
+// evict 业务的验证码超过容量了就淘汰最久没用过的，直到不超过容量
+func (c *CodeMemoryCache) evict(biz string) {
+	limit := c.limit(biz)
+	if limit.capacity <= 0 || limit.size.Load() <= limit.capacity {
+		return
+	}
+	limit.evictMu.Lock()
+	defer limit.evictMu.Unlock()
+	for limit.size.Load() > limit.capacity {
+		if !c.evictOldest(biz) {
+			return
+		}
+	}
+}
+
+// evictOldest 每个分片最久没用过的验证码在链表末尾，挑出其中最久没用过的淘汰掉
+// 一次只拿一个分片的锁，挑选和淘汰之间这个分片的末尾可能变了，淘汰的是它当时的末尾
+func (c *CodeMemoryCache) evictOldest(biz string) bool {
+	var victim *codeShard
+	var oldest uint64
+	for _, shard := range c.shards {
+		shard.mu.Lock()
+		if b, ok := shard.buckets[biz]; ok && b.lru.Len() > 0 {
+			used := b.lru.Back().Value.(*codeItem).used
+			if victim == nil || used < oldest {
+				victim, oldest = shard, used
+			}
+		}
+		shard.mu.Unlock()
+	}
+	if victim == nil {
+		return false
+	}
+	victim.mu.Lock()
+	defer victim.mu.Unlock()
+	b := victim.buckets[biz]
+	if b.lru.Len() > 0 {
+		b.remove(b.lru.Back())
+		victim.stats.Evictions++
+	}
+	return true
 }
 
 // Verify 方法用于验证输入的验证码是否正确
 func (c *CodeMemoryCache) Verify(ctx context.Context, biz, phone, inputCode string) (bool, error) {
-	c.mu.Lock()         // 锁定互斥锁，保证并发安全
-	defer c.mu.Unlock() // 在函数结束后解锁
-
 	key := c.key(biz, phone) // 生成用于存储的键
+	shard := c.shard(key)
+	shard.mu.Lock()         // 锁定分片的互斥锁，保证并发安全
+	defer shard.mu.Unlock() // 在函数结束后解锁
 
 	// 获取存储的验证码
-	storedCode, exists := c.cache[key]
+	item, exists := c.bucket(shard, biz).get(key)
+
+	// 没有发送过验证码，或者验证码已经过期，都当作验证码不对
+	if !exists || !c.now().Before(item.expireAt) {
+		shard.stats.Misses++
+		return false, nil
+	}
+	shard.stats.Hits++
 
-	// 如果不存在相同的键（即没有发送验证码记录），返回验证次数太多的错误
-	if !exists {
+	// 次数用完了，或者已经验证成功过，返回验证次数太多的错误
+	if item.cnt <= 0 {
 		return false, ErrCodeVerifyTooManyTimes
 	}
 
-	// 如果输入的验证码与存储的验证码匹配，删除缓存中的验证码并返回验证成功
-	if storedCode == inputCode {
-		delete(c.cache, key) // 从缓存中删除验证码
+	// 如果输入的验证码与存储的验证码匹配，作废这个验证码并返回验证成功
+	// 这里不删除记录，是为了在过期之前依旧限制发送频率
+	if item.code == inputCode {
+		item.cnt = 0
 		return true, nil
 	}
 
-	// 否则，返回验证失败
+	// 否则，扣掉一次验证次数，返回验证失败
+	item.cnt--
 	return false, nil
 }
 
+// Stats 返回目前为止的统计数据
+func (c *CodeMemoryCache) Stats() CodeCacheStats {
+	var res CodeCacheStats
+	for _, shard := range c.shards {
+		shard.mu.Lock()
+		res.Hits += shard.stats.Hits
+		res.Misses += shard.stats.Misses
+		res.Evictions += shard.stats.Evictions
+		shard.mu.Unlock()
+	}
+	return res
+}
+
+// shard 根据 key 的 FNV-1a 哈希选择分片
+func (c *CodeMemoryCache) shard(key string) *codeShard {
+	const (
+		offset32 = 2166136261
+		prime32  = 16777619
+	)
+	var h uint32 = offset32
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= prime32
+	}
+	return c.shards[h%uint32(len(c.shards))]
+}
+
+// bucket 获取分片里面业务对应的 codeBucket，没有就创建一个，调用方要持有分片的锁
+func (c *CodeMemoryCache) bucket(shard *codeShard, biz string) *codeBucket {
+	b, ok := shard.buckets[biz]
+	if !ok {
+		b = newCodeBucket(c.limit(biz), &c.seq)
+		shard.buckets[biz] = b
+	}
+	return b
+}
+
+// limit 获取业务的容量限制，所有分片共用一个
+func (c *CodeMemoryCache) limit(biz string) *codeBizLimit {
+	if l, ok := c.limits.Load(biz); ok {
+		return l.(*codeBizLimit)
+	}
+	capacity, ok := c.bizMaxEntries[biz]
+	if !ok {
+		capacity = c.maxEntries
+	}
+	l, _ := c.limits.LoadOrStore(biz, &codeBizLimit{capacity: int64(capacity)})
+	return l.(*codeBizLimit)
+}
+
+// Close 停止后台清理，可以重复调用
+func (c *CodeMemoryCache) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closeCh)
+	})
+	return nil
+}
+
+// janitor 定期清理过期的验证码，避免 map 一直增长
+func (c *CodeMemoryCache) janitor() {
+	ticker := time.NewTicker(c.cleanInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.deleteExpired()
+		case <-c.closeCh:
+			return
+		}
+	}
+}
+
+// deleteExpired 删除所有已经过期的验证码
+func (c *CodeMemoryCache) deleteExpired() {
+	now := c.now()
+	for _, shard := range c.shards {
+		// 一个分片一个分片地清理，不会长时间阻塞所有请求
+		shard.mu.Lock()
+		for _, bucket := range shard.buckets {
+			for elem := bucket.lru.Front(); elem != nil; {
+				next := elem.Next()
+				if !now.Before(elem.Value.(*codeItem).expireAt) {
+					bucket.remove(elem)
+				}
+				elem = next
+			}
+		}
+		shard.mu.Unlock()
+	}
+}
+
 // key 方法用于生成存储的键
 func (c *CodeMemoryCache) key(biz, phone string) string {
 	return fmt.Sprintf("phone_code:%s:%s", biz, phone)
@@ -0,0 +1,253 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClock 手动拨动的时钟
+type fakeClock struct {
+	now time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	return f.now
+}
+
+func (f *fakeClock) Add(d time.Duration) {
+	f.now = f.now.Add(d)
+}
+
+func TestCodeMemoryCache_deleteExpired(t *testing.T) {
+	clock := &fakeClock{now: time.UnixMilli(1000000)}
+	c := NewCodeMemoryCache(WithCodeClock(clock.Now), WithCodeCleanInterval(0))
+	assert.NoError(t, c.Set(context.Background(), "login", "15212345678", "123456"))
+	clock.Add(5 * time.Minute)
+	assert.NoError(t, c.Set(context.Background(), "login", "15212345679", "123456"))
+
+	clock.Add(6 * time.Minute)
+	c.deleteExpired()
+	key1, key2 := c.key("login", "15212345678"), c.key("login", "15212345679")
+	_, ok := c.shard(key1).buckets["login"].items[key1]
+	assert.False(t, ok)
+	_, ok = c.shard(key2).buckets["login"].items[key2]
+	assert.True(t, ok)
+}
+
+func TestCodeMemoryCache_LRU(t *testing.T) {
+	c := NewCodeMemoryCache(WithCodeCleanInterval(0),
+		WithCodeMaxEntries(2),
+		WithCodeBizMaxEntries("login", 3))
+	ctx := context.Background()
+
+	// register 业务只能存两个，最久没用过的 15200000001 被淘汰
+	assert.NoError(t, c.Set(ctx, "register", "15200000001", "123456"))
+	assert.NoError(t, c.Set(ctx, "register", "15200000002", "123456"))
+	assert.NoError(t, c.Set(ctx, "register", "15200000003", "123456"))
+	// login 业务可以存三个，不受 register 的影响
+	assert.NoError(t, c.Set(ctx, "login", "15200000001", "123456"))
+	assert.NoError(t, c.Set(ctx, "login", "15200000002", "123456"))
+	// 访问一下 15200000001，淘汰的就变成 15200000002
+	ok, err := c.Verify(ctx, "login", "15200000001", "000000")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.NoError(t, c.Set(ctx, "login", "15200000003", "123456"))
+	assert.NoError(t, c.Set(ctx, "login", "15200000004", "123456"))
+
+	ok, err = c.Verify(ctx, "register", "15200000001", "123456")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = c.Verify(ctx, "register", "15200000003", "123456")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = c.Verify(ctx, "login", "15200000002", "123456")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = c.Verify(ctx, "login", "15200000001", "123456")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	assert.Equal(t, CodeCacheStats{
+		Hits:      3,
+		Misses:    2,
+		Evictions: 2,
+	}, c.Stats())
+}
+
+// size 所有分片上一共缓存了多少个 biz 的验证码
+func (c *CodeMemoryCache) size(biz string) int {
+	res := 0
+	for _, shard := range c.shards {
+		shard.mu.Lock()
+		if b, ok := shard.buckets[biz]; ok {
+			res += len(b.items)
+		}
+		shard.mu.Unlock()
+	}
+	return res
+}
+
+func TestCodeMemoryCache_BizMaxEntries(t *testing.T) {
+	// 默认的分片数，容量限制的是整个业务，不是每个分片
+	c := NewCodeMemoryCache(WithCodeCleanInterval(0), WithCodeBizMaxEntries("login", 10))
+	ctx := context.Background()
+	for i := 0; i < 1000; i++ {
+		assert.NoError(t, c.Set(ctx, "login", strconv.Itoa(15200000000+i), "123456"))
+	}
+	assert.Equal(t, 10, c.size("login"))
+	assert.Equal(t, int64(990), c.Stats().Evictions)
+	// 留下来的是最近发送的 10 个
+	for i := 990; i < 1000; i++ {
+		ok, err := c.Verify(ctx, "login", strconv.Itoa(15200000000+i), "123456")
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	}
+}
+
+func TestCodeMemoryCache_BizMaxEntriesConcurrent(t *testing.T) {
+	c := NewCodeMemoryCache(WithCodeCleanInterval(0), WithCodeBizMaxEntries("login", 50))
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				phone := strconv.Itoa(15200000000 + g*1000 + i)
+				assert.NoError(t, c.Set(ctx, "login", phone, "123456"))
+				_, _ = c.Verify(ctx, "login", phone, "654321")
+			}
+		}(g)
+	}
+	wg.Wait()
+	assert.Equal(t, 50, c.size("login"))
+	assert.Equal(t, int64(16*500-50), c.Stats().Evictions)
+}
+
+// singleMutexCodeCache 分片之前的实现：一把锁，每个业务一个 LRU，只用来做基准测试的对照
+type singleMutexCodeCache struct {
+	mu         sync.Mutex
+	buckets    map[string]map[string]*list.Element
+	lrus       map[string]*list.List
+	maxEntries int
+}
+
+func newSingleMutexCodeCache(maxEntries int) *singleMutexCodeCache {
+	return &singleMutexCodeCache{
+		buckets:    make(map[string]map[string]*list.Element),
+		lrus:       make(map[string]*list.List),
+		maxEntries: maxEntries,
+	}
+}
+
+func (c *singleMutexCodeCache) bucket(biz string) (map[string]*list.Element, *list.List) {
+	items, ok := c.buckets[biz]
+	if !ok {
+		items = make(map[string]*list.Element)
+		c.buckets[biz] = items
+		c.lrus[biz] = list.New()
+	}
+	return items, c.lrus[biz]
+}
+
+func (c *singleMutexCodeCache) Set(ctx context.Context, biz, phone, code string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := fmt.Sprintf("phone_code:%s:%s", biz, phone)
+	now := time.Now()
+	items, lru := c.bucket(biz)
+	if elem, ok := items[key]; ok {
+		lru.MoveToFront(elem)
+		item := elem.Value.(*codeItem)
+		if now.Before(item.expireAt) && now.Sub(item.expireAt.Add(-defaultCodeExpiration)) < defaultCodeSendInterval {
+			return ErrCodeSendTooMany
+		}
+		elem.Value = &codeItem{key: key, code: code, cnt: defaultCodeVerifyCnt, expireAt: now.Add(defaultCodeExpiration)}
+		return nil
+	}
+	items[key] = lru.PushFront(&codeItem{key: key, code: code, cnt: defaultCodeVerifyCnt,
+		expireAt: now.Add(defaultCodeExpiration)})
+	for lru.Len() > c.maxEntries {
+		back := lru.Back()
+		lru.Remove(back)
+		delete(items, back.Value.(*codeItem).key)
+	}
+	return nil
+}
+
+func (c *singleMutexCodeCache) Verify(ctx context.Context, biz, phone, inputCode string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := fmt.Sprintf("phone_code:%s:%s", biz, phone)
+	items, lru := c.bucket(biz)
+	elem, ok := items[key]
+	if !ok {
+		return false, nil
+	}
+	lru.MoveToFront(elem)
+	item := elem.Value.(*codeItem)
+	if !time.Now().Before(item.expireAt) {
+		return false, nil
+	}
+	if item.cnt <= 0 {
+		return false, ErrCodeVerifyTooManyTimes
+	}
+	if item.code == inputCode {
+		item.cnt = 0
+		return true, nil
+	}
+	item.cnt--
+	return false, nil
+}
+
+// BenchmarkCodeMemoryCache 对比分片之前的单锁实现和现在的分片实现在不同并发度下的吞吐
+// go test -bench=CodeMemoryCache -benchmem
+func BenchmarkCodeMemoryCache(b *testing.B) {
+	impls := []struct {
+		name string
+		new  func() CodeCache
+	}{
+		{
+			name: "single-mutex",
+			new: func() CodeCache {
+				return newSingleMutexCodeCache(defaultCodeMaxEntries)
+			},
+		},
+		{
+			name: "sharded",
+			new: func() CodeCache {
+				return NewCodeMemoryCache(WithCodeCleanInterval(0))
+			},
+		},
+	}
+	for _, impl := range impls {
+		for _, parallelism := range []int{1, 4, 16, 64} {
+			b.Run(fmt.Sprintf("%s/parallelism=%d", impl.name, parallelism), func(b *testing.B) {
+				c := impl.new()
+				ctx := context.Background()
+				var cnt atomic.Int64
+				b.SetParallelism(parallelism)
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						i := cnt.Add(1)
+						phone := strconv.FormatInt(15200000000+i%100000, 10)
+						// 模拟登录高峰，一次发送对应几次验证
+						if i%4 == 0 {
+							_ = c.Set(ctx, "login", phone, "123456")
+						} else {
+							_, _ = c.Verify(ctx, "login", phone, "654321")
+						}
+					}
+				})
+			})
+		}
+	}
+}
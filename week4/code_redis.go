@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// 编译器会在编译的时候，把 lua 脚本的内容放进这两个变量里
+var (
+	//go:embed lua/set_code.lua
+	luaSetCode string
+	//go:embed lua/verify_code.lua
+	luaVerifyCode string
+)
+
+// CodeRedisCache 是 CodeCache 接口基于 Redis 的实现
+// 检查发送间隔、设置过期时间、扣减验证次数都放在 lua 脚本里面，保证原子性，多实例部署也没问题
+type CodeRedisCache struct {
+	client       redis.Cmdable
+	expiration   time.Duration
+	sendInterval time.Duration
+	verifyCnt    int
+}
+
+// NewCodeRedisCache 创建一个新的 CodeRedisCache 实例
+func NewCodeRedisCache(client redis.Cmdable) *CodeRedisCache {
+	return &CodeRedisCache{
+		client:       client,
+		expiration:   defaultCodeExpiration,
+		sendInterval: defaultCodeSendInterval,
+		verifyCnt:    defaultCodeVerifyCnt,
+	}
+}
+
+// Set 方法用于将验证码存入缓存
+func (c *CodeRedisCache) Set(ctx context.Context, biz, phone, code string) error {
+	res, err := c.client.Eval(ctx, luaSetCode, []string{c.key(biz, phone)}, code,
+		c.expiration.Milliseconds(),
+		(c.expiration - c.sendInterval).Milliseconds(),
+		c.verifyCnt).Int()
+	if err != nil {
+		return err
+	}
+	switch res {
+	case 0:
+		return nil
+	case -1:
+		return ErrCodeSendTooMany
+	default:
+		// 验证码的 key 没有过期时间
+		return ErrUnknownForCode
+	}
+}
+
+// Verify 方法用于验证输入的验证码是否正确
+func (c *CodeRedisCache) Verify(ctx context.Context, biz, phone, inputCode string) (bool, error) {
+	res, err := c.client.Eval(ctx, luaVerifyCode, []string{c.key(biz, phone)}, inputCode).Int()
+	if err != nil {
+		return false, err
+	}
+	switch res {
+	case 0:
+		return true, nil
+	case -1:
+		return false, ErrCodeVerifyTooManyTimes
+	case -2:
+		return false, nil
+	}
+	return false, ErrUnknownForCode
+}
+
+// key 方法用于生成存储的键，和 CodeMemoryCache 保持一致
+func (c *CodeRedisCache) key(biz, phone string) string {
+	return fmt.Sprintf("phone_code:%s:%s", biz, phone)
+}
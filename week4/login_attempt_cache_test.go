@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestLoginAttemptMemoryCache(t *testing.T) {
+	c := NewLoginAttemptMemoryCache()
+	defer c.Close()
+	testLoginAttemptCache(t, c, func(d time.Duration) {
+		time.Sleep(d)
+	})
+}
+
+// TestLoginAttemptMemoryCache_DeleteExpired 过期的记录没有人再访问也会被后台清理掉
+func TestLoginAttemptMemoryCache_DeleteExpired(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	c := NewLoginAttemptMemoryCache(WithLoginAttemptCleanInterval(0),
+		WithLoginAttemptClock(func() time.Time { return now }))
+	_, err := c.IncrFail(ctx, "email:123@qq.com", time.Minute)
+	require.NoError(t, err)
+	_, err = c.IncrFail(ctx, "ip:127.0.0.1", time.Minute)
+	require.NoError(t, err)
+	// 失败次数过期了，但是还在锁定中的不能删
+	require.NoError(t, c.Lock(ctx, "ip:127.0.0.1", now.Add(time.Hour)))
+
+	now = now.Add(2 * time.Minute)
+	c.deleteExpired()
+	assert.Equal(t, 1, len(c.cache))
+	until, err := c.LockedUntil(ctx, "ip:127.0.0.1")
+	require.NoError(t, err)
+	assert.False(t, until.IsZero())
+}
+
+// TestLoginAttemptMemoryCache_MaxEntries 随便编的 key 再多，也只记住最近更新的那些
+func TestLoginAttemptMemoryCache_MaxEntries(t *testing.T) {
+	ctx := context.Background()
+	c := NewLoginAttemptMemoryCache(WithLoginAttemptCleanInterval(0), WithLoginAttemptMaxEntries(10))
+	_, err := c.IncrFail(ctx, "email:123@qq.com", time.Minute)
+	require.NoError(t, err)
+	for i := 0; i < 1000; i++ {
+		_, err = c.IncrFail(ctx, fmt.Sprintf("ip:10.0.%d.%d", i/256, i%256), time.Minute)
+		require.NoError(t, err)
+		if i%5 == 0 {
+			// 一直在失败的账号不会被挤出去
+			_, err = c.IncrFail(ctx, "email:123@qq.com", time.Minute)
+			require.NoError(t, err)
+		}
+	}
+	assert.Equal(t, 10, len(c.cache))
+	assert.Equal(t, 10, c.lru.Len())
+	cnt, err := c.IncrFail(ctx, "email:123@qq.com", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 202, cnt)
+}
+
+func TestLoginAttemptRedisCache(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+	defer client.Close()
+	// miniredis 的过期时间要手动快进，顺便真的等一下，让锁定时间也过去
+	testLoginAttemptCache(t, NewLoginAttemptRedisCache(client), func(d time.Duration) {
+		time.Sleep(d)
+		mr.FastForward(d)
+	})
+}
+
+// testLoginAttemptCache 所有 LoginAttemptCache 的实现都必须通过的测试
+func testLoginAttemptCache(t *testing.T, c LoginAttemptCache, wait func(d time.Duration)) {
+	ctx := context.Background()
+	const window = 100 * time.Millisecond
+
+	for i := 1; i <= 3; i++ {
+		cnt, err := c.IncrFail(ctx, "email:123@qq.com", window)
+		require.NoError(t, err)
+		assert.Equal(t, i, cnt)
+	}
+	// 别的 key 不受影响
+	cnt, err := c.IncrFail(ctx, "ip:127.0.0.1", window)
+	require.NoError(t, err)
+	assert.Equal(t, 1, cnt)
+
+	until, err := c.LockedUntil(ctx, "email:123@qq.com")
+	require.NoError(t, err)
+	assert.True(t, until.IsZero())
+	lockedUntil := time.Now().Add(time.Minute)
+	require.NoError(t, c.Lock(ctx, "email:123@qq.com", lockedUntil))
+	until, err = c.LockedUntil(ctx, "email:123@qq.com")
+	require.NoError(t, err)
+	assert.Equal(t, lockedUntil.UnixMilli(), until.UnixMilli())
+
+	// 解锁之后失败次数也清零了
+	require.NoError(t, c.Reset(ctx, "email:123@qq.com"))
+	until, err = c.LockedUntil(ctx, "email:123@qq.com")
+	require.NoError(t, err)
+	assert.True(t, until.IsZero())
+	cnt, err = c.IncrFail(ctx, "email:123@qq.com", window)
+	require.NoError(t, err)
+	assert.Equal(t, 1, cnt)
+
+	// 过了 window 没有再失败，重新计数；锁定到时间自动解锁
+	require.NoError(t, c.Lock(ctx, "email:123@qq.com", time.Now().Add(window)))
+	wait(2 * window)
+	cnt, err = c.IncrFail(ctx, "email:123@qq.com", window)
+	require.NoError(t, err)
+	assert.Equal(t, 1, cnt)
+	until, err = c.LockedUntil(ctx, "email:123@qq.com")
+	require.NoError(t, err)
+	assert.True(t, until.IsZero())
+}
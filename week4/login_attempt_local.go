@@ -0,0 +1,199 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultLoginAttemptCleanInterval 默认多久清理一次过期的记录
+	defaultLoginAttemptCleanInterval = time.Minute
+	// defaultLoginAttemptMaxEntries 默认最多记住多少个 key，随便编的邮箱和 IP 也不能把内存撑爆
+	defaultLoginAttemptMaxEntries = 100000
+)
+
+type loginAttemptItem struct {
+	key string
+	cnt int
+	// 失败次数什么时候清零
+	expireAt    time.Time
+	lockedUntil time.Time
+}
+
+// LoginAttemptMemoryCache 是 LoginAttemptCache 基于本地内存的实现，开发环境或者单机部署用
+// 失败次数和锁定都过期的记录在下一次访问的时候删掉，后台也会定期清理
+// 超过上限的时候淘汰最久没有更新的记录
+type LoginAttemptMemoryCache struct {
+	cache map[string]*list.Element
+	// lru 最近更新的放在最前面
+	lru *list.List
+	mu  sync.Mutex
+
+	cleanInterval time.Duration
+	maxEntries    int
+	// now 获取当前时间，测试的时候可以替换掉，不用真的等时间过去
+	now func() time.Time
+
+	closeOnce sync.Once
+	closeCh   chan struct{}
+}
+
+// LoginAttemptMemoryCacheOption 用于修改 LoginAttemptMemoryCache 的默认配置
+type LoginAttemptMemoryCacheOption func(c *LoginAttemptMemoryCache)
+
+// WithLoginAttemptCleanInterval 设置后台清理过期记录的周期，小于等于 0 表示不启动后台清理
+func WithLoginAttemptCleanInterval(interval time.Duration) LoginAttemptMemoryCacheOption {
+	return func(c *LoginAttemptMemoryCache) {
+		c.cleanInterval = interval
+	}
+}
+
+// WithLoginAttemptMaxEntries 设置最多记住多少个 key，小于等于 0 表示不限制
+func WithLoginAttemptMaxEntries(n int) LoginAttemptMemoryCacheOption {
+	return func(c *LoginAttemptMemoryCache) {
+		c.maxEntries = n
+	}
+}
+
+// WithLoginAttemptClock 替换获取当前时间的方法，主要是测试用
+func WithLoginAttemptClock(now func() time.Time) LoginAttemptMemoryCacheOption {
+	return func(c *LoginAttemptMemoryCache) {
+		c.now = now
+	}
+}
+
+// NewLoginAttemptMemoryCache 创建一个新的 LoginAttemptMemoryCache 实例
+// 会启动一个后台 goroutine 定期清理过期的记录，不用的时候调用 Close 停掉它
+func NewLoginAttemptMemoryCache(opts ...LoginAttemptMemoryCacheOption) *LoginAttemptMemoryCache {
+	c := &LoginAttemptMemoryCache{
+		cache:         make(map[string]*list.Element),
+		lru:           list.New(),
+		cleanInterval: defaultLoginAttemptCleanInterval,
+		maxEntries:    defaultLoginAttemptMaxEntries,
+		now:           time.Now,
+		closeCh:       make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.cleanInterval > 0 {
+		go c.janitor()
+	}
+	return c
+}
+
+func (c *LoginAttemptMemoryCache) IncrFail(ctx context.Context, key string, window time.Duration) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	item := c.getOrCreate(key, now)
+	if now.After(item.expireAt) {
+		item.cnt = 0
+	}
+	item.cnt++
+	item.expireAt = now.Add(window)
+	return item.cnt, nil
+}
+
+func (c *LoginAttemptMemoryCache) Lock(ctx context.Context, key string, until time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	item := c.getOrCreate(key, c.now())
+	item.lockedUntil = until
+	return nil
+}
+
+func (c *LoginAttemptMemoryCache) LockedUntil(ctx context.Context, key string) (time.Time, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	item := c.get(key, now)
+	if item == nil || !item.lockedUntil.After(now) {
+		return time.Time{}, nil
+	}
+	return item.lockedUntil, nil
+}
+
+func (c *LoginAttemptMemoryCache) Reset(ctx context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.cache[key]; ok {
+		c.remove(elem)
+	}
+	return nil
+}
+
+// Close 停止后台清理，可以重复调用
+func (c *LoginAttemptMemoryCache) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closeCh)
+	})
+	return nil
+}
+
+// get 调用方要持有锁，失败次数和锁定都过期了就删掉
+func (c *LoginAttemptMemoryCache) get(key string, now time.Time) *loginAttemptItem {
+	elem, ok := c.cache[key]
+	if !ok {
+		return nil
+	}
+	item := elem.Value.(*loginAttemptItem)
+	if item.expired(now) {
+		c.remove(elem)
+		return nil
+	}
+	return item
+}
+
+// getOrCreate 调用方要持有锁，要修改的记录挪到最前面，新建的时候超过上限就淘汰最久没有更新的
+func (c *LoginAttemptMemoryCache) getOrCreate(key string, now time.Time) *loginAttemptItem {
+	if item := c.get(key, now); item != nil {
+		c.lru.MoveToFront(c.cache[key])
+		return item
+	}
+	item := &loginAttemptItem{key: key}
+	c.cache[key] = c.lru.PushFront(item)
+	for c.maxEntries > 0 && c.lru.Len() > c.maxEntries {
+		c.remove(c.lru.Back())
+	}
+	return item
+}
+
+func (c *LoginAttemptMemoryCache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.cache, elem.Value.(*loginAttemptItem).key)
+}
+
+// janitor 定期清理过期的记录，避免 map 一直增长
+func (c *LoginAttemptMemoryCache) janitor() {
+	ticker := time.NewTicker(c.cleanInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.deleteExpired()
+		case <-c.closeCh:
+			return
+		}
+	}
+}
+
+// deleteExpired 删除所有失败次数和锁定都过期的记录
+func (c *LoginAttemptMemoryCache) deleteExpired() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	for elem := c.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if elem.Value.(*loginAttemptItem).expired(now) {
+			c.remove(elem)
+		}
+		elem = next
+	}
+}
+
+func (i *loginAttemptItem) expired(now time.Time) bool {
+	return now.After(i.expireAt) && now.After(i.lockedUntil)
+}
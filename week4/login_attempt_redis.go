@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// LoginAttemptRedisCache 是 LoginAttemptCache 基于 Redis 的实现，多个实例共享失败次数
+type LoginAttemptRedisCache struct {
+	client redis.Cmdable
+}
+
+// NewLoginAttemptRedisCache 创建一个新的 LoginAttemptRedisCache 实例
+func NewLoginAttemptRedisCache(client redis.Cmdable) *LoginAttemptRedisCache {
+	return &LoginAttemptRedisCache{
+		client: client,
+	}
+}
+
+func (c *LoginAttemptRedisCache) IncrFail(ctx context.Context, key string, window time.Duration) (int, error) {
+	var incr *redis.IntCmd
+	// 加一和刷新过期时间放在一个事务里面，不会出现没有过期时间的计数
+	_, err := c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, c.failKey(key))
+		pipe.PExpire(ctx, c.failKey(key), window)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(incr.Val()), nil
+}
+
+func (c *LoginAttemptRedisCache) Lock(ctx context.Context, key string, until time.Time) error {
+	ttl := time.Until(until)
+	if ttl <= 0 {
+		return nil
+	}
+	return c.client.Set(ctx, c.lockKey(key), until.UnixMilli(), ttl).Err()
+}
+
+func (c *LoginAttemptRedisCache) LockedUntil(ctx context.Context, key string) (time.Time, error) {
+	val, err := c.client.Get(ctx, c.lockKey(key)).Int64()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMilli(val), nil
+}
+
+func (c *LoginAttemptRedisCache) Reset(ctx context.Context, key string) error {
+	return c.client.Del(ctx, c.failKey(key), c.lockKey(key)).Err()
+}
+
+func (c *LoginAttemptRedisCache) failKey(key string) string {
+	return fmt.Sprintf("login_attempt:%s:fail", key)
+}
+
+func (c *LoginAttemptRedisCache) lockKey(key string) string {
+	return fmt.Sprintf("login_attempt:%s:lock", key)
+}
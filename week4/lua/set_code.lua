@@ -0,0 +1,24 @@
+-- 发送验证码，一个 key 对应一个验证码，另外一个 key 记录剩余的验证次数
+local key = KEYS[1]
+local cntKey = key..":cnt"
+local val = ARGV[1]
+-- 验证码有效期，毫秒
+local expiration = tonumber(ARGV[2])
+-- 剩余有效期小于等于这个值，说明距离上次发送已经超过了最小间隔，毫秒
+local resendTTL = tonumber(ARGV[3])
+-- 可以验证的次数
+local cnt = tonumber(ARGV[4])
+
+local ttl = tonumber(redis.call("pttl", key))
+if ttl == -1 then
+    -- key 存在，但是没有过期时间，说明有人手动设置了这个 key
+    return -2
+elseif ttl == -2 or ttl <= resendTTL then
+    -- 没发过，或者已经过了最小发送间隔
+    redis.call("set", key, val, "px", expiration)
+    redis.call("set", cntKey, cnt, "px", expiration)
+    return 0
+else
+    -- 发送太频繁
+    return -1
+end
@@ -0,0 +1,25 @@
+local key = KEYS[1]
+local cntKey = key..":cnt"
+-- 用户输入的验证码
+local expectedCode = ARGV[1]
+
+local cnt = tonumber(redis.call("get", cntKey))
+local code = redis.call("get", key)
+if cnt == nil or not code then
+    -- 没发过验证码，或者已经过期了
+    return -2
+end
+if cnt <= 0 then
+    -- 次数用完了，或者已经验证成功过
+    return -1
+end
+if code == expectedCode then
+    -- 验证成功，把次数清零，这个验证码就不能再用了
+    -- 用 decrby 是为了保留原来的过期时间
+    redis.call("decrby", cntKey, cnt)
+    return 0
+else
+    -- 输错了，扣掉一次
+    redis.call("decr", cntKey)
+    return -2
+end
@@ -0,0 +1,47 @@
+package cache
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSessionMemoryCache(t *testing.T) {
+	testSessionCache(t, NewSessionMemoryCache())
+}
+
+func TestSessionRedisCache(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+	defer client.Close()
+	testSessionCache(t, NewSessionRedisCache(client))
+}
+
+// testSessionCache 所有 SessionCache 的实现都必须通过的测试
+func testSessionCache(t *testing.T, c SessionCache) {
+	ctx := context.Background()
+	_, err := c.GetRevokedAt(ctx, 123)
+	assert.Equal(t, ErrKeyNotExist, err)
+
+	now := time.Now()
+	assert.NoError(t, c.SetRevokedAt(ctx, 123, now))
+	got, err := c.GetRevokedAt(ctx, 123)
+	assert.NoError(t, err)
+	assert.True(t, now.Equal(got))
+
+	// 再撤销一次以后面的时间为准
+	later := now.Add(time.Minute)
+	assert.NoError(t, c.SetRevokedAt(ctx, 123, later))
+	got, err = c.GetRevokedAt(ctx, 123)
+	assert.NoError(t, err)
+	assert.True(t, later.Equal(got))
+
+	// 别的用户不受影响
+	_, err = c.GetRevokedAt(ctx, 456)
+	assert.Equal(t, ErrKeyNotExist, err)
+}
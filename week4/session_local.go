@@ -0,0 +1,56 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type sessionItem struct {
+	revokedAt time.Time
+	expireAt  time.Time
+}
+
+// SessionMemoryCache 是 SessionCache 基于本地内存的实现，开发环境或者单机部署用
+type SessionMemoryCache struct {
+	cache      map[int64]sessionItem
+	mu         sync.RWMutex
+	expiration time.Duration
+}
+
+// NewSessionMemoryCache 创建一个新的 SessionMemoryCache 实例
+func NewSessionMemoryCache() *SessionMemoryCache {
+	return &SessionMemoryCache{
+		cache:      make(map[int64]sessionItem),
+		expiration: defaultSessionRevokeExpiration,
+	}
+}
+
+func (c *SessionMemoryCache) SetRevokedAt(ctx context.Context, uid int64, t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cache[uid] = sessionItem{
+		revokedAt: t,
+		expireAt:  time.Now().Add(c.expiration),
+	}
+	return nil
+}
+
+func (c *SessionMemoryCache) GetRevokedAt(ctx context.Context, uid int64) (time.Time, error) {
+	c.mu.RLock()
+	item, ok := c.cache[uid]
+	c.mu.RUnlock()
+	if !ok {
+		return time.Time{}, ErrKeyNotExist
+	}
+	if time.Now().After(item.expireAt) {
+		c.mu.Lock()
+		// 拿写锁之前可能已经被别人重新 Set 了，再检查一次
+		if item, ok = c.cache[uid]; ok && time.Now().After(item.expireAt) {
+			delete(c.cache, uid)
+		}
+		c.mu.Unlock()
+		return time.Time{}, ErrKeyNotExist
+	}
+	return item.revokedAt, nil
+}
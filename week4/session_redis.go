@@ -0,0 +1,44 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// 撤销记录保留 7 天，比 session 和 JWT 的有效期都长，过期之后之前登录的 session 自己也过期了
+const defaultSessionRevokeExpiration = 7 * 24 * time.Hour
+
+// SessionRedisCache 是 SessionCache 基于 Redis 的实现，存的是撤销时间的纳秒时间戳
+type SessionRedisCache struct {
+	client     redis.Cmdable
+	expiration time.Duration
+}
+
+// NewSessionRedisCache 创建一个新的 SessionRedisCache 实例
+func NewSessionRedisCache(client redis.Cmdable) *SessionRedisCache {
+	return &SessionRedisCache{
+		client:     client,
+		expiration: defaultSessionRevokeExpiration,
+	}
+}
+
+func (c *SessionRedisCache) SetRevokedAt(ctx context.Context, uid int64, t time.Time) error {
+	return c.client.Set(ctx, c.key(uid), t.UnixNano(), c.expiration).Err()
+}
+
+func (c *SessionRedisCache) GetRevokedAt(ctx context.Context, uid int64) (time.Time, error) {
+	val, err := c.client.Get(ctx, c.key(uid)).Int64()
+	if err == redis.Nil {
+		return time.Time{}, ErrKeyNotExist
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, val), nil
+}
+
+func (c *SessionRedisCache) key(uid int64) string {
+	return fmt.Sprintf("user:session:revoked:%d", uid)
+}
@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"awesomeProject/webook/internal/domain"
+	"context"
+	"errors"
+	"time"
+)
+
+// 定义错误变量，用于表示不同的错误情况
+var (
+	ErrCodeSendTooMany        = errors.New("发送验证码太频繁")
+	ErrCodeVerifyTooManyTimes = errors.New("验证次数太多")
+	ErrUnknownForCode         = errors.New("我也不知道发生什么，反正是和code有关")
+)
+
+const (
+	// 验证码有效期
+	defaultCodeExpiration = 10 * time.Minute
+	// 两次发送之间的最小间隔
+	defaultCodeSendInterval = time.Minute
+	// 一个验证码最多验证几次
+	defaultCodeVerifyCnt = 3
+)
+
+//go:generate mockgen -source=./types.go -package=cachemocks -destination=./mocks/types.mock.go
+
+// CodeCache 接口定义了缓存操作的方法
+// 本地缓存 CodeMemoryCache 和 Redis 的 CodeRedisCache 都要遵守同样的规则：
+// 验证码 10 分钟过期，一分钟内不能重复发送，一个验证码最多验证 3 次
+type CodeCache interface {
+	Set(ctx context.Context, biz, phone, code string) error
+	Verify(ctx context.Context, biz, phone, inputCode string) (bool, error)
+}
+
+// ErrKeyNotExist 缓存里面没有这个 key
+var ErrKeyNotExist = errors.New("key不存在")
+
+// UserCache 缓存用户信息，FindById 先查缓存，查不到再查数据库
+type UserCache interface {
+	Get(ctx context.Context, id int64) (domain.User, error)
+	Set(ctx context.Context, u domain.User) error
+	Delete(ctx context.Context, id int64) error
+}
+
+// SessionCache 记录用户的登录状态在什么时候被撤销，这个时间之前登录的 session 都失效
+// 找回密码、修改密码之后用来让别的设备上的登录失效
+type SessionCache interface {
+	SetRevokedAt(ctx context.Context, uid int64, t time.Time) error
+	// GetRevokedAt 没有撤销过返回 ErrKeyNotExist
+	GetRevokedAt(ctx context.Context, uid int64) (time.Time, error)
+}
+
+// LoginAttemptCache 记录登录失败的次数和锁定时间，key 是邮箱或者 IP 这类标识
+type LoginAttemptCache interface {
+	// IncrFail 失败次数加一，返回加一之后的次数，window 之内没有再失败就清零
+	IncrFail(ctx context.Context, key string, window time.Duration) (int, error)
+	// Lock 锁定到 until，到时间自动解锁
+	Lock(ctx context.Context, key string, until time.Time) error
+	// LockedUntil 锁定到什么时候，没有锁定返回零值
+	LockedUntil(ctx context.Context, key string) (time.Time, error)
+	// Reset 清掉失败次数和锁定
+	Reset(ctx context.Context, key string) error
+}
@@ -0,0 +1,44 @@
+package cache
+
+import (
+	"awesomeProject/webook/internal/domain"
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestUserMemoryCache(t *testing.T) {
+	testUserCache(t, NewUserMemoryCache())
+}
+
+func TestUserRedisCache(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+	defer client.Close()
+	testUserCache(t, NewUserRedisCache(client))
+}
+
+// testUserCache 所有 UserCache 的实现都必须通过的测试
+func testUserCache(t *testing.T, c UserCache) {
+	ctx := context.Background()
+	_, err := c.Get(ctx, 123)
+	assert.Equal(t, ErrKeyNotExist, err)
+
+	u := domain.User{
+		Id:       123,
+		Email:    "123@qq.com",
+		Nickname: "大明",
+	}
+	assert.NoError(t, c.Set(ctx, u))
+	got, err := c.Get(ctx, 123)
+	assert.NoError(t, err)
+	assert.Equal(t, u, got)
+
+	assert.NoError(t, c.Delete(ctx, 123))
+	_, err = c.Get(ctx, 123)
+	assert.Equal(t, ErrKeyNotExist, err)
+}
@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"awesomeProject/webook/internal/domain"
+	"context"
+	"sync"
+	"time"
+)
+
+type userItem struct {
+	u        domain.User
+	expireAt time.Time
+}
+
+// UserMemoryCache 是 UserCache 基于本地内存的实现，开发环境或者单机部署用
+// 过期的用户信息在下一次 Get 的时候删掉
+type UserMemoryCache struct {
+	cache      map[int64]userItem
+	mu         sync.RWMutex
+	expiration time.Duration
+}
+
+// NewUserMemoryCache 创建一个新的 UserMemoryCache 实例
+func NewUserMemoryCache() *UserMemoryCache {
+	return &UserMemoryCache{
+		cache:      make(map[int64]userItem),
+		expiration: defaultUserExpiration,
+	}
+}
+
+func (c *UserMemoryCache) Get(ctx context.Context, id int64) (domain.User, error) {
+	c.mu.RLock()
+	item, ok := c.cache[id]
+	c.mu.RUnlock()
+	if !ok {
+		return domain.User{}, ErrKeyNotExist
+	}
+	if time.Now().After(item.expireAt) {
+		c.mu.Lock()
+		// 拿写锁之前可能已经被别人重新 Set 了，再检查一次
+		if item, ok = c.cache[id]; ok && time.Now().After(item.expireAt) {
+			delete(c.cache, id)
+		}
+		c.mu.Unlock()
+		return domain.User{}, ErrKeyNotExist
+	}
+	return item.u, nil
+}
+
+func (c *UserMemoryCache) Set(ctx context.Context, u domain.User) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cache[u.Id] = userItem{
+		u:        u,
+		expireAt: time.Now().Add(c.expiration),
+	}
+	return nil
+}
+
+func (c *UserMemoryCache) Delete(ctx context.Context, id int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.cache, id)
+	return nil
+}
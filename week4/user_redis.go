@@ -0,0 +1,56 @@
+package cache
+
+import (
+	"awesomeProject/webook/internal/domain"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// 用户信息默认缓存 15 分钟
+const defaultUserExpiration = 15 * time.Minute
+
+// UserRedisCache 是 UserCache 基于 Redis 的实现，用户信息序列化成 JSON 存储
+type UserRedisCache struct {
+	client     redis.Cmdable
+	expiration time.Duration
+}
+
+// NewUserRedisCache 创建一个新的 UserRedisCache 实例
+func NewUserRedisCache(client redis.Cmdable) *UserRedisCache {
+	return &UserRedisCache{
+		client:     client,
+		expiration: defaultUserExpiration,
+	}
+}
+
+func (c *UserRedisCache) Get(ctx context.Context, id int64) (domain.User, error) {
+	val, err := c.client.Get(ctx, c.key(id)).Bytes()
+	if err == redis.Nil {
+		return domain.User{}, ErrKeyNotExist
+	}
+	if err != nil {
+		return domain.User{}, err
+	}
+	var u domain.User
+	err = json.Unmarshal(val, &u)
+	return u, err
+}
+
+func (c *UserRedisCache) Set(ctx context.Context, u domain.User) error {
+	val, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	return c.client.Set(ctx, c.key(u.Id), val, c.expiration).Err()
+}
+
+func (c *UserRedisCache) Delete(ctx context.Context, id int64) error {
+	return c.client.Del(ctx, c.key(id)).Err()
+}
+
+func (c *UserRedisCache) key(id int64) string {
+	return fmt.Sprintf("user:info:%d", id)
+}
@@ -0,0 +1,126 @@
+package web
+
+import (
+	svcmocks "awesomeProject/webook/internal/service/mocks"
+	"bytes"
+	"errors"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminHandler_UnlockUser(t *testing.T) {
+	testCases := []struct {
+		name     string
+		mock     func(ctrl *gomock.Controller) *svcmocks.MockLoginGuardService
+		uid      int64
+		reqBody  string
+		wantCode int
+		wantBody string
+		// JWT 登录的，没有 session
+		jwt bool
+	}{
+		{
+			name: "解锁成功",
+			mock: func(ctrl *gomock.Controller) *svcmocks.MockLoginGuardService {
+				guardsvc := svcmocks.NewMockLoginGuardService(ctrl)
+				guardsvc.EXPECT().Unlock(gomock.Any(), "123@qq.com").Return(nil)
+				return guardsvc
+			},
+			uid:      1,
+			reqBody:  `{"email":"123@qq.com"}`,
+			wantCode: http.StatusOK,
+			wantBody: `{"code":0,"msg":"解锁成功","data":null}`,
+		},
+		{
+			name: "JWT 登录的管理员",
+			mock: func(ctrl *gomock.Controller) *svcmocks.MockLoginGuardService {
+				guardsvc := svcmocks.NewMockLoginGuardService(ctrl)
+				guardsvc.EXPECT().Unlock(gomock.Any(), "123@qq.com").Return(nil)
+				return guardsvc
+			},
+			uid:      1,
+			jwt:      true,
+			reqBody:  `{"email":"123@qq.com"}`,
+			wantCode: http.StatusOK,
+			wantBody: `{"code":0,"msg":"解锁成功","data":null}`,
+		},
+		{
+			name:     "JWT 登录的不是管理员",
+			mock:     svcmocks.NewMockLoginGuardService,
+			uid:      123,
+			jwt:      true,
+			reqBody:  `{"email":"123@qq.com"}`,
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "没有登录",
+			mock:     svcmocks.NewMockLoginGuardService,
+			reqBody:  `{"email":"123@qq.com"}`,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "不是管理员",
+			mock:     svcmocks.NewMockLoginGuardService,
+			uid:      123,
+			reqBody:  `{"email":"123@qq.com"}`,
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "邮箱为空",
+			mock:     svcmocks.NewMockLoginGuardService,
+			uid:      1,
+			reqBody:  `{"email":" "}`,
+			wantCode: http.StatusOK,
+			wantBody: `{"code":4,"msg":"邮箱不能为空","data":null}`,
+		},
+		{
+			name: "解锁出错",
+			mock: func(ctrl *gomock.Controller) *svcmocks.MockLoginGuardService {
+				guardsvc := svcmocks.NewMockLoginGuardService(ctrl)
+				guardsvc.EXPECT().Unlock(gomock.Any(), "123@qq.com").Return(errors.New("redis 错误"))
+				return guardsvc
+			},
+			uid:      1,
+			reqBody:  `{"email":"123@qq.com"}`,
+			wantCode: http.StatusOK,
+			wantBody: `{"code":5,"msg":"系统错误","data":null}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			server := gin.Default()
+			if tc.jwt {
+				server.Use(func(ctx *gin.Context) {
+					ctx.Set(ClaimsKey, &UserClaims{Uid: tc.uid})
+				})
+			} else {
+				server.Use(sessions.Sessions("mysession", cookie.NewStore([]byte("secret"))))
+				if tc.uid > 0 {
+					server.Use(func(ctx *gin.Context) {
+						sessions.Default(ctx).Set("userId", tc.uid)
+					})
+				}
+			}
+			NewAdminHandler(tc.mock(ctrl), []int64{1}).RegisterRoutes(server)
+
+			req := httptest.NewRequest(http.MethodPost, "/admin/users/unlock", bytes.NewBufferString(tc.reqBody))
+			req.Header.Set("Content-Type", "application/json")
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+
+			assert.Equal(t, tc.wantCode, resp.Code)
+			if tc.wantBody != "" {
+				assert.JSONEq(t, tc.wantBody, resp.Body.String())
+			}
+		})
+	}
+}
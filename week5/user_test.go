@@ -2,18 +2,27 @@ package web
 
 import (
 	"awesomeProject/webook/internal/domain"
+	"awesomeProject/webook/internal/repository"
+	"awesomeProject/webook/internal/repository/cache"
 	"awesomeProject/webook/internal/service"
 	svcmocks "awesomeProject/webook/internal/service/mocks"
+	"awesomeProject/webook/internal/service/password"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/mock/gomock"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestUserHandler_SignUp(t *testing.T) {
@@ -99,13 +108,18 @@ func TestUserHandler_SignUp(t *testing.T) {
 			wantBody: "两次输入的密码不一致",
 		},
 		{
-			name: "密码格式不对",
+			name: "密码不符合密码策略",
 			mock: func(ctrl *gomock.Controller) service.UserService {
 				usersvc := svcmocks.NewMockUserService(ctrl)
-				//usersvc.EXPECT().SignUp(gomock.Any(), domain.User{
-				//	Email:    "123@qq.com",
-				//	Password: "Huawei@123",
-				//}).Return(nil)
+				usersvc.EXPECT().SignUp(gomock.Any(), domain.User{
+					Email:    "123@qq.com",
+					Password: "Huawei",
+				}).Return(&password.PolicyError{
+					Violations: []password.Violation{
+						{Rule: password.RuleMinLength, Msg: "密码至少 8 个字符"},
+						{Rule: password.RuleBanned, Msg: "密码太常见了，很容易被猜到"},
+					},
+				})
 				return usersvc
 			},
 			reqBody: `
@@ -116,7 +130,8 @@ func TestUserHandler_SignUp(t *testing.T) {
 }
 `,
 			wantCode: http.StatusOK,
-			wantBody: "密码必须大于8位,包括数字、特殊字符",
+			// 没有通过的规则全部返回
+			wantBody: "密码至少 8 个字符；密码太常见了，很容易被猜到",
 		},
 		{
 			name: "邮箱冲突",
@@ -272,7 +287,7 @@ func TestUserHandler_LoginSMS(t *testing.T) {
 				codesvc := svcmocks.NewMockCodeService(ctrl)
 				usersvc := svcmocks.NewMockUserService(ctrl)
 				codesvc.EXPECT().Verify(gomock.Any(), biz, "11111111", "11").Return(true, nil)
-				usersvc.EXPECT().FindOrCreate(gomock.Any(), "11111111").Return(nil, errors.New("报错"))
+				usersvc.EXPECT().FindOrCreate(gomock.Any(), "11111111").Return(domain.User{}, errors.New("报错"))
 				return usersvc, codesvc
 			},
 			reqBody: `
@@ -294,6 +309,8 @@ func TestUserHandler_LoginSMS(t *testing.T) {
 			ctrl := gomock.NewController(t)
 			defer ctrl.Finish()
 			server := gin.Default()
+			// 登录成功要设置 session
+			server.Use(sessions.Sessions("mysession", cookie.NewStore([]byte("secret"))))
 			h := NewUserHandler(tc.mock(ctrl))
 			h.RegisterRoutes(server)
 
@@ -322,3 +339,878 @@ func TestUserHandler_LoginSMS(t *testing.T) {
 		})
 	}
 }
+
+func TestUserHandler_PatchProfile(t *testing.T) {
+	strPtr := func(s string) *string {
+		return &s
+	}
+	timePtr := func(t time.Time) *time.Time {
+		return &t
+	}
+	// 修改之后返回的个人信息
+	profile := domain.User{
+		Id:       123,
+		Email:    "123@qq.com",
+		Nickname: "大明",
+		Timezone: "UTC",
+		Version:  2,
+		Ctime:    time.UnixMilli(0),
+		Utime:    time.UnixMilli(0),
+	}
+	testCases := []struct {
+		name     string
+		mock     func(ctrl *gomock.Controller) service.UserService
+		reqBody  string
+		ifMatch  string
+		wantCode int
+		wantETag string
+		wantBody string
+	}{
+		{
+			name: "只修改昵称",
+			mock: func(ctrl *gomock.Controller) service.UserService {
+				usersvc := svcmocks.NewMockUserService(ctrl)
+				usersvc.EXPECT().UpdateProfile(gomock.Any(), int64(123), domain.UserProfilePatch{
+					Nickname: strPtr("大明"),
+				}).Return(profile, nil)
+				return usersvc
+			},
+			reqBody:  `{"nickname": "大明"}`,
+			wantCode: http.StatusOK,
+			wantETag: `"2"`,
+			wantBody: `{"code":0,"msg":"修改个人信息成功","data":{"Id":123,"Email":"123@qq.com","EmailVerified":false,
+"Nickname":"大明","Birthday":"","Abstract":"","Timezone":"UTC","Avatar":"","AvatarThumbnails":null,"Version":2,
+"Ctime":"1970-01-01 00:00:00.000","Utime":"1970-01-01 00:00:00.000"}}`,
+		},
+		{
+			name: "清空生日和个人简介，null 表示不修改",
+			mock: func(ctrl *gomock.Controller) service.UserService {
+				usersvc := svcmocks.NewMockUserService(ctrl)
+				usersvc.EXPECT().UpdateProfile(gomock.Any(), int64(123), domain.UserProfilePatch{
+					Birthday: timePtr(time.Time{}),
+					Abstract: strPtr(""),
+				}).Return(profile, nil)
+				return usersvc
+			},
+			reqBody:  `{"birthday": "", "abstract": "", "nickname": null}`,
+			wantCode: http.StatusOK,
+			wantETag: `"2"`,
+			wantBody: `{"code":0,"msg":"修改个人信息成功","data":{"Id":123,"Email":"123@qq.com","EmailVerified":false,
+"Nickname":"大明","Birthday":"","Abstract":"","Timezone":"UTC","Avatar":"","AvatarThumbnails":null,"Version":2,
+"Ctime":"1970-01-01 00:00:00.000","Utime":"1970-01-01 00:00:00.000"}}`,
+		},
+		{
+			name: "修改生日",
+			mock: func(ctrl *gomock.Controller) service.UserService {
+				usersvc := svcmocks.NewMockUserService(ctrl)
+				usersvc.EXPECT().UpdateProfile(gomock.Any(), int64(123), domain.UserProfilePatch{
+					Birthday: timePtr(time.Date(1992, 1, 1, 0, 0, 0, 0, time.UTC)),
+				}).Return(profile, nil)
+				return usersvc
+			},
+			reqBody:  `{"birthday": "1992-01-01"}`,
+			wantCode: http.StatusOK,
+			wantETag: `"2"`,
+			wantBody: `{"code":0,"msg":"修改个人信息成功","data":{"Id":123,"Email":"123@qq.com","EmailVerified":false,
+"Nickname":"大明","Birthday":"","Abstract":"","Timezone":"UTC","Avatar":"","AvatarThumbnails":null,"Version":2,
+"Ctime":"1970-01-01 00:00:00.000","Utime":"1970-01-01 00:00:00.000"}}`,
+		},
+		{
+			name: "昵称太长",
+			mock: func(ctrl *gomock.Controller) service.UserService {
+				return svcmocks.NewMockUserService(ctrl)
+			},
+			reqBody:  `{"nickname": "一二三四五六七八九十"}`,
+			wantCode: http.StatusOK,
+			wantBody: `{"code":4,"msg":"昵称字符串长度小于10，英文字符和中文长度一样","data":null}`,
+		},
+		{
+			name: "生日不合法",
+			mock: func(ctrl *gomock.Controller) service.UserService {
+				return svcmocks.NewMockUserService(ctrl)
+			},
+			reqBody:  `{"birthday": "2023-02-29"}`,
+			wantCode: http.StatusOK,
+			wantBody: `{"code":4,"msg":"生日格式：YYYY-MM-DD，例如 1992-01-01，必须是真实的日期并且不能晚于今天","data":null}`,
+		},
+		{
+			name: "时区不合法",
+			mock: func(ctrl *gomock.Controller) service.UserService {
+				return svcmocks.NewMockUserService(ctrl)
+			},
+			reqBody:  `{"timezone": "Mars/Olympus"}`,
+			wantCode: http.StatusOK,
+			wantBody: `{"code":4,"msg":"时区格式：IANA 时区名，例如 Asia/Shanghai","data":null}`,
+		},
+		{
+			name: "系统错误",
+			mock: func(ctrl *gomock.Controller) service.UserService {
+				usersvc := svcmocks.NewMockUserService(ctrl)
+				usersvc.EXPECT().UpdateProfile(gomock.Any(), int64(123), domain.UserProfilePatch{
+					Timezone: strPtr(""),
+				}).Return(domain.User{}, errors.New("数据库崩了"))
+				return usersvc
+			},
+			reqBody:  `{"timezone": ""}`,
+			wantCode: http.StatusOK,
+			wantBody: `{"code":5,"msg":"系统错误","data":null}`,
+		},
+		{
+			name: "带了 If-Match",
+			mock: func(ctrl *gomock.Controller) service.UserService {
+				usersvc := svcmocks.NewMockUserService(ctrl)
+				usersvc.EXPECT().UpdateProfile(gomock.Any(), int64(123), domain.UserProfilePatch{
+					Nickname: strPtr("大明"),
+					Version:  1,
+				}).Return(profile, nil)
+				return usersvc
+			},
+			reqBody:  `{"nickname": "大明"}`,
+			ifMatch:  `"1"`,
+			wantCode: http.StatusOK,
+			wantETag: `"2"`,
+			wantBody: `{"code":0,"msg":"修改个人信息成功","data":{"Id":123,"Email":"123@qq.com","EmailVerified":false,
+"Nickname":"大明","Birthday":"","Abstract":"","Timezone":"UTC","Avatar":"","AvatarThumbnails":null,"Version":2,
+"Ctime":"1970-01-01 00:00:00.000","Utime":"1970-01-01 00:00:00.000"}}`,
+		},
+		{
+			name: "版本号冲突",
+			mock: func(ctrl *gomock.Controller) service.UserService {
+				usersvc := svcmocks.NewMockUserService(ctrl)
+				usersvc.EXPECT().UpdateProfile(gomock.Any(), int64(123), domain.UserProfilePatch{
+					Nickname: strPtr("大明"),
+					Version:  1,
+				}).Return(domain.User{}, service.ErrProfileConflict)
+				return usersvc
+			},
+			reqBody:  `{"nickname": "大明"}`,
+			ifMatch:  `W/"1"`,
+			wantCode: http.StatusConflict,
+			wantBody: `{"code":4,"msg":"个人信息已经被修改过了，请刷新之后重试","data":null}`,
+		},
+		{
+			name: "If-Match 格式不对",
+			mock: func(ctrl *gomock.Controller) service.UserService {
+				return svcmocks.NewMockUserService(ctrl)
+			},
+			reqBody:  `{"nickname": "大明"}`,
+			ifMatch:  `abc`,
+			wantCode: http.StatusBadRequest,
+			wantBody: `{"code":4,"msg":"If-Match 格式不对","data":null}`,
+		},
+		{
+			name: "用户不存在",
+			mock: func(ctrl *gomock.Controller) service.UserService {
+				usersvc := svcmocks.NewMockUserService(ctrl)
+				usersvc.EXPECT().UpdateProfile(gomock.Any(), int64(123), domain.UserProfilePatch{
+					Nickname: strPtr("大明"),
+				}).Return(domain.User{}, service.ErrInvalidUserNotFund)
+				return usersvc
+			},
+			reqBody:  `{"nickname": "大明"}`,
+			wantCode: http.StatusNotFound,
+			wantBody: `{"code":4,"msg":"没有查询到该用户","data":null}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			server := gin.Default()
+			server.Use(sessions.Sessions("mysession", cookie.NewStore([]byte("secret"))))
+			// 模拟已经登录
+			server.Use(func(ctx *gin.Context) {
+				sessions.Default(ctx).Set("userId", int64(123))
+			})
+			h := NewUserHandler(tc.mock(ctrl), nil)
+			h.RegisterRoutes(server)
+
+			req, err := http.NewRequest(http.MethodPatch,
+				"/users/profile", bytes.NewBuffer([]byte(tc.reqBody)))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+			if tc.ifMatch != "" {
+				req.Header.Set("If-Match", tc.ifMatch)
+			}
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+
+			assert.Equal(t, tc.wantCode, resp.Code)
+			assert.Equal(t, tc.wantETag, resp.Header().Get("ETag"))
+			assert.JSONEq(t, tc.wantBody, resp.Body.String())
+		})
+	}
+}
+
+func TestUserHandler_ProfileHistory(t *testing.T) {
+	testCases := []struct {
+		name     string
+		mock     func(ctrl *gomock.Controller) service.UserService
+		query    string
+		wantBody string
+	}{
+		{
+			name: "查询成功",
+			mock: func(ctrl *gomock.Controller) service.UserService {
+				usersvc := svcmocks.NewMockUserService(ctrl)
+				usersvc.EXPECT().Profile(gomock.Any(), int64(123)).Return(domain.User{
+					Id:       123,
+					Timezone: "UTC",
+				}, nil)
+				usersvc.EXPECT().ProfileHistory(gomock.Any(), int64(123), 2, 2).Return([]domain.ProfileChange{
+					{
+						Id:     5,
+						Uid:    123,
+						Field:  "nickname",
+						Before: "大明",
+						After:  "小明",
+						Actor:  123,
+						Ctime:  time.UnixMilli(0),
+					},
+				}, int64(5), nil)
+				return usersvc
+			},
+			query: "?page=2&page_size=2",
+			wantBody: `{"code":0,"msg":"","data":{"Total":5,"Page":2,"PageSize":2,"Items":[
+{"Field":"nickname","Before":"大明","After":"小明","Actor":123,"Ctime":"1970-01-01 00:00:00.000"}]}}`,
+		},
+		{
+			name: "一页太多了",
+			mock: func(ctrl *gomock.Controller) service.UserService {
+				return svcmocks.NewMockUserService(ctrl)
+			},
+			query:    "?page_size=101",
+			wantBody: `{"code":4,"msg":"分页参数不对","data":null}`,
+		},
+		{
+			name: "系统错误",
+			mock: func(ctrl *gomock.Controller) service.UserService {
+				usersvc := svcmocks.NewMockUserService(ctrl)
+				usersvc.EXPECT().Profile(gomock.Any(), int64(123)).Return(domain.User{Id: 123}, nil)
+				usersvc.EXPECT().ProfileHistory(gomock.Any(), int64(123), 0, 20).
+					Return(nil, int64(0), errors.New("数据库崩了"))
+				return usersvc
+			},
+			wantBody: `{"code":5,"msg":"系统错误","data":null}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			server := gin.Default()
+			server.Use(sessions.Sessions("mysession", cookie.NewStore([]byte("secret"))))
+			// 模拟已经登录
+			server.Use(func(ctx *gin.Context) {
+				sessions.Default(ctx).Set("userId", int64(123))
+			})
+			h := NewUserHandler(tc.mock(ctrl), nil)
+			h.RegisterRoutes(server)
+
+			req, err := http.NewRequest(http.MethodGet, "/users/profile/history"+tc.query, nil)
+			require.NoError(t, err)
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+
+			assert.Equal(t, http.StatusOK, resp.Code)
+			assert.JSONEq(t, tc.wantBody, resp.Body.String())
+		})
+	}
+}
+
+// verifiedUserSvc 已经验证过邮箱的用户
+func verifiedUserSvc(ctrl *gomock.Controller) service.UserService {
+	usersvc := svcmocks.NewMockUserService(ctrl)
+	usersvc.EXPECT().Profile(gomock.Any(), int64(123)).Return(domain.User{
+		Id:            123,
+		Email:         "123@qq.com",
+		EmailVerified: true,
+	}, nil)
+	return usersvc
+}
+
+func TestUserHandler_UploadAvatar(t *testing.T) {
+	testCases := []struct {
+		name     string
+		mock     func(ctrl *gomock.Controller) (service.UserService, service.AvatarService)
+		field    string
+		data     []byte
+		wantCode int
+		wantBody string
+	}{
+		{
+			name: "上传成功",
+			mock: func(ctrl *gomock.Controller) (service.UserService, service.AvatarService) {
+				avatarsvc := svcmocks.NewMockAvatarService(ctrl)
+				avatarsvc.EXPECT().Upload(gomock.Any(), int64(123), []byte("png")).Return(domain.User{
+					Id:       123,
+					Avatar:   "avatars/123/abc.png",
+					Timezone: "UTC",
+					Version:  3,
+					Ctime:    time.UnixMilli(0),
+					Utime:    time.UnixMilli(0),
+				}, nil)
+				avatarsvc.EXPECT().URL("avatars/123/abc.png", 0).Return("/uploads/avatars/123/abc.png")
+				avatarsvc.EXPECT().URL("avatars/123/abc.png", 64).Return("/uploads/avatars/123/abc_64.png")
+				avatarsvc.EXPECT().URL("avatars/123/abc.png", 256).Return("/uploads/avatars/123/abc_256.png")
+				return verifiedUserSvc(ctrl), avatarsvc
+			},
+			field:    "avatar",
+			data:     []byte("png"),
+			wantCode: http.StatusOK,
+			wantBody: `{"code":0,"msg":"上传头像成功","data":{"Id":123,"Email":"","EmailVerified":false,
+"Nickname":"","Birthday":"","Abstract":"","Timezone":"UTC","Avatar":"/uploads/avatars/123/abc.png",
+"AvatarThumbnails":{"64":"/uploads/avatars/123/abc_64.png","256":"/uploads/avatars/123/abc_256.png"},
+"Version":3,"Ctime":"1970-01-01 00:00:00.000","Utime":"1970-01-01 00:00:00.000"}}`,
+		},
+		{
+			name: "没有上传文件",
+			mock: func(ctrl *gomock.Controller) (service.UserService, service.AvatarService) {
+				return verifiedUserSvc(ctrl), svcmocks.NewMockAvatarService(ctrl)
+			},
+			field:    "file",
+			data:     []byte("png"),
+			wantCode: http.StatusOK,
+			wantBody: `{"code":4,"msg":"请上传头像","data":null}`,
+		},
+		{
+			name: "文件太大",
+			mock: func(ctrl *gomock.Controller) (service.UserService, service.AvatarService) {
+				return verifiedUserSvc(ctrl), svcmocks.NewMockAvatarService(ctrl)
+			},
+			field:    "avatar",
+			data:     make([]byte, service.MaxAvatarSize+1),
+			wantCode: http.StatusRequestEntityTooLarge,
+			wantBody: `{"code":4,"msg":"头像不能超过 5MB","data":null}`,
+		},
+		{
+			name: "格式不支持",
+			mock: func(ctrl *gomock.Controller) (service.UserService, service.AvatarService) {
+				avatarsvc := svcmocks.NewMockAvatarService(ctrl)
+				avatarsvc.EXPECT().Upload(gomock.Any(), int64(123), []byte("txt")).
+					Return(domain.User{}, service.ErrAvatarUnsupportedType)
+				return verifiedUserSvc(ctrl), avatarsvc
+			},
+			field:    "avatar",
+			data:     []byte("txt"),
+			wantCode: http.StatusOK,
+			wantBody: `{"code":4,"msg":"头像只支持 JPEG、PNG、GIF 和 WebP 格式","data":null}`,
+		},
+		{
+			name: "邮箱没有验证",
+			mock: func(ctrl *gomock.Controller) (service.UserService, service.AvatarService) {
+				usersvc := svcmocks.NewMockUserService(ctrl)
+				usersvc.EXPECT().Profile(gomock.Any(), int64(123)).Return(domain.User{
+					Id:    123,
+					Email: "123@qq.com",
+				}, nil)
+				return usersvc, svcmocks.NewMockAvatarService(ctrl)
+			},
+			field:    "avatar",
+			data:     []byte("png"),
+			wantCode: http.StatusForbidden,
+			wantBody: `{"code":4,"msg":"请先验证邮箱","data":null}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			server := gin.Default()
+			server.Use(sessions.Sessions("mysession", cookie.NewStore([]byte("secret"))))
+			// 模拟已经登录
+			server.Use(func(ctx *gin.Context) {
+				sessions.Default(ctx).Set("userId", int64(123))
+			})
+			usersvc, avatarsvc := tc.mock(ctrl)
+			h := NewUserHandler(usersvc, nil).WithAvatarService(avatarsvc)
+			h.RegisterRoutes(server)
+
+			var body bytes.Buffer
+			w := multipart.NewWriter(&body)
+			fw, err := w.CreateFormFile(tc.field, "avatar.png")
+			require.NoError(t, err)
+			_, err = fw.Write(tc.data)
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+			req, err := http.NewRequest(http.MethodPost, "/users/avatar", &body)
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", w.FormDataContentType())
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+
+			assert.Equal(t, tc.wantCode, resp.Code)
+			assert.JSONEq(t, tc.wantBody, resp.Body.String())
+		})
+	}
+}
+
+func TestUserHandler_VerifyEmail(t *testing.T) {
+	testCases := []struct {
+		name     string
+		mock     func(ctrl *gomock.Controller) service.EmailVerifyService
+		wantBody string
+	}{
+		{
+			name: "验证成功",
+			mock: func(ctrl *gomock.Controller) service.EmailVerifyService {
+				svc := svcmocks.NewMockEmailVerifyService(ctrl)
+				svc.EXPECT().Verify(gomock.Any(), "abc").Return(nil)
+				return svc
+			},
+			wantBody: `{"code":0,"msg":"邮箱验证成功","data":null}`,
+		},
+		{
+			name: "链接过期",
+			mock: func(ctrl *gomock.Controller) service.EmailVerifyService {
+				svc := svcmocks.NewMockEmailVerifyService(ctrl)
+				svc.EXPECT().Verify(gomock.Any(), "abc").Return(service.ErrInvalidEmailVerifyToken)
+				return svc
+			},
+			wantBody: `{"code":4,"msg":"验证链接无效或者已经过期","data":null}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			server := gin.Default()
+			h := NewUserHandler(nil, nil).WithEmailVerifyService(tc.mock(ctrl))
+			h.RegisterRoutes(server)
+
+			req, err := http.NewRequest(http.MethodGet, "/users/verify_email?token=abc", nil)
+			require.NoError(t, err)
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+
+			assert.Equal(t, http.StatusOK, resp.Code)
+			assert.JSONEq(t, tc.wantBody, resp.Body.String())
+		})
+	}
+}
+
+// weakPasswordErr 没有通过密码策略
+func weakPasswordErr() error {
+	return &password.PolicyError{
+		Violations: []password.Violation{
+			{Rule: password.RuleMinLength, Msg: "密码至少 8 个字符"},
+			{Rule: password.RuleMinEntropy, Msg: "密码强度不够"},
+		},
+	}
+}
+
+const weakPasswordBody = `{"code":4,"msg":"密码至少 8 个字符；密码强度不够","data":{
+"Violations":[{"Rule":"min_length","Msg":"密码至少 8 个字符"},{"Rule":"min_entropy","Msg":"密码强度不够"}],
+"Score":0}}`
+
+func TestUserHandler_ResetPassword(t *testing.T) {
+	testCases := []struct {
+		name     string
+		mock     func(ctrl *gomock.Controller) service.PasswordResetService
+		reqBody  string
+		wantBody string
+	}{
+		{
+			name: "重置成功",
+			mock: func(ctrl *gomock.Controller) service.PasswordResetService {
+				svc := svcmocks.NewMockPasswordResetService(ctrl)
+				svc.EXPECT().Reset(gomock.Any(), "123@qq.com", "123456", "hello#world123").Return(nil)
+				return svc
+			},
+			reqBody:  `{"account":"123@qq.com","code":"123456","password":"hello#world123","confirmPassword":"hello#world123"}`,
+			wantBody: `{"code":0,"msg":"密码重置成功，请重新登录","data":null}`,
+		},
+		{
+			name: "两次密码不一致",
+			mock: func(ctrl *gomock.Controller) service.PasswordResetService {
+				return svcmocks.NewMockPasswordResetService(ctrl)
+			},
+			reqBody:  `{"account":"123@qq.com","code":"123456","password":"hello#world123","confirmPassword":"hello#world1234"}`,
+			wantBody: `{"code":4,"msg":"两次输入的密码不一致","data":null}`,
+		},
+		{
+			name: "密码太简单",
+			mock: func(ctrl *gomock.Controller) service.PasswordResetService {
+				svc := svcmocks.NewMockPasswordResetService(ctrl)
+				svc.EXPECT().Reset(gomock.Any(), "123@qq.com", "123456", "hello").Return(weakPasswordErr())
+				return svc
+			},
+			reqBody:  `{"account":"123@qq.com","code":"123456","password":"hello","confirmPassword":"hello"}`,
+			wantBody: weakPasswordBody,
+		},
+		{
+			name: "验证码有误",
+			mock: func(ctrl *gomock.Controller) service.PasswordResetService {
+				svc := svcmocks.NewMockPasswordResetService(ctrl)
+				svc.EXPECT().Reset(gomock.Any(), "123@qq.com", "123456", "hello#world123").
+					Return(service.ErrInvalidResetCode)
+				return svc
+			},
+			reqBody:  `{"account":"123@qq.com","code":"123456","password":"hello#world123","confirmPassword":"hello#world123"}`,
+			wantBody: `{"code":4,"msg":"验证码有误","data":null}`,
+		},
+		{
+			name: "验证次数太多",
+			mock: func(ctrl *gomock.Controller) service.PasswordResetService {
+				svc := svcmocks.NewMockPasswordResetService(ctrl)
+				svc.EXPECT().Reset(gomock.Any(), "123@qq.com", "123456", "hello#world123").
+					Return(service.ErrCodeVerifyTooManyTimes)
+				return svc
+			},
+			reqBody:  `{"account":"123@qq.com","code":"123456","password":"hello#world123","confirmPassword":"hello#world123"}`,
+			wantBody: `{"code":4,"msg":"验证次数太多，请重新获取验证码","data":null}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			server := gin.Default()
+			h := NewUserHandler(nil, nil).WithPasswordResetService(tc.mock(ctrl))
+			h.RegisterRoutes(server)
+
+			req, err := http.NewRequest(http.MethodPost, "/users/password/reset", bytes.NewBufferString(tc.reqBody))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+
+			assert.Equal(t, http.StatusOK, resp.Code)
+			assert.JSONEq(t, tc.wantBody, resp.Body.String())
+		})
+	}
+}
+
+func TestUserHandler_ChangePassword(t *testing.T) {
+	const reqBody = `{"oldPassword":"hello#world123","password":"hello#world456","confirmPassword":"hello#world456"}`
+
+	testCases := []struct {
+		name     string
+		mock     func(ctrl *gomock.Controller) (service.UserService, service.SessionService)
+		reqBody  string
+		wantBody string
+		// 当前 session 的登录时间有没有刷新
+		wantCookie bool
+	}{
+		{
+			name: "修改成功，只保留当前 session",
+			mock: func(ctrl *gomock.Controller) (service.UserService, service.SessionService) {
+				usersvc := svcmocks.NewMockUserService(ctrl)
+				sessionsvc := svcmocks.NewMockSessionService(ctrl)
+				gomock.InOrder(
+					usersvc.EXPECT().ChangePassword(gomock.Any(), int64(123), "hello#world123", "hello#world456").Return(nil),
+					sessionsvc.EXPECT().Revoke(gomock.Any(), int64(123)).Return(nil),
+				)
+				return usersvc, sessionsvc
+			},
+			reqBody:    reqBody,
+			wantBody:   `{"code":0,"msg":"密码修改成功，其他设备需要重新登录","data":null}`,
+			wantCookie: true,
+		},
+		{
+			name: "原密码不对",
+			mock: func(ctrl *gomock.Controller) (service.UserService, service.SessionService) {
+				usersvc := svcmocks.NewMockUserService(ctrl)
+				usersvc.EXPECT().ChangePassword(gomock.Any(), int64(123), "hello#world123", "hello#world456").
+					Return(service.ErrInvalidUserOrPassword)
+				return usersvc, svcmocks.NewMockSessionService(ctrl)
+			},
+			reqBody:  reqBody,
+			wantBody: `{"code":4,"msg":"原密码不对","data":null}`,
+		},
+		{
+			name: "新密码和原密码一样",
+			mock: func(ctrl *gomock.Controller) (service.UserService, service.SessionService) {
+				return svcmocks.NewMockUserService(ctrl), svcmocks.NewMockSessionService(ctrl)
+			},
+			reqBody:  `{"oldPassword":"hello#world123","password":"hello#world123","confirmPassword":"hello#world123"}`,
+			wantBody: `{"code":4,"msg":"新密码不能和原密码一样","data":null}`,
+		},
+		{
+			name: "新密码太简单",
+			mock: func(ctrl *gomock.Controller) (service.UserService, service.SessionService) {
+				usersvc := svcmocks.NewMockUserService(ctrl)
+				usersvc.EXPECT().ChangePassword(gomock.Any(), int64(123), "hello#world123", "hello").
+					Return(weakPasswordErr())
+				return usersvc, svcmocks.NewMockSessionService(ctrl)
+			},
+			reqBody:  `{"oldPassword":"hello#world123","password":"hello","confirmPassword":"hello"}`,
+			wantBody: weakPasswordBody,
+		},
+		{
+			name: "撤销 session 失败",
+			mock: func(ctrl *gomock.Controller) (service.UserService, service.SessionService) {
+				usersvc := svcmocks.NewMockUserService(ctrl)
+				sessionsvc := svcmocks.NewMockSessionService(ctrl)
+				usersvc.EXPECT().ChangePassword(gomock.Any(), int64(123), "hello#world123", "hello#world456").Return(nil)
+				sessionsvc.EXPECT().Revoke(gomock.Any(), int64(123)).Return(errors.New("redis 错误"))
+				return usersvc, sessionsvc
+			},
+			reqBody:  reqBody,
+			wantBody: `{"code":5,"msg":"系统错误","data":null}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			server := gin.Default()
+			server.Use(sessions.Sessions("mysession", cookie.NewStore([]byte("secret"))))
+			// 模拟已经登录
+			server.Use(func(ctx *gin.Context) {
+				sessions.Default(ctx).Set("userId", int64(123))
+			})
+			usersvc, sessionsvc := tc.mock(ctrl)
+			h := NewUserHandler(usersvc, nil).WithSessionService(sessionsvc)
+			h.RegisterRoutes(server)
+
+			req, err := http.NewRequest(http.MethodPost, "/users/password", bytes.NewBufferString(tc.reqBody))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+
+			assert.Equal(t, http.StatusOK, resp.Code)
+			assert.JSONEq(t, tc.wantBody, resp.Body.String())
+			assert.Equal(t, tc.wantCookie, resp.Header().Get("Set-Cookie") != "")
+		})
+	}
+}
+
+// TestUserHandler_ChangePasswordJWT JWT 登录的修改密码之后换一个新的 token，
+// 老的 token 被撤销，新的 token 还能用
+func TestUserHandler_ChangePasswordJWT(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	usersvc := svcmocks.NewMockUserService(ctrl)
+	usersvc.EXPECT().ChangePassword(gomock.Any(), int64(123), "hello#world123", "hello#world456").Return(nil)
+	sessionsvc := service.NewSessionService(repository.NewSessionRepository(cache.NewSessionMemoryCache()))
+	key := []byte("95osj3fUD7fo0mlYdDbncXz4VD2igvf0")
+	h := NewUserHandler(usersvc, nil).WithJWTKey(key).WithSessionService(sessionsvc).WithJWTLogin()
+
+	oldIssuedAt := jwt.NewNumericDate(time.Now())
+	server := gin.Default()
+	// 模拟 JWT 中间件校验通过，没有 session
+	server.Use(func(ctx *gin.Context) {
+		ctx.Set(ClaimsKey, &UserClaims{
+			RegisteredClaims: jwt.RegisteredClaims{IssuedAt: oldIssuedAt},
+			Uid:              123,
+		})
+	})
+	h.RegisterRoutes(server)
+
+	req := httptest.NewRequest(http.MethodPost, "/users/password",
+		bytes.NewBufferString(`{"oldPassword":"hello#world123","password":"hello#world456","confirmPassword":"hello#world456"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.JSONEq(t, `{"code":0,"msg":"密码修改成功，其他设备需要重新登录","data":null}`, resp.Body.String())
+	assert.Empty(t, resp.Header().Get("Set-Cookie"))
+	claims := &UserClaims{}
+	_, err := jwt.ParseWithClaims(resp.Header().Get("x-jwt-token"), claims, func(token *jwt.Token) (interface{}, error) {
+		return key, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(123), claims.Uid)
+	assert.Equal(t, "Mozilla/5.0", claims.UserAgent)
+
+	// 签发时间只精确到秒，新的 token 也不能被当成撤销之前签发的
+	ok, err := sessionsvc.Valid(context.Background(), 123, claims.IssuedAt.Time)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = sessionsvc.Valid(context.Background(), 123, oldIssuedAt.Time)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+// TestUserHandler_RegisterRoutesJWT JWT 登录的时候 /users/login 签发 token，不设置 session
+func TestUserHandler_RegisterRoutesJWT(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	usersvc := svcmocks.NewMockUserService(ctrl)
+	usersvc.EXPECT().Login(gomock.Any(), "123@qq.com", "hello#world123").Return(domain.User{Id: 123}, nil)
+	server := gin.Default()
+	server.Use(sessions.Sessions("mysession", cookie.NewStore([]byte("secret"))))
+	NewUserHandler(usersvc, nil).WithJWTKey([]byte("95osj3fUD7fo0mlYdDbncXz4VD2igvf0")).
+		WithJWTLogin().RegisterRoutes(server)
+
+	req := httptest.NewRequest(http.MethodPost, "/users/login",
+		bytes.NewBufferString(`{"email":"123@qq.com","password":"hello#world123"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.NotEmpty(t, resp.Header().Get("x-jwt-token"))
+	assert.Empty(t, resp.Header().Get("Set-Cookie"))
+}
+
+// TestUserHandler_ResendVerifyEmailJWT 没有 session 的时候从 JWT 里面取用户 id
+func TestUserHandler_ResendVerifyEmailJWT(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	usersvc := svcmocks.NewMockUserService(ctrl)
+	usersvc.EXPECT().Profile(gomock.Any(), int64(123)).Return(domain.User{Id: 123, Email: "123@qq.com"}, nil)
+	emailsvc := svcmocks.NewMockEmailVerifyService(ctrl)
+	emailsvc.EXPECT().Send(gomock.Any(), "123@qq.com").Return(nil)
+	server := gin.Default()
+	server.Use(func(ctx *gin.Context) {
+		ctx.Set(ClaimsKey, &UserClaims{Uid: 123})
+	})
+	NewUserHandler(usersvc, nil).WithEmailVerifyService(emailsvc).RegisterRoutes(server)
+
+	req := httptest.NewRequest(http.MethodPost, "/users/verify_email/resend", nil)
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.JSONEq(t, `{"code":0,"msg":"验证邮件已经发送","data":null}`, resp.Body.String())
+}
+
+func TestUserHandler_Login(t *testing.T) {
+	const reqBody = `{"email":"123@qq.com","password":"hello#world123"}`
+	// httptest 默认的客户端地址是 192.0.2.1:1234
+	const ip = "192.0.2.1"
+
+	testCases := []struct {
+		name           string
+		mock           func(ctrl *gomock.Controller) (service.UserService, service.LoginGuardService)
+		wantCode       int
+		wantBody       string
+		wantRetryAfter string
+	}{
+		{
+			name: "登录成功，清掉失败记录",
+			mock: func(ctrl *gomock.Controller) (service.UserService, service.LoginGuardService) {
+				usersvc := svcmocks.NewMockUserService(ctrl)
+				guardsvc := svcmocks.NewMockLoginGuardService(ctrl)
+				gomock.InOrder(
+					guardsvc.EXPECT().Check(gomock.Any(), "123@qq.com", ip).Return(nil),
+					usersvc.EXPECT().Login(gomock.Any(), "123@qq.com", "hello#world123").Return(domain.User{Id: 123}, nil),
+					guardsvc.EXPECT().Unlock(gomock.Any(), "123@qq.com").Return(nil),
+				)
+				return usersvc, guardsvc
+			},
+			wantCode: http.StatusOK,
+			wantBody: "登录成功",
+		},
+		{
+			name: "密码不对，记一次失败",
+			mock: func(ctrl *gomock.Controller) (service.UserService, service.LoginGuardService) {
+				usersvc := svcmocks.NewMockUserService(ctrl)
+				guardsvc := svcmocks.NewMockLoginGuardService(ctrl)
+				guardsvc.EXPECT().Check(gomock.Any(), "123@qq.com", ip).Return(nil)
+				usersvc.EXPECT().Login(gomock.Any(), "123@qq.com", "hello#world123").
+					Return(domain.User{}, service.ErrInvalidUserOrPassword)
+				guardsvc.EXPECT().Fail(gomock.Any(), "123@qq.com", ip).Return(nil)
+				return usersvc, guardsvc
+			},
+			wantCode: http.StatusOK,
+			wantBody: "用户名或者密码不对",
+		},
+		{
+			name: "被锁定了，不检查密码",
+			mock: func(ctrl *gomock.Controller) (service.UserService, service.LoginGuardService) {
+				guardsvc := svcmocks.NewMockLoginGuardService(ctrl)
+				guardsvc.EXPECT().Check(gomock.Any(), "123@qq.com", ip).
+					Return(&service.LoginLockedError{RetryAfter: 1500 * time.Millisecond})
+				return svcmocks.NewMockUserService(ctrl), guardsvc
+			},
+			wantCode:       http.StatusTooManyRequests,
+			wantBody:       `{"code":6,"msg":"登录失败次数太多，请 2 秒之后再试","data":{"RetryAfter":2}}`,
+			wantRetryAfter: "2",
+		},
+		{
+			name: "检查锁定出错",
+			mock: func(ctrl *gomock.Controller) (service.UserService, service.LoginGuardService) {
+				guardsvc := svcmocks.NewMockLoginGuardService(ctrl)
+				guardsvc.EXPECT().Check(gomock.Any(), "123@qq.com", ip).Return(errors.New("redis 错误"))
+				return svcmocks.NewMockUserService(ctrl), guardsvc
+			},
+			wantCode: http.StatusOK,
+			wantBody: "系统错误",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			server := gin.Default()
+			server.Use(sessions.Sessions("mysession", cookie.NewStore([]byte("secret"))))
+			usersvc, guardsvc := tc.mock(ctrl)
+			h := NewUserHandler(usersvc, nil).WithLoginGuardService(guardsvc)
+			h.RegisterRoutes(server)
+
+			req := httptest.NewRequest(http.MethodPost, "/users/login", bytes.NewBufferString(reqBody))
+			req.Header.Set("Content-Type", "application/json")
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+
+			assert.Equal(t, tc.wantCode, resp.Code)
+			assert.Equal(t, tc.wantBody, resp.Body.String())
+			assert.Equal(t, tc.wantRetryAfter, resp.Header().Get("Retry-After"))
+		})
+	}
+}
+
+func TestUserHandler_LoginJWT(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	usersvc := svcmocks.NewMockUserService(ctrl)
+	usersvc.EXPECT().Login(gomock.Any(), "123@qq.com", "hello#world123").Return(domain.User{Id: 123}, nil)
+	key := []byte("95osj3fUD7fo0mlYdDbncXz4VD2igvf0")
+	h := NewUserHandler(usersvc, nil).WithJWTKey(key)
+	server := gin.Default()
+	server.POST("/users/login", h.LoginJWT)
+
+	req := httptest.NewRequest(http.MethodPost, "/users/login",
+		bytes.NewBufferString(`{"email":"123@qq.com","password":"hello#world123"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	claims := &UserClaims{}
+	_, err := jwt.ParseWithClaims(resp.Header().Get("x-jwt-token"), claims, func(token *jwt.Token) (interface{}, error) {
+		return key, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(123), claims.Uid)
+	assert.Equal(t, "Mozilla/5.0", claims.UserAgent)
+	assert.WithinDuration(t, time.Now().Add(jwtExpiration), claims.ExpiresAt.Time, 5*time.Second)
+	assert.WithinDuration(t, time.Now(), claims.IssuedAt.Time, 5*time.Second)
+}
+
+func TestUserHandler_ProfileJWT(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	usersvc := svcmocks.NewMockUserService(ctrl)
+	usersvc.EXPECT().Profile(gomock.Any(), int64(123)).Return(domain.User{Id: 123, Email: "123@qq.com"}, nil)
+	server := gin.Default()
+	// 模拟 JWT 中间件校验通过，没有 session
+	server.Use(func(ctx *gin.Context) {
+		ctx.Set(ClaimsKey, &UserClaims{Uid: 123})
+	})
+	NewUserHandler(usersvc, nil).RegisterRoutes(server)
+
+	req := httptest.NewRequest(http.MethodGet, "/users/profile", nil)
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	var vo ProfileVo
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &vo))
+	assert.Equal(t, int64(123), vo.Id)
+	assert.Equal(t, "123@qq.com", vo.Email)
+}